@@ -0,0 +1,259 @@
+package accounts
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	hashIterations = 600_000
+	hashLength     = 32
+	saltLength     = 16
+
+	MaxUsernameLength = 32
+	MinPasswordLength = 8
+)
+
+var (
+	ErrUnknownAccount  = errors.New("unknown account")
+	ErrWrongPassword   = errors.New("wrong password")
+	ErrUsernameTaken   = errors.New("username is already taken")
+	ErrInvalidUsername = fmt.Errorf("usernames must be 1 to %d letters, numbers, ., - or _", MaxUsernameLength)
+	ErrPasswordShort   = fmt.Errorf("passwords must be at least %d characters", MinPasswordLength)
+)
+
+// Account is a registered player. ID never changes so it is what everything
+// else should refer to the player by, Username is just what they log in and
+// show up as
+type Account struct {
+	ID       uuid.UUID
+	Username string
+	Salt     []byte
+	Hash     []byte
+	Created  time.Time
+	// Operator lets the player run admin commands
+	Operator bool
+}
+
+// Store keeps accounts in memory and writes them to a file, if it has one,
+// whenever they change
+type Store struct {
+	path string
+
+	mu       sync.Mutex
+	accounts map[string]*Account // keyed by lower case username
+	// operators are usernames that become operators when they register
+	operators map[string]bool
+}
+
+// NewMemoryStore returns a store that forgets its accounts on restart
+func NewMemoryStore() *Store {
+	return &Store{
+		accounts:  make(map[string]*Account),
+		operators: make(map[string]bool),
+	}
+}
+
+// Open loads the accounts in the file at path, starting empty if it doesn't
+// exist yet
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:      path,
+		accounts:  make(map[string]*Account),
+		operators: make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []*Account
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return nil, fmt.Errorf("reading accounts from %s: %w", path, err)
+	}
+	for _, a := range accounts {
+		s.accounts[strings.ToLower(a.Username)] = a
+	}
+	return s, nil
+}
+
+// Register creates a new account
+func (s *Store) Register(username, password string) (*Account, error) {
+	if !validUsername(username) {
+		return nil, ErrInvalidUsername
+	}
+	// A taken name is the more useful thing to hear about. It is checked
+	// again below as someone could take it while the password is hashed
+	key := strings.ToLower(username)
+	s.mu.Lock()
+	_, taken := s.accounts[key]
+	s.mu.Unlock()
+	if taken {
+		return nil, ErrUsernameTaken
+	}
+	if len(password) < MinPasswordLength {
+		return nil, ErrPasswordShort
+	}
+
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	hash, err := hashPassword(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[key]; ok {
+		return nil, ErrUsernameTaken
+	}
+	a := &Account{
+		ID:       uuid.New(),
+		Username: username,
+		Salt:     salt,
+		Hash:     hash,
+		Created:  time.Now().UTC(),
+		Operator: s.operators[key],
+	}
+	s.accounts[key] = a
+	if err := s.save(); err != nil {
+		delete(s.accounts, key)
+		return nil, err
+	}
+	return a, nil
+}
+
+// SetOperator grants or takes away admin commands for the account. If nobody
+// has registered the username yet it applies to whoever does
+func (s *Store) SetOperator(username string, operator bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(username)
+	a, ok := s.accounts[key]
+	if !ok {
+		s.operators[key] = operator
+		return nil
+	}
+	a.Operator = operator
+	return s.save()
+}
+
+// SetPassword changes the account's password
+func (s *Store) SetPassword(username, password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrPasswordShort
+	}
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	hash, err := hashPassword(password, salt)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.accounts[strings.ToLower(username)]
+	if !ok {
+		return ErrUnknownAccount
+	}
+	a.Salt, a.Hash = salt, hash
+	return s.save()
+}
+
+// Authenticate returns the account if the password is right
+func (s *Store) Authenticate(username, password string) (*Account, error) {
+	s.mu.Lock()
+	a, ok := s.accounts[strings.ToLower(username)]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownAccount
+	}
+
+	hash, err := hashPassword(password, a.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(hash, a.Hash) != 1 {
+		return nil, ErrWrongPassword
+	}
+	return a, nil
+}
+
+// save writes every account to the store's file. Like world saves it goes to a
+// temporary file first so a crash can't lose the accounts. Must be called
+// with mu held
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	accounts := make([]*Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+	data, err := json.MarshalIndent(accounts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, s.path)
+}
+
+func hashPassword(password string, salt []byte) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, password, salt, hashIterations, hashLength)
+}
+
+func validUsername(username string) bool {
+	if len(username) == 0 || len(username) > MaxUsernameLength {
+		return false
+	}
+	for _, r := range username {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
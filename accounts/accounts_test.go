@@ -0,0 +1,124 @@
+package accounts
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegisterAndAuthenticate(t *testing.T) {
+	s := NewMemoryStore()
+	registered, err := s.Register("Alice", "correct horse")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	// Names aren't case sensitive
+	a, err := s.Authenticate("alice", "correct horse")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	if a.ID != registered.ID || a.Username != "Alice" {
+		t.Errorf("got %s %q, want %s %q", a.ID, a.Username, registered.ID, "Alice")
+	}
+
+	if _, err := s.Authenticate("alice", "wrong horse"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("wrong password: got %v, want %v", err, ErrWrongPassword)
+	}
+	if _, err := s.Authenticate("bob", "correct horse"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("unknown account: got %v, want %v", err, ErrUnknownAccount)
+	}
+}
+
+func TestRegisterRejects(t *testing.T) {
+	s := NewMemoryStore()
+	if _, err := s.Register("alice", "correct horse"); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     error
+	}{
+		{"empty name", "", "correct horse", ErrInvalidUsername},
+		{"bad characters", "al ice", "correct horse", ErrInvalidUsername},
+		{"long name", "a23456789012345678901234567890123", "correct horse", ErrInvalidUsername},
+		{"short password", "bob", "short", ErrPasswordShort},
+		{"taken", "ALICE", "correct horse", ErrUsernameTaken},
+		{"taken with short password", "alice", "short", ErrUsernameTaken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Register(tt.username, tt.password); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperatorBeforeRegistering(t *testing.T) {
+	s := NewMemoryStore()
+	if err := s.SetOperator("Admin", true); err != nil {
+		t.Fatalf("set operator: %v", err)
+	}
+	a, err := s.Register("admin", "correct horse")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if !a.Operator {
+		t.Error("account registered after SetOperator isn't an operator")
+	}
+}
+
+func TestStoreReopens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	registered, err := s.Register("alice", "correct horse")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := s.SetOperator("alice", true); err != nil {
+		t.Fatalf("set operator: %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	a, err := reopened.Authenticate("alice", "correct horse")
+	if err != nil {
+		t.Fatalf("authenticate after reopening: %v", err)
+	}
+	if a.ID != registered.ID || !a.Operator {
+		t.Errorf("got %s operator %v, want %s operator true", a.ID, a.Operator, registered.ID)
+	}
+}
+
+func TestSetPassword(t *testing.T) {
+	s := NewMemoryStore()
+	registered, err := s.Register("alice", "correct horse")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := s.SetPassword("Alice", "battery staple"); err != nil {
+		t.Fatalf("set password: %v", err)
+	}
+	if _, err := s.Authenticate("alice", "correct horse"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("old password: got %v, want %v", err, ErrWrongPassword)
+	}
+	if a, err := s.Authenticate("alice", "battery staple"); err != nil || a.ID != registered.ID {
+		t.Errorf("new password: got %v, want the same account", err)
+	}
+
+	if err := s.SetPassword("alice", "short"); !errors.Is(err, ErrPasswordShort) {
+		t.Errorf("short password: got %v, want %v", err, ErrPasswordShort)
+	}
+	if err := s.SetPassword("bob", "battery staple"); !errors.Is(err, ErrUnknownAccount) {
+		t.Errorf("unknown account: got %v, want %v", err, ErrUnknownAccount)
+	}
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"time"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+)
+
+// blocksEvery is how often we can ask the server about the blocks on screen
+// while the view moves. Blocks being claimed and released are pushed to us so
+// a view that stays still is only asked about once
+const blocksEvery = 250 * time.Millisecond
+
+// blockView is what the server last told us about the blocks on screen.
+// Blocks only live on the server so this is all we know about them
+type blockView struct {
+	// overlay is set while the player wants track coloured by block
+	overlay bool
+	byPos   map[world.Pos]*message.BlockState
+	byID    map[string]*message.BlockState
+
+	// min and max are the area last asked about
+	min, max    world.Pos
+	requestedAt time.Time
+	// waiting is set between asking and hearing back, so requests don't pile
+	// up while the connection is down
+	waiting bool
+	// stale is set when track has changed since we last asked
+	stale bool
+}
+
+// refreshBlocks asks the server about the blocks on screen when the view has
+// moved or the track in it has changed
+func (c *Client) refreshBlocks() {
+	if !c.hasFeature(message.CapabilityBlockStates) || c.offline {
+		return
+	}
+	minPos, maxPos := c.blocksArea()
+	if !c.blocks.stale && minPos == c.blocks.min && maxPos == c.blocks.max {
+		return
+	}
+	if c.blocks.waiting || time.Since(c.blocks.requestedAt) < blocksEvery {
+		return
+	}
+	c.blocks.requestedAt = time.Now()
+	sent := c.trySend(outgoingMessage{
+		getBlocksMessage: &message.GetBlocksMessage{Min: minPos, Max: maxPos},
+	})
+	if !sent {
+		return
+	}
+	c.blocks.min, c.blocks.max = minPos, maxPos
+	c.blocks.waiting = true
+	c.blocks.stale = false
+}
+
+// blocksArea is the area to ask about, the view with a tile of margin so
+// signals on its edge can see the block they face. Views too big for the
+// server keep to the middle
+func (c *Client) blocksArea() (world.Pos, world.Pos) {
+	width, height := c.r.ViewSize()
+	minPos := world.Pos{X: c.camPos.X - 1, Y: c.camPos.Y - 1}
+	width, height = width+2, height+2
+	if width > message.MaxBlocksSpan {
+		minPos.X += (width - message.MaxBlocksSpan) / 2
+		width = message.MaxBlocksSpan
+	}
+	if height > message.MaxBlocksSpan {
+		minPos.Y += (height - message.MaxBlocksSpan) / 2
+		height = message.MaxBlocksSpan
+	}
+	return minPos, world.Pos{X: minPos.X + width - 1, Y: minPos.Y + height - 1}
+}
+
+func (c *Client) handleBlocks(msg *message.BlocksMessage) {
+	c.blocks.waiting = false
+	byPos := make(map[world.Pos]*message.BlockState)
+	byID := make(map[string]*message.BlockState, len(msg.Blocks))
+	for i := range msg.Blocks {
+		byID[msg.Blocks[i].ID] = &msg.Blocks[i]
+		for _, pos := range msg.Blocks[i].Tiles {
+			byPos[pos] = &msg.Blocks[i]
+		}
+	}
+	c.blocks.byPos = byPos
+	c.blocks.byID = byID
+}
+
+// handleBlockUpdates changes who holds the blocks we know about. Blocks we
+// don't know about are off screen and will be asked about when they aren't
+func (c *Client) handleBlockUpdates(msg *message.BlockUpdatesMessage) {
+	for _, state := range msg.Blocks {
+		if block, ok := c.blocks.byID[state.ID]; ok {
+			block.OccupiedBy = state.OccupiedBy
+			block.Reserved = state.Reserved
+		}
+	}
+}
+
+// signalChar draws a signal as an arrow the way it faces. It is red while
+// the block past the edge it faces is held by a train and green when it is
+// free, grey until the server has told us which
+func signalChar(pos world.Pos, track *types.Track, blocks map[world.Pos]*message.BlockState) (rune, tcell.Style) {
+	var ch rune
+	var facing types.Dir
+	switch {
+	case track.SignalDir&types.DirNorth != 0:
+		ch, facing = '▲', types.DirNorth
+	case track.SignalDir&types.DirEast != 0:
+		ch, facing = '►', types.DirEast
+	case track.SignalDir&types.DirSouth != 0:
+		ch, facing = '▼', types.DirSouth
+	case track.SignalDir&types.DirWest != 0:
+		ch, facing = '◄', types.DirWest
+	default:
+		ch = '●'
+	}
+
+	colour := tcell.ColorGray
+	if block, ok := blocks[pos.Neighbour(facing)]; facing != types.DirNone && ok {
+		colour = tcell.ColorGreen
+		if block.OccupiedBy != "" {
+			colour = tcell.ColorRed
+		}
+	}
+	return ch, tcell.StyleDefault.Foreground(colour)
+}
+
+var freeBlockColors = []tcell.Color{
+	tcell.ColorDarkGreen,
+	tcell.ColorDarkOliveGreen,
+}
+
+// blockBackground is the overlay colour for the size x size tiles starting at
+// origin. Occupied beats reserved beats free so a held block is never hidden
+// when zoomed out. Neighbouring free blocks are told apart by their shade of
+// green. It returns false if none of the tiles are in a block we know about
+func (r *SimpleRenderer) blockBackground(origin world.Pos, size int, blocks map[world.Pos]*message.BlockState) (tcell.Color, bool) {
+	const (
+		free = iota + 1
+		reserved
+		occupied
+	)
+	worst, colour := 0, tcell.ColorDefault
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			block, ok := blocks[world.Pos{X: origin.X + dx, Y: origin.Y + dy}]
+			if !ok {
+				continue
+			}
+			switch {
+			case block.OccupiedBy != "" && !block.Reserved:
+				if worst < occupied {
+					worst, colour = occupied, tcell.ColorDarkRed
+				}
+			case block.Reserved:
+				if worst < reserved {
+					worst, colour = reserved, tcell.ColorOlive
+				}
+			default:
+				if worst < free {
+					shade := 0
+					if n := len(block.ID); n > 0 {
+						shade = int(block.ID[n-1]) % len(freeBlockColors)
+					}
+					worst, colour = free, freeBlockColors[shade]
+				}
+			}
+		}
+	}
+	return colour, worst != 0
+}
+
+// blockLegend explains the overlay's colours in the info panel
+func blockLegend() []string {
+	return []string{
+		"",
+		"Block overlay (o to hide)",
+		"  green: free",
+		"  yellow: reserved",
+		"  red: occupied",
+	}
+}
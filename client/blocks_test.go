@@ -0,0 +1,147 @@
+package client
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+)
+
+func TestRefreshBlocksWaitsForAnswer(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	c.camPos = world.Pos{X: 10, Y: 10}
+
+	c.refreshBlocks()
+	msgs := sent(c)
+	if len(msgs) != 1 || msgs[0].getBlocksMessage == nil {
+		t.Fatalf("sent %+v, want the blocks on screen asked for", msgs)
+	}
+	if got := msgs[0].getBlocksMessage; got.Min != (world.Pos{X: 9, Y: 9}) || got.Max != (world.Pos{X: 50, Y: 30}) {
+		t.Errorf("asked for %v to %v, want the view and a tile around it", got.Min, got.Max)
+	}
+
+	// Nothing more until the server answers, even once it's due again
+	c.blocks.requestedAt = c.blocks.requestedAt.Add(-blocksEvery)
+	c.refreshBlocks()
+	if msgs := sent(c); len(msgs) != 0 {
+		t.Fatalf("sent %+v while waiting for an answer", msgs)
+	}
+	c.handleIncomingMessage(incomingMessage{blocksMessage: &message.BlocksMessage{}})
+
+	// Changes are pushed to us so the same view isn't asked about again
+	c.blocks.requestedAt = c.blocks.requestedAt.Add(-blocksEvery)
+	c.refreshBlocks()
+	if msgs := sent(c); len(msgs) != 0 {
+		t.Fatalf("sent %+v for a view that didn't move", msgs)
+	}
+	c.camPos.X++
+	c.refreshBlocks()
+	if msgs := sent(c); len(msgs) != 1 {
+		t.Errorf("sent %d messages once the view moved, want another request", len(msgs))
+	}
+	c.handleIncomingMessage(incomingMessage{blocksMessage: &message.BlocksMessage{}})
+	c.blocks.requestedAt = c.blocks.requestedAt.Add(-blocksEvery)
+
+	c.handleIncomingMessage(incomingMessage{trackUpdatesMessage: &message.TrackUpdatesMessage{}})
+	c.refreshBlocks()
+	if msgs := sent(c); len(msgs) != 1 {
+		t.Errorf("sent %d messages once track changed, want another request", len(msgs))
+	}
+}
+
+func TestBlockUpdatesChangeKnownBlocks(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	pos := world.Pos{X: 5, Y: 5}
+	c.handleBlocks(&message.BlocksMessage{Blocks: []message.BlockState{{ID: "a", Tiles: []world.Pos{pos}}}})
+
+	c.handleIncomingMessage(incomingMessage{blockUpdatesMessage: &message.BlockUpdatesMessage{
+		Blocks: []message.BlockState{{ID: "a", OccupiedBy: "train"}, {ID: "off screen", OccupiedBy: "train"}},
+	}})
+	if got := c.blocks.byPos[pos]; got.OccupiedBy != "train" {
+		t.Errorf("got %+v, want the block occupied", got)
+	}
+	if _, ok := c.blocks.byID["off screen"]; ok {
+		t.Error("a block we weren't told about was added")
+	}
+}
+
+func TestSignalColour(t *testing.T) {
+	pos := world.Pos{X: 5, Y: 5}
+	track := &types.Track{Direction: types.DirEast | types.DirWest, HasSignal: true, SignalDir: types.DirEast}
+	ahead := world.Pos{X: 6, Y: 5}
+
+	colour := func(blocks map[world.Pos]*message.BlockState) tcell.Color {
+		ch, style := signalChar(pos, track, blocks)
+		if ch != '►' {
+			t.Errorf("got %q, want an arrow facing east", ch)
+		}
+		fg, _, _ := style.Decompose()
+		return fg
+	}
+	if got := colour(nil); got != tcell.ColorGray {
+		t.Errorf("got %v before the server answered, want grey", got)
+	}
+	if got := colour(map[world.Pos]*message.BlockState{ahead: {ID: "a"}}); got != tcell.ColorGreen {
+		t.Errorf("got %v for a free block, want green", got)
+	}
+	// Only the block the signal faces counts
+	behind := map[world.Pos]*message.BlockState{ahead: {ID: "a"}, pos: {ID: "b", OccupiedBy: "train"}}
+	if got := colour(behind); got != tcell.ColorGreen {
+		t.Errorf("got %v with a train behind, want green", got)
+	}
+	if got := colour(map[world.Pos]*message.BlockState{ahead: {ID: "a", OccupiedBy: "train"}}); got != tcell.ColorRed {
+		t.Errorf("got %v for a held block, want red", got)
+	}
+}
+
+func TestBlockBackgroundShowsWorst(t *testing.T) {
+	r := &SimpleRenderer{w: world.New(10, 10)}
+	free := &message.BlockState{ID: "a"}
+	occupied := &message.BlockState{ID: "b", OccupiedBy: "train"}
+	blocks := map[world.Pos]*message.BlockState{
+		{X: 0, Y: 0}: free,
+		{X: 1, Y: 1}: occupied,
+	}
+
+	if bg, ok := r.blockBackground(world.Pos{}, 1, blocks); !ok || !slices.Contains(freeBlockColors, bg) {
+		t.Errorf("got %v, %v for a free block, want a shade of green", bg, ok)
+	}
+	if bg, ok := r.blockBackground(world.Pos{}, 2, blocks); !ok || bg != tcell.ColorDarkRed {
+		t.Errorf("got %v, %v zoomed out, want the occupied block to win", bg, ok)
+	}
+	if _, ok := r.blockBackground(world.Pos{X: 5, Y: 5}, 2, blocks); ok {
+		t.Error("tiles without blocks got a background")
+	}
+}
+
+func TestBlockOverlayToggle(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	c.handleKey(runeKey('o'))
+	if !c.blocks.overlay || !slices.Contains(c.infoLines(), "Block overlay (o to hide)") {
+		t.Fatal("o didn't turn the overlay and its legend on")
+	}
+	c.handleKey(runeKey('o'))
+	if c.blocks.overlay {
+		t.Error("o didn't turn the overlay off")
+	}
+}
+
+func TestFeaturesTheServerDidntAgreeToStayOff(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	c.serverFeatures = []string{message.CapabilityTrainUpdates}
+
+	c.handleKey(runeKey('o'))
+	if c.blocks.overlay {
+		t.Error("block overlay turned on without block states")
+	}
+	c.handleKey(runeKey('b'))
+	if c.build.tool != toolNone {
+		t.Errorf("got tool %v, want building left off", c.build.tool)
+	}
+	if len(c.chatMessages) != 2 {
+		t.Errorf("got chat %+v, want a note for each", c.chatMessages)
+	}
+}
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"time"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+)
+
+type buildTool int
+
+const (
+	toolNone buildTool = iota
+	// toolInspect only moves the cursor around to look at things
+	toolInspect
+	toolTrack
+	toolDelete
+)
+
+const (
+	inspectToolHelp = " INSPECT  arrows/click: select  drag: pan  b: build tool  esc: leave "
+	trackToolHelp   = " BUILD  space/drag: lay track  x: delete tool  i: inspect  esc: leave "
+	deleteToolHelp  = " DELETE  space/click: remove track  b: build tool  i: inspect  esc: leave "
+
+	// rejectionFlash is how long a tile the server refused stays highlighted
+	rejectionFlash = 2 * time.Second
+)
+
+// buildState is the player's build mode, the cursor and any track being
+// dragged out. The cursor is also used to pick what the info panel shows
+type buildState struct {
+	tool   buildTool
+	cursor world.Pos
+	// path is the tiles dragged out so far, nil when not dragging
+	path []world.Pos
+
+	rejectedPos world.Pos
+	rejectedAt  time.Time
+}
+
+func (b *buildState) active() bool {
+	return b.tool != toolNone
+}
+
+// handleBuildKey deals with keys while in build mode, it returns false if
+// the key isn't one build mode uses
+func (c *Client) handleBuildKey(ev *tcell.EventKey) bool {
+	// A cell at a time, so zoomed out the cursor moves a block of tiles
+	step := c.r.Zoom()
+	switch ev.Key() {
+	case tcell.KeyUp:
+		c.moveCursor(0, step)
+	case tcell.KeyDown:
+		c.moveCursor(0, -step)
+	case tcell.KeyLeft:
+		c.moveCursor(-step, 0)
+	case tcell.KeyRight:
+		c.moveCursor(step, 0)
+	case tcell.KeyEscape:
+		if c.build.path != nil {
+			c.build.path = nil
+		} else {
+			c.build.tool = toolNone
+		}
+	case tcell.KeyEnter:
+		// Inspecting has nothing for enter to do, so it still opens chat
+		if c.build.tool == toolInspect {
+			return false
+		}
+		c.buildAction()
+	case tcell.KeyRune:
+		switch ev.Rune() {
+		case ' ':
+			c.buildAction()
+		case 'b':
+			if c.canBuild() {
+				c.build.tool = toolTrack
+			}
+		case 'i':
+			c.build.tool = toolInspect
+			c.build.path = nil
+		case 'x':
+			if c.canBuild() {
+				c.build.tool = toolDelete
+				c.build.path = nil
+			}
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// canBuild reports whether the server lets us change track, telling the
+// player if it doesn't
+func (c *Client) canBuild() bool {
+	if c.hasFeature(message.CapabilityTrackBuilding) {
+		return true
+	}
+	c.addChatMessage(ChatMessage{Message: "This server doesn't allow building"})
+	return false
+}
+
+// enterCursorMode picks up the tool with the cursor in the middle of the view
+func (c *Client) enterCursorMode(tool buildTool) {
+	width, height := c.r.ViewSize()
+	c.build.tool = tool
+	c.build.cursor = world.Pos{X: c.camPos.X + width/2, Y: c.camPos.Y + height/2}
+	c.build.path = nil
+}
+
+// buildAction is space or enter, starting or finishing a drag with the track
+// tool and removing track with the delete tool
+func (c *Client) buildAction() {
+	switch c.build.tool {
+	case toolTrack:
+		if c.build.path == nil {
+			c.build.path = []world.Pos{c.build.cursor}
+			return
+		}
+		c.commitTrackPath()
+	case toolDelete:
+		c.removeTrack(c.build.cursor)
+	}
+}
+
+// handleBuildMouse lets the player drag out track or click to delete
+func (c *Client) handleBuildMouse(ev *tcell.EventMouse) {
+	x, y := ev.Position()
+	pos, onMap := c.r.ScreenToWorld(c.camPos, x, y)
+	pressed := ev.Buttons()&tcell.Button1 != 0
+
+	if onMap {
+		c.build.cursor = pos
+	}
+
+	switch c.build.tool {
+	case toolTrack:
+		switch {
+		case pressed && c.build.path == nil && onMap:
+			c.build.path = []world.Pos{pos}
+		case pressed && c.build.path != nil && onMap:
+			c.extendPath(pos)
+		case !pressed && c.build.path != nil:
+			// Letting go of the button finishes the drag
+			c.commitTrackPath()
+		}
+	case toolDelete:
+		if pressed && onMap {
+			c.removeTrack(pos)
+		}
+	}
+}
+
+func (c *Client) moveCursor(dx, dy int) {
+	next := world.Pos{X: c.build.cursor.X + dx, Y: c.build.cursor.Y + dy}
+	if !c.w.InBounds(next) {
+		return
+	}
+	c.build.cursor = next
+	if c.build.path != nil {
+		c.extendPath(next)
+	}
+
+	// Drag the camera along so the cursor stays on screen
+	width, height := c.r.ViewSize()
+	camDX, camDY := 0, 0
+	if next.X < c.camPos.X {
+		camDX = next.X - c.camPos.X
+	} else if next.X >= c.camPos.X+width {
+		camDX = next.X - (c.camPos.X + width - 1)
+	}
+	if next.Y < c.camPos.Y {
+		camDY = next.Y - c.camPos.Y
+	} else if next.Y >= c.camPos.Y+height {
+		camDY = next.Y - (c.camPos.Y + height - 1)
+	}
+	if camDX != 0 || camDY != 0 {
+		// The player has taken the camera back from any train it was following
+		c.stopFollowing()
+		c.moveCamera(camDX, camDY)
+	}
+}
+
+// extendPath adds pos to the end of the drag. Going back over the last tile
+// undoes it, and a jump of more than one tile (a fast mouse) is filled in
+// across then up. The path stops growing once it is as long as the server
+// allows
+func (c *Client) extendPath(pos world.Pos) {
+	for {
+		last := c.build.path[len(c.build.path)-1]
+		if last == pos {
+			return
+		}
+
+		step := last
+		switch {
+		case pos.X > last.X:
+			step.X++
+		case pos.X < last.X:
+			step.X--
+		case pos.Y > last.Y:
+			step.Y++
+		default:
+			step.Y--
+		}
+
+		if n := len(c.build.path); n >= 2 && c.build.path[n-2] == step {
+			c.build.path = c.build.path[:n-1]
+		} else if len(c.build.path) < message.MaxTrackPathLength {
+			c.build.path = append(c.build.path, step)
+		} else {
+			return
+		}
+	}
+}
+
+func (c *Client) commitTrackPath() {
+	path := c.build.path
+	c.build.path = nil
+	if len(path) < 2 {
+		return
+	}
+	c.nm.outgoingCh <- outgoingMessage{
+		buildTrackPathMessage: &message.BuildTrackPathMessage{Path: path},
+	}
+}
+
+func (c *Client) removeTrack(pos world.Pos) {
+	if _, ok := c.w.Tracks[pos]; !ok {
+		return
+	}
+	c.nm.outgoingCh <- outgoingMessage{
+		removeTrackMessage: &message.RemoveTrackMessage{Pos: pos},
+	}
+}
+
+func (c *Client) handleBuildRejected(msg *message.BuildRejectedMessage) {
+	c.build.rejectedPos = msg.Pos
+	c.build.rejectedAt = time.Now()
+	c.addChatMessage(ChatMessage{
+		Message: "Can't build here: " + msg.Reason,
+	})
+}
+
+// buildOverlay works out what build mode should draw this frame
+func (c *Client) buildOverlay() BuildOverlay {
+	overlay := BuildOverlay{
+		Active:   c.build.active(),
+		Deleting: c.build.tool == toolDelete,
+		Cursor:   c.build.cursor,
+		Help:     trackToolHelp,
+	}
+	switch c.build.tool {
+	case toolInspect:
+		overlay.Help = inspectToolHelp
+	case toolDelete:
+		overlay.Help = deleteToolHelp
+	}
+	if time.Since(c.build.rejectedAt) < rejectionFlash {
+		rejected := c.build.rejectedPos
+		overlay.Rejected = &rejected
+	}
+
+	if len(c.build.path) >= 2 {
+		plan, err := c.w.PlanTrackPath(c.build.path)
+		if err != nil {
+			overlay.PreviewErr = " " + err.Error() + " "
+			// Still show where the drag went
+			plan = make(map[world.Pos]types.Dir, len(c.build.path))
+			for _, pos := range c.build.path {
+				plan[pos] = types.DirNone
+			}
+		}
+		overlay.Preview = plan
+	}
+	return overlay
+}
@@ -0,0 +1,202 @@
+package client
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+)
+
+// testClient returns a client for w drawn on a simulated screen with a 40x20
+// map view, with the camera in the bottom left corner
+func testClient(t *testing.T, w *world.World) *Client {
+	t.Helper()
+	screen := tcell.NewSimulationScreen("")
+	if err := screen.Init(); err != nil {
+		t.Fatalf("init screen: %v", err)
+	}
+	t.Cleanup(screen.Fini)
+	screen.SetSize(40+infoPanelWidth, 20+chatPanelHeight)
+
+	return &Client{
+		w:              w,
+		chunksLoaded:   make(map[world.Pos]struct{}),
+		chunksPending:  make(map[world.Pos]struct{}),
+		nm:             &clientNetworkManager{outgoingCh: make(chan outgoingMessage, 100)},
+		serverFeatures: message.Capabilities,
+		camSpeed:       2,
+		r:              NewSimpleRenderer(screen, w),
+	}
+}
+
+func runeKey(r rune) *tcell.EventKey {
+	return tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone)
+}
+
+// sent drains everything the client has queued for the server
+func sent(c *Client) []outgoingMessage {
+	var msgs []outgoingMessage
+	for {
+		select {
+		case msg := <-c.nm.outgoingCh:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func sentPaths(c *Client) [][]world.Pos {
+	var paths [][]world.Pos
+	for _, msg := range sent(c) {
+		if msg.buildTrackPathMessage != nil {
+			paths = append(paths, msg.buildTrackPathMessage.Path)
+		}
+	}
+	return paths
+}
+
+func TestBuildModeLaysDraggedPath(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	c.handleKey(runeKey('b'))
+	if !c.build.active() || c.build.cursor != (world.Pos{X: 20, Y: 10}) {
+		t.Fatalf("got tool %d with the cursor at %v, want the track tool in the middle of the view", c.build.tool, c.build.cursor)
+	}
+
+	c.handleKey(runeKey(' '))
+	for _, k := range []tcell.Key{tcell.KeyRight, tcell.KeyRight, tcell.KeyUp} {
+		c.handleKey(key(k))
+	}
+	if paths := sentPaths(c); len(paths) != 0 {
+		t.Fatalf("path sent before the drag finished: %v", paths)
+	}
+	c.handleKey(key(tcell.KeyEnter))
+
+	want := []world.Pos{{X: 20, Y: 10}, {X: 21, Y: 10}, {X: 22, Y: 10}, {X: 22, Y: 11}}
+	paths := sentPaths(c)
+	if len(paths) != 1 || !slices.Equal(paths[0], want) {
+		t.Errorf("sent %v, want %v", paths, want)
+	}
+	if c.build.path != nil || c.chatInput.active {
+		t.Error("enter should finish the drag without opening chat")
+	}
+}
+
+func TestExtendPath(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	c.build.path = []world.Pos{{X: 5, Y: 5}}
+
+	// A jump is filled in across then up
+	c.extendPath(world.Pos{X: 7, Y: 6})
+	want := []world.Pos{{X: 5, Y: 5}, {X: 6, Y: 5}, {X: 7, Y: 5}, {X: 7, Y: 6}}
+	if !slices.Equal(c.build.path, want) {
+		t.Fatalf("got %v, want %v", c.build.path, want)
+	}
+
+	// Going back over the path undoes it
+	c.extendPath(world.Pos{X: 7, Y: 5})
+	c.extendPath(world.Pos{X: 6, Y: 5})
+	if !slices.Equal(c.build.path, want[:2]) {
+		t.Errorf("got %v after backing up, want %v", c.build.path, want[:2])
+	}
+}
+
+func TestExtendPathStopsAtTheLimit(t *testing.T) {
+	c := testClient(t, world.New(1000, 100))
+	c.build.path = []world.Pos{{X: 0, Y: 5}}
+
+	c.extendPath(world.Pos{X: message.MaxTrackPathLength + 10, Y: 5})
+	if len(c.build.path) != message.MaxTrackPathLength {
+		t.Fatalf("path is %d tiles, want it stopped at %d", len(c.build.path), message.MaxTrackPathLength)
+	}
+	// Backing up still works from the end of a full path
+	c.extendPath(world.Pos{X: 10, Y: 5})
+	if len(c.build.path) != 11 {
+		t.Errorf("path is %d tiles after backing up, want 11", len(c.build.path))
+	}
+}
+
+func TestEscapeCancelsThenLeaves(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	c.handleKey(runeKey('b'))
+	c.handleKey(runeKey(' '))
+	c.handleKey(key(tcell.KeyRight))
+
+	c.handleKey(key(tcell.KeyEscape))
+	if c.build.path != nil || !c.build.active() {
+		t.Fatal("first escape should only drop the drag")
+	}
+	c.handleKey(key(tcell.KeyEscape))
+	if c.build.active() {
+		t.Fatal("second escape should leave build mode")
+	}
+	if paths := sentPaths(c); len(paths) != 0 {
+		t.Errorf("cancelled drag sent %v", paths)
+	}
+
+	// Arrows move the camera again
+	c.handleKey(key(tcell.KeyUp))
+	if c.camPos.Y != c.camSpeed {
+		t.Errorf("camera at %v, want it moved up", c.camPos)
+	}
+}
+
+func TestDeleteTool(t *testing.T) {
+	w := world.New(100, 100)
+	track := world.Pos{X: 20, Y: 10}
+	w.AddTrack(track, &types.Track{Direction: types.DirEast | types.DirWest})
+	c := testClient(t, w)
+	c.handleKey(runeKey('b'))
+	c.handleKey(runeKey('x'))
+
+	c.handleKey(runeKey(' '))
+	msgs := sent(c)
+	if len(msgs) != 1 || msgs[0].removeTrackMessage == nil || msgs[0].removeTrackMessage.Pos != track {
+		t.Fatalf("sent %+v, want the track under the cursor removed", msgs)
+	}
+
+	// Nothing to remove next door
+	c.handleKey(key(tcell.KeyRight))
+	c.handleKey(runeKey(' '))
+	if msgs := sent(c); len(msgs) != 0 {
+		t.Errorf("sent %+v for a tile without track", msgs)
+	}
+}
+
+func TestBuildMouseDrag(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	c.enterCursorMode(toolTrack)
+
+	// Screen rows count down from the top of the view
+	c.handleBuildMouse(tcell.NewEventMouse(2, 19, tcell.Button1, tcell.ModNone))
+	c.handleBuildMouse(tcell.NewEventMouse(4, 19, tcell.Button1, tcell.ModNone))
+	c.handleBuildMouse(tcell.NewEventMouse(4, 18, tcell.Button1, tcell.ModNone))
+	if c.build.cursor != (world.Pos{X: 4, Y: 1}) {
+		t.Errorf("cursor at %v, want it under the mouse", c.build.cursor)
+	}
+	c.handleBuildMouse(tcell.NewEventMouse(4, 18, tcell.ButtonNone, tcell.ModNone))
+
+	want := []world.Pos{{X: 2, Y: 0}, {X: 3, Y: 0}, {X: 4, Y: 0}, {X: 4, Y: 1}}
+	paths := sentPaths(c)
+	if len(paths) != 1 || !slices.Equal(paths[0], want) {
+		t.Errorf("sent %v, want %v", paths, want)
+	}
+}
+
+func TestBuildRejectedHighlightsTile(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	c.enterCursorMode(toolTrack)
+	pos := world.Pos{X: 3, Y: 3}
+	c.handleIncomingMessage(incomingMessage{buildRejectedMessage: &message.BuildRejectedMessage{Pos: pos, Reason: "water"}})
+
+	overlay := c.buildOverlay()
+	if overlay.Rejected == nil || *overlay.Rejected != pos {
+		t.Errorf("got rejected tile %v, want %v", overlay.Rejected, pos)
+	}
+	if len(c.chatMessages) != 1 {
+		t.Error("player wasn't told why")
+	}
+}
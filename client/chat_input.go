@@ -0,0 +1,129 @@
+package client
+
+import (
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/gdamore/tcell"
+)
+
+const maxChatHistory = 50
+
+// chatInput is the line the player is typing a chat message into
+type chatInput struct {
+	active bool
+	line   []rune
+	cursor int
+
+	// history holds sent messages, oldest first. historyIdx is the entry being
+	// shown, len(history) while editing a new line, and draft keeps that new
+	// line while the player looks back through history
+	history    []string
+	historyIdx int
+	draft      []rune
+}
+
+func (in *chatInput) open() {
+	in.active = true
+	in.line = in.line[:0]
+	in.cursor = 0
+	in.historyIdx = len(in.history)
+	in.draft = nil
+}
+
+func (in *chatInput) close() {
+	in.active = false
+	in.line = in.line[:0]
+	in.cursor = 0
+}
+
+// handleKey edits the line. It returns the message once the player presses
+// enter, and whether the input has closed
+func (in *chatInput) handleKey(ev *tcell.EventKey) (string, bool) {
+	switch ev.Key() {
+	case tcell.KeyEnter:
+		text := string(in.line)
+		if text != "" {
+			in.remember(text)
+		}
+		in.close()
+		return text, true
+	case tcell.KeyEscape:
+		in.close()
+		return "", true
+
+	case tcell.KeyLeft:
+		in.cursor = max(in.cursor-1, 0)
+	case tcell.KeyRight:
+		in.cursor = min(in.cursor+1, len(in.line))
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		in.cursor = 0
+	case tcell.KeyEnd, tcell.KeyCtrlE:
+		in.cursor = len(in.line)
+
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if in.cursor > 0 {
+			in.line = append(in.line[:in.cursor-1], in.line[in.cursor:]...)
+			in.cursor--
+		}
+	case tcell.KeyDelete:
+		if in.cursor < len(in.line) {
+			in.line = append(in.line[:in.cursor], in.line[in.cursor+1:]...)
+		}
+	case tcell.KeyCtrlU:
+		in.line = append(in.line[:0], in.line[in.cursor:]...)
+		in.cursor = 0
+	case tcell.KeyCtrlW:
+		start := in.cursor
+		for start > 0 && in.line[start-1] == ' ' {
+			start--
+		}
+		for start > 0 && in.line[start-1] != ' ' {
+			start--
+		}
+		in.line = append(in.line[:start], in.line[in.cursor:]...)
+		in.cursor = start
+
+	case tcell.KeyUp:
+		in.showHistory(in.historyIdx - 1)
+	case tcell.KeyDown:
+		in.showHistory(in.historyIdx + 1)
+
+	case tcell.KeyRune:
+		if len(in.line) >= message.MaxChatLength {
+			break
+		}
+		in.line = append(in.line, 0)
+		copy(in.line[in.cursor+1:], in.line[in.cursor:])
+		in.line[in.cursor] = ev.Rune()
+		in.cursor++
+	}
+	return "", false
+}
+
+// showHistory swaps the line for the history entry at idx, going past the
+// newest entry brings back whatever the player was typing
+func (in *chatInput) showHistory(idx int) {
+	if idx < 0 || idx > len(in.history) || idx == in.historyIdx {
+		return
+	}
+	if in.historyIdx == len(in.history) {
+		in.draft = append(in.draft[:0], in.line...)
+	}
+
+	in.historyIdx = idx
+	if idx == len(in.history) {
+		in.line = append(in.line[:0], in.draft...)
+	} else {
+		in.line = append(in.line[:0], []rune(in.history[idx])...)
+	}
+	in.cursor = len(in.line)
+}
+
+func (in *chatInput) remember(text string) {
+	if len(in.history) > 0 && in.history[len(in.history)-1] == text {
+		return
+	}
+	in.history = append(in.history, text)
+	if len(in.history) > maxChatHistory {
+		in.history = in.history[len(in.history)-maxChatHistory:]
+	}
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/gdamore/tcell"
+)
+
+func key(k tcell.Key) *tcell.EventKey {
+	return tcell.NewEventKey(k, 0, tcell.ModNone)
+}
+
+func typeText(in *chatInput, text string) {
+	for _, r := range text {
+		in.handleKey(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+}
+
+func press(in *chatInput, keys ...tcell.Key) {
+	for _, k := range keys {
+		in.handleKey(key(k))
+	}
+}
+
+func checkLine(t *testing.T, in *chatInput, line string, cursor int) {
+	t.Helper()
+	if string(in.line) != line || in.cursor != cursor {
+		t.Errorf("got %q with the cursor at %d, want %q at %d", string(in.line), in.cursor, line, cursor)
+	}
+}
+
+func TestChatInputEditing(t *testing.T) {
+	in := &chatInput{}
+	in.open()
+
+	typeText(in, "helo world")
+	checkLine(t, in, "helo world", 10)
+
+	// Insert in the middle
+	press(in, tcell.KeyHome, tcell.KeyRight, tcell.KeyRight, tcell.KeyRight)
+	typeText(in, "l")
+	checkLine(t, in, "hello world", 4)
+
+	press(in, tcell.KeyBackspace2)
+	checkLine(t, in, "helo world", 3)
+	press(in, tcell.KeyDelete)
+	checkLine(t, in, "hel world", 3)
+
+	// The cursor can't leave the line
+	press(in, tcell.KeyHome, tcell.KeyLeft, tcell.KeyBackspace2)
+	checkLine(t, in, "hel world", 0)
+	press(in, tcell.KeyEnd, tcell.KeyRight, tcell.KeyDelete)
+	checkLine(t, in, "hel world", 9)
+
+	press(in, tcell.KeyCtrlA)
+	checkLine(t, in, "hel world", 0)
+	press(in, tcell.KeyCtrlE)
+	checkLine(t, in, "hel world", 9)
+}
+
+func TestChatInputDeletesWordsAndLines(t *testing.T) {
+	in := &chatInput{}
+	in.open()
+	typeText(in, "one two  three")
+
+	press(in, tcell.KeyCtrlW)
+	checkLine(t, in, "one two  ", 9)
+	// Spaces before the word go with it
+	press(in, tcell.KeyCtrlW)
+	checkLine(t, in, "one ", 4)
+
+	typeText(in, "four")
+	press(in, tcell.KeyLeft, tcell.KeyLeft, tcell.KeyCtrlU)
+	checkLine(t, in, "ur", 0)
+}
+
+func TestChatInputSendsAndCloses(t *testing.T) {
+	in := &chatInput{}
+	in.open()
+	typeText(in, "hi")
+
+	text, closed := in.handleKey(key(tcell.KeyEnter))
+	if text != "hi" || !closed {
+		t.Errorf("enter gave %q closed %v, want %q closed", text, closed, "hi")
+	}
+	if in.active || len(in.line) != 0 {
+		t.Error("input is still open after sending")
+	}
+
+	in.open()
+	typeText(in, "never mind")
+	text, closed = in.handleKey(key(tcell.KeyEscape))
+	if text != "" || !closed {
+		t.Errorf("escape gave %q closed %v, want nothing and closed", text, closed)
+	}
+}
+
+func TestChatInputLengthLimit(t *testing.T) {
+	in := &chatInput{}
+	in.open()
+	typeText(in, strings.Repeat("a", message.MaxChatLength+10))
+	if len(in.line) != message.MaxChatLength {
+		t.Errorf("line is %d runes, want it to stop at %d", len(in.line), message.MaxChatLength)
+	}
+}
+
+func TestChatInputHistory(t *testing.T) {
+	in := &chatInput{}
+	for _, text := range []string{"first", "second", "second"} {
+		in.open()
+		typeText(in, text)
+		press(in, tcell.KeyEnter)
+	}
+	if len(in.history) != 2 {
+		t.Fatalf("history has %d entries, want repeats kept once", len(in.history))
+	}
+
+	in.open()
+	typeText(in, "draft")
+	press(in, tcell.KeyUp)
+	checkLine(t, in, "second", 6)
+	press(in, tcell.KeyUp)
+	checkLine(t, in, "first", 5)
+	// Nothing older
+	press(in, tcell.KeyUp)
+	checkLine(t, in, "first", 5)
+
+	// Coming back down restores what was being typed
+	press(in, tcell.KeyDown, tcell.KeyDown)
+	checkLine(t, in, "draft", 5)
+	press(in, tcell.KeyDown)
+	checkLine(t, in, "draft", 5)
+}
+
+func TestChatInputHistoryIsCapped(t *testing.T) {
+	in := &chatInput{}
+	for i := range maxChatHistory + 5 {
+		in.remember(strings.Repeat("x", i+1))
+	}
+	if len(in.history) != maxChatHistory {
+		t.Fatalf("history has %d entries, want %d", len(in.history), maxChatHistory)
+	}
+	if in.history[0] != strings.Repeat("x", 6) {
+		t.Error("the oldest entries weren't the ones dropped")
+	}
+}
@@ -1,10 +1,14 @@
 package client
 
 import (
+	"fmt"
 	"os/user"
+	"slices"
 	"time"
 
 	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
 	"github.com/danharasymiw/bit-rail/world"
 	"github.com/gdamore/tcell"
 )
@@ -12,15 +16,38 @@ import (
 type Client struct {
 	w            *world.World
 	chunksLoaded map[world.Pos]struct{}
-	chatMessages []ChatMessage
-	username     string
+	// chunksPending have been asked for but haven't arrived yet
+	chunksPending map[world.Pos]struct{}
+	chatMessages  []ChatMessage
+	chatInput     chatInput
+	// chatScroll is how many messages back the chat panel is scrolled
+	chatScroll int
+	username   string
+	password   string
+	register   bool
+	// playerID is our account ID on the server
+	playerID string
 
 	running bool
 	nm      *clientNetworkManager
+	codec   message.Codec
+	// offline is set while the connection is down and we are reconnecting
+	offline bool
+	// serverFeatures are the capabilities the server enabled for us at login
+	serverFeatures []string
 
 	camPos   world.Pos
 	camSpeed int
-	r        Renderer
+	build    buildState
+	follow   followState
+	mouse    mouseState
+	blocks   blockView
+	// minimapActive is set while the arrow keys move around the minimap
+	minimapActive bool
+	// inspected is the server's answer about the tile under the cursor
+	inspected   *message.TileInfoMessage
+	inspectedAt time.Time
+	r           Renderer
 
 	quitCh chan struct{}
 }
@@ -36,10 +63,36 @@ func New() (*Client, chan struct{}) {
 		running:  false,
 		username: usr.Username,
 		camSpeed: 2,
+		codec:    message.BinaryCodec{},
 	}, quitCh
 }
 
+// SetCredentials sets the account to log in with. If register is set the
+// account is created when it doesn't exist yet. An empty username keeps the
+// system username
+func (c *Client) SetCredentials(username, password string, register bool) {
+	if username != "" {
+		c.username = username
+	}
+	c.password = password
+	c.register = register
+}
+
+// Username is the account the client logs in as
+func (c *Client) Username() string {
+	return c.username
+}
+
+// SetCodec changes the wire format used to talk to the server, the JSON codec
+// is handy for debugging
+func (c *Client) SetCodec(codec message.Codec) {
+	c.codec = codec
+}
+
 func (c *Client) Run() error {
+	// Tell whoever launched us that we're done, however that happened
+	defer close(c.quitCh)
+
 	screen, err := tcell.NewScreen()
 	if err != nil {
 		return err
@@ -49,23 +102,24 @@ func (c *Client) Run() error {
 	}
 	defer screen.Fini()
 
-	c.nm, err = newClientNetworkManager()
+	c.nm, err = newClientNetworkManager(c.codec, message.LoginMessage{
+		ProtocolVersion: message.ProtocolVersion,
+		Username:        c.username,
+		Password:        c.password,
+		Register:        c.register,
+		Capabilities:    message.Capabilities,
+	})
 	if err != nil {
 		return err
 	}
 	c.nm.start()
 
-	c.nm.outgoingCh <- outgoingMessage{
-		loginMessage: &message.LoginMessage{
-			Username: c.username,
-		},
-	}
-
 	if err := c.waitForInitialLoad(); err != nil {
 		return err
 	}
 
 	c.r = NewSimpleRenderer(screen, c.w)
+	screen.EnableMouse()
 
 	events := make(chan tcell.Event, 32)
 
@@ -80,6 +134,7 @@ func (c *Client) Run() error {
 	}()
 
 	c.running = true
+	var runErr error
 
 	ticker := time.NewTicker(50 * time.Millisecond)
 	defer ticker.Stop()
@@ -89,68 +144,217 @@ func (c *Client) Run() error {
 		case ev := <-events:
 			switch tev := ev.(type) {
 			case *tcell.EventKey:
-				switch tev.Key() {
-				case tcell.KeyUp:
-					c.moveCamera(0, c.camSpeed)
-				case tcell.KeyDown:
-					c.moveCamera(0, -c.camSpeed)
-				case tcell.KeyLeft:
-					c.moveCamera(-c.camSpeed, 0)
-				case tcell.KeyRight:
-					c.moveCamera(c.camSpeed, 0)
-				}
-				if tev.Rune() == 'q' {
-					c.running = false
-				}
+				c.handleKey(tev)
+			case *tcell.EventMouse:
+				c.handleMouse(tev)
 			case *tcell.EventResize:
 				screen.Sync()
 			}
 
-		case incoming := <-c.nm.incomingCh:
-			c.handleIncomingMessage(incoming)
+		case incoming, ok := <-c.nm.incomingCh:
+			if !ok {
+				runErr = fmt.Errorf("connection to server closed")
+				c.running = false
+				continue
+			}
+			if err := c.handleIncomingMessage(incoming); err != nil {
+				runErr = err
+				c.running = false
+			}
 
 		case <-ticker.C:
-			c.r.Render(c.camPos, c.chatMessages)
+			c.updateFollow()
+			c.refreshInspection()
+			c.refreshBlocks()
+			c.r.Render(Frame{
+				CamPos: c.camPos,
+				Chat: ChatPanel{
+					Messages: c.chatMessages,
+					Scroll:   c.chatScroll,
+					Typing:   c.chatInput.active,
+					Input:    c.chatInput.line,
+					Cursor:   c.chatInput.cursor,
+				},
+				Build:         c.buildOverlay(),
+				Info:          c.infoLines(),
+				Known:         c.chunkKnown,
+				MinimapActive: c.minimapActive,
+				Blocks:        c.blocks.byPos,
+				BlockOverlay:  c.blocks.overlay,
+			})
 		}
 	}
 
-	// Tell whoever launched us that we're done
 	c.nm.close()
-	close(c.quitCh)
-	return nil
+	return runErr
+}
+
+func (c *Client) handleKey(ev *tcell.EventKey) {
+	// Scrolling the chat works whether or not we're typing
+	switch ev.Key() {
+	case tcell.KeyPgUp:
+		c.scrollChat(chatScrollStep)
+		return
+	case tcell.KeyPgDn:
+		c.scrollChat(-chatScrollStep)
+		return
+	}
+
+	if c.chatInput.active {
+		if text, done := c.chatInput.handleKey(ev); done && text != "" {
+			c.sendChat(text)
+		}
+		return
+	}
+
+	if c.minimapActive && c.handleMinimapKey(ev) {
+		return
+	}
+
+	if c.build.active() && c.handleBuildKey(ev) {
+		return
+	}
+
+	// The camera moves the same number of cells whatever the zoom
+	speed := c.camSpeed * c.r.Zoom()
+	switch ev.Key() {
+	case tcell.KeyUp:
+		c.stopFollowing()
+		c.moveCamera(0, speed)
+	case tcell.KeyDown:
+		c.stopFollowing()
+		c.moveCamera(0, -speed)
+	case tcell.KeyLeft:
+		c.stopFollowing()
+		c.moveCamera(-speed, 0)
+	case tcell.KeyRight:
+		c.stopFollowing()
+		c.moveCamera(speed, 0)
+	case tcell.KeyEscape:
+		c.stopFollowing()
+	case tcell.KeyEnter:
+		c.chatInput.open()
+	}
+	switch ev.Rune() {
+	case 'f':
+		c.followNext(1)
+	case 'F':
+		c.followNext(-1)
+	case '+', '=':
+		c.zoomBy(-1)
+	case '-':
+		c.zoomBy(1)
+	case 'b':
+		if c.canBuild() {
+			c.enterCursorMode(toolTrack)
+		}
+	case 'i':
+		c.enterCursorMode(toolInspect)
+	case 'm':
+		c.minimapActive = true
+	case 'o':
+		if !c.hasFeature(message.CapabilityBlockStates) {
+			c.addChatMessage(ChatMessage{Message: "This server doesn't share block states"})
+			break
+		}
+		c.blocks.overlay = !c.blocks.overlay
+	case 'q':
+		c.running = false
+	}
+}
+
+const chatScrollStep = 5
+
+// handleMinimapKey moves the camera a minimap cell at a time, it returns
+// false if the key isn't one the minimap uses
+func (c *Client) handleMinimapKey(ev *tcell.EventKey) bool {
+	step := c.r.MinimapScale()
+	switch ev.Key() {
+	case tcell.KeyUp:
+		c.stopFollowing()
+		c.moveCamera(0, step)
+	case tcell.KeyDown:
+		c.stopFollowing()
+		c.moveCamera(0, -step)
+	case tcell.KeyLeft:
+		c.stopFollowing()
+		c.moveCamera(-step, 0)
+	case tcell.KeyRight:
+		c.stopFollowing()
+		c.moveCamera(step, 0)
+	case tcell.KeyEscape, tcell.KeyEnter:
+		c.minimapActive = false
+	case tcell.KeyRune:
+		if ev.Rune() != 'm' {
+			return false
+		}
+		c.minimapActive = false
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *Client) scrollChat(lines int) {
+	c.chatScroll = max(0, min(c.chatScroll+lines, len(c.chatMessages)-1))
+}
+
+func (c *Client) sendChat(text string) {
+	// The server knows who we are so Author is left for it to fill in
+	c.nm.outgoingCh <- outgoingMessage{
+		chatMessage: &message.ChatMessage{Message: text},
+	}
+	// Jump back to the newest messages so we see our own
+	c.chatScroll = 0
 }
 
 func (c *Client) waitForInitialLoad() error {
 	for incoming := range c.nm.incomingCh {
-		if incoming.initialLoadMessage != nil {
+		switch {
+		case incoming.loginResultMessage != nil:
+			if err := c.handleLoginResult(incoming.loginResultMessage); err != nil {
+				return err
+			}
+
+		case incoming.initialLoadMessage != nil:
 			return c.handleInitialLoad(incoming.initialLoadMessage)
 		}
 	}
+	return fmt.Errorf("connection to server closed before the world was loaded")
+}
+
+func (c *Client) handleLoginResult(result *message.LoginResultMessage) error {
+	if !result.Accepted {
+		return fmt.Errorf("server refused login: %s", result.Reason)
+	}
+	if result.ServerVersion != message.ProtocolVersion {
+		return fmt.Errorf("server speaks protocol version %d but this client speaks %d", result.ServerVersion, message.ProtocolVersion)
+	}
+	c.serverFeatures = result.Features
+	c.playerID = result.PlayerID
 	return nil
 }
 
+// hasFeature reports whether the server enabled the capability at login
+func (c *Client) hasFeature(capability string) bool {
+	return slices.Contains(c.serverFeatures, capability)
+}
+
 func (c *Client) handleInitialLoad(msg *message.InitialLoadMessage) error {
-	c.w = world.New(msg.Width, msg.Height)
-	c.camPos = msg.CameraPos
+	if c.w == nil {
+		c.w = world.New(msg.Width, msg.Height)
+		c.camPos = msg.CameraPos
+	} else {
+		// The server couldn't resume our session so start again, keeping the
+		// camera where the player left it. The renderer holds on to the world
+		// so it is reset in place
+		*c.w = *world.New(msg.Width, msg.Height)
+	}
 	c.chunksLoaded = make(map[world.Pos]struct{})
+	c.chunksPending = make(map[world.Pos]struct{})
 
 	for _, chunk := range msg.Chunks {
-		c.chunksLoaded[chunk.Pos] = struct{}{}
-		for i, tile := range chunk.Tiles {
-			worldY := chunk.Pos.Y*world.ChunkSize + i/world.ChunkSize
-			worldX := chunk.Pos.X*world.ChunkSize + i%world.ChunkSize
-			if worldY < c.w.Height && worldX < c.w.Width {
-				c.w.Tiles[worldY][worldX] = tile
-			}
-		}
-	}
-
-	for _, train := range msg.Trains {
-		c.w.AddTrain(train)
-	}
-	for pos, track := range msg.Tracks {
-		c.w.AddTrack(pos, track)
-		c.w.Tracks[pos] = track
+		c.applyChunk(chunk)
 	}
 
 	// Ensure we have full chunk buffer (in case initial load didn't include all)
@@ -159,47 +363,207 @@ func (c *Client) handleInitialLoad(msg *message.InitialLoadMessage) error {
 	return nil
 }
 
-func (c *Client) handleIncomingMessage(incoming incomingMessage) {
+func (c *Client) handleIncomingMessage(incoming incomingMessage) error {
 	switch {
+	case incoming.disconnected:
+		c.offline = true
+		c.addChatMessage(ChatMessage{Message: "Lost connection to the server, reconnecting..."})
+
+	case incoming.reconnected:
+		c.handleReconnected()
+
+	case incoming.loginResultMessage != nil:
+		return c.handleLoginResult(incoming.loginResultMessage)
+
+	case incoming.initialLoadMessage != nil:
+		return c.handleInitialLoad(incoming.initialLoadMessage)
+
 	case incoming.chatMessage != nil:
-		c.chatMessages = append(c.chatMessages, ChatMessage{
+		c.addChatMessage(ChatMessage{
 			Author:  incoming.chatMessage.Author,
 			Message: incoming.chatMessage.Message,
 		})
 
-		// Keep only last N messages
-		const maxChatMessages = 50
-		if len(c.chatMessages) > maxChatMessages {
-			c.chatMessages = c.chatMessages[len(c.chatMessages)-maxChatMessages:]
-		}
-
 	case incoming.chunksMessage != nil:
 		for _, chunk := range incoming.chunksMessage.Chunks {
-			c.chunksLoaded[chunk.Pos] = struct{}{}
-			for i, tile := range chunk.Tiles {
-				worldY := chunk.Pos.Y*world.ChunkSize + i/world.ChunkSize
-				worldX := chunk.Pos.X*world.ChunkSize + i%world.ChunkSize
-				if worldY < c.w.Height && worldX < c.w.Width {
-					c.w.Tiles[worldY][worldX] = tile
-				}
+			c.applyChunk(chunk)
+		}
+
+	case incoming.trainUpdatesMessage != nil:
+		c.handleTrainUpdates(incoming.trainUpdatesMessage)
+
+	case incoming.trackUpdatesMessage != nil:
+		// New track can split or join blocks, which updates don't cover
+		c.blocks.stale = true
+		for _, change := range incoming.trackUpdatesMessage.Changes {
+			if !c.w.InBounds(change.Pos) {
+				continue
+			}
+			tile := change.Tile
+			c.w.Tiles[change.Pos.Y][change.Pos.X] = &tile
+			if change.Track != nil {
+				c.w.Tracks[change.Pos] = change.Track
+			} else {
+				delete(c.w.Tracks, change.Pos)
 			}
 		}
+
+	case incoming.tileInfoMessage != nil:
+		c.inspected = incoming.tileInfoMessage
+
+	case incoming.blocksMessage != nil:
+		c.handleBlocks(incoming.blocksMessage)
+
+	case incoming.blockUpdatesMessage != nil:
+		c.handleBlockUpdates(incoming.blockUpdatesMessage)
+
+	case incoming.moveCameraMessage != nil:
+		c.stopFollowing()
+		c.centreCamera(incoming.moveCameraMessage.Pos)
+
+	case incoming.buildRejectedMessage != nil:
+		c.handleBuildRejected(incoming.buildRejectedMessage)
+	}
+	return nil
+}
+
+// handleReconnected tidies up after the connection comes back. The server
+// sends the trains in our area when it resumes the session, so we forget the
+// ones we have rather than leave behind any that left while we were away
+func (c *Client) handleReconnected() {
+	c.offline = false
+	c.addChatMessage(ChatMessage{Message: "Reconnected"})
+
+	for _, t := range c.w.Trains {
+		for _, car := range t.Cars {
+			c.w.UnsetOccupied(world.Pos{X: car.X, Y: car.Y})
+		}
+	}
+	c.w.Trains = c.w.Trains[:0]
+
+	// Any question about blocks went with the old connection, and so did the
+	// updates that would have kept the answer current
+	c.blocks.waiting = false
+	c.blocks.stale = true
+
+	// Chunks we asked for may have been lost with the old connection
+	if len(c.chunksPending) > 0 {
+		positions := make([]world.Pos, 0, len(c.chunksPending))
+		for pos := range c.chunksPending {
+			positions = append(positions, pos)
+		}
+		c.requestChunks(positions)
 	}
 }
 
+// applyChunk copies the chunk's tiles, tracks and trains into the local world
+func (c *Client) applyChunk(chunk *world.Chunk) {
+	c.chunksLoaded[chunk.Pos] = struct{}{}
+	delete(c.chunksPending, chunk.Pos)
+	for i, tile := range chunk.Tiles {
+		worldY := chunk.Pos.Y*world.ChunkSize + i/world.ChunkSize
+		worldX := chunk.Pos.X*world.ChunkSize + i%world.ChunkSize
+		if worldY < c.w.Height && worldX < c.w.Width {
+			c.w.Tiles[worldY][worldX] = tile
+			if tile.Type != types.TileTrack {
+				delete(c.w.Tracks, world.Pos{X: worldX, Y: worldY})
+			}
+		}
+	}
+
+	for pos, track := range chunk.Tracks {
+		c.w.Tracks[pos] = track
+	}
+	for _, t := range chunk.Trains {
+		c.upsertTrain(t)
+	}
+}
+
+// upsertTrain adds the train to the local world, replacing any copy we
+// already had
+func (c *Client) upsertTrain(t *trains.Train) {
+	existing := c.w.TrainByID(t.ID)
+	if existing == nil {
+		c.w.AddTrain(t)
+		return
+	}
+
+	for _, car := range existing.Cars {
+		c.w.UnsetOccupied(world.Pos{X: car.X, Y: car.Y})
+	}
+	*existing = *t
+	for _, car := range existing.Cars {
+		c.w.SetOccupied(world.Pos{X: car.X, Y: car.Y})
+	}
+}
+
+func (c *Client) addChatMessage(msg ChatMessage) {
+	c.chatMessages = append(c.chatMessages, msg)
+	// Keep the panel still if the player has scrolled back
+	if c.chatScroll > 0 {
+		c.chatScroll++
+	}
+
+	// Keep only last N messages
+	const maxChatMessages = 500
+	if len(c.chatMessages) > maxChatMessages {
+		c.chatMessages = c.chatMessages[len(c.chatMessages)-maxChatMessages:]
+		c.chatScroll = min(c.chatScroll, len(c.chatMessages)-1)
+	}
+}
+
+func (c *Client) handleTrainUpdates(msg *message.TrainUpdatesMessage) {
+	for _, state := range msg.Trains {
+		t := c.w.TrainByID(state.ID)
+		if t == nil {
+			// The train has come from somewhere we haven't loaded yet
+			t = &trains.Train{ID: state.ID}
+			c.w.Trains = append(c.w.Trains, t)
+		}
+
+		for _, car := range t.Cars {
+			c.w.UnsetOccupied(world.Pos{X: car.X, Y: car.Y})
+		}
+		if len(t.Cars) != len(state.Cars) {
+			t.Cars = make([]*trains.TrainCar, len(state.Cars))
+			for i := range t.Cars {
+				t.Cars[i] = &trains.TrainCar{}
+			}
+		}
+		t.IsMoving = state.IsMoving
+		t.IsReversing = state.IsReversing
+		t.Speed = state.Speed
+		t.Orders = state.Orders
+		t.CurrentOrder = state.CurrentOrder
+		t.RepeatOrders = state.RepeatOrders
+		t.WaitTicks = state.WaitTicks
+		t.Blocked = state.Blocked
+		for i, car := range t.Cars {
+			car.X, car.Y, car.Direction, car.Type = state.Cars[i].X, state.Cars[i].Y, state.Cars[i].Direction, state.Cars[i].Type
+			c.w.SetOccupied(world.Pos{X: car.X, Y: car.Y})
+		}
+	}
+
+	// Trains that have left our area get one last update as they go
+	c.dropTrainsOutsideLoadedChunks()
+}
+
 func (c *Client) moveCamera(xDelta, yDelta int) {
-	width, height := c.r.Screen().Size()
+	width, height := c.r.ViewSize()
 	newCamX := c.camPos.X + xDelta
 	newCamY := c.camPos.Y + yDelta
+	// Checked in this order so a world smaller than the view sits at 0
+	if newCamX > c.w.Width-width {
+		newCamX = c.w.Width - width
+	}
 	if newCamX < 0 {
 		newCamX = 0
-	} else if newCamX > c.w.Width-width {
-		newCamX = c.w.Width - width
+	}
+	if newCamY > c.w.Height-height {
+		newCamY = c.w.Height - height
 	}
 	if newCamY < 0 {
 		newCamY = 0
-	} else if newCamY > c.w.Height-height {
-		newCamY = c.w.Height - height
 	}
 
 	c.camPos.X = newCamX
@@ -208,20 +572,150 @@ func (c *Client) moveCamera(xDelta, yDelta int) {
 	c.loadChunksAroundCamera()
 }
 
-// loadChunksAroundCamera ensures a radius of chunks is loaded around the camera
+// chunkKnown reports whether we have the contents of the chunk at chunkPos
+func (c *Client) chunkKnown(chunkPos world.Pos) bool {
+	_, loaded := c.chunksLoaded[chunkPos]
+	_, pending := c.chunksPending[chunkPos]
+	return loaded && !pending
+}
+
+// centreCamera moves the camera so pos is in the middle of the screen
+func (c *Client) centreCamera(pos world.Pos) {
+	width, height := c.r.ViewSize()
+	c.camPos = world.Pos{X: pos.X - width/2, Y: pos.Y - height/2}
+	// Moving by nothing keeps the camera inside the world
+	c.moveCamera(0, 0)
+}
+
+// zoomBy steps through the zoom levels, keeping the middle of the view where
+// it is
+func (c *Client) zoomBy(levels int) {
+	width, height := c.viewCells()
+	c.zoomAt(levels, width/2, height/2)
+}
+
+// zoomAt steps through the zoom levels, keeping the tile drawn at screen cell
+// x, y where it is
+func (c *Client) zoomAt(levels, x, y int) {
+	current := slices.Index(zoomLevels, c.r.Zoom())
+	next := max(0, min(current+levels, len(zoomLevels)-1))
+	if next == current {
+		return
+	}
+
+	anchor := c.cellToWorld(x, y)
+	c.r.SetZoom(zoomLevels[next])
+	c.placeCamera(anchor, x, y)
+}
+
+// viewCells is how many screen cells the map takes up
+func (c *Client) viewCells() (int, int) {
+	width, height := c.r.ViewSize()
+	return width / c.r.Zoom(), height / c.r.Zoom()
+}
+
+// cellToWorld is the tile drawn at screen cell x, y. Unlike the renderer's
+// ScreenToWorld it doesn't care whether that is on the map
+func (c *Client) cellToWorld(x, y int) world.Pos {
+	_, height := c.viewCells()
+	zoom := c.r.Zoom()
+	return world.Pos{X: c.camPos.X + x*zoom, Y: c.camPos.Y + (height-1-y)*zoom} // Flip Y
+}
+
+// placeCamera moves the camera so pos is drawn at screen cell x, y, or as
+// close as it can get without leaving the world
+func (c *Client) placeCamera(pos world.Pos, x, y int) {
+	_, height := c.viewCells()
+	zoom := c.r.Zoom()
+	c.camPos = world.Pos{X: pos.X - x*zoom, Y: pos.Y - (height-1-y)*zoom} // Flip Y
+	// Moving by nothing keeps the camera inside the world
+	c.moveCamera(0, 0)
+}
+
+// loadChunksAroundCamera ensures a radius of chunks is loaded around the camera,
+// growing it when zoomed out so the whole view is covered
 func (c *Client) loadChunksAroundCamera() {
-	const chunkRadius = 3
+	chunkRadius := 3
+	center := c.camPos
+	if c.r != nil {
+		width, height := c.r.ViewSize()
+		center = world.Pos{X: c.camPos.X + width/2, Y: c.camPos.Y + height/2}
+		chunkRadius = max(chunkRadius, max(width, height)/2/world.ChunkSize+1)
+	}
 
-	centerChunk := world.TileToChunkPos(c.camPos)
+	centerChunk := world.TileToChunkPos(center)
 
 	chunkPositions := make([]world.Pos, 0, (2*chunkRadius+1)*(2*chunkRadius+1))
 	for dx := -chunkRadius; dx <= chunkRadius; dx++ {
 		for dy := -chunkRadius; dy <= chunkRadius; dy++ {
-			chunkPositions = append(chunkPositions, world.Pos{X: centerChunk.X + dx, Y: centerChunk.Y + dy})
+			pos := world.Pos{X: centerChunk.X + dx, Y: centerChunk.Y + dy}
+			if !c.w.InBounds(world.ChunkToTilePos(pos)) {
+				// The server has nothing for us past the edge of the world
+				continue
+			}
+			chunkPositions = append(chunkPositions, pos)
 		}
 	}
 
 	c.getChunks(chunkPositions)
+	c.unloadChunksOutside(centerChunk, chunkRadius+1)
+}
+
+// unloadChunksOutside unsubscribes from chunks more than radius chunks away
+// from center. The extra chunk of slack stops us flapping at the edges
+func (c *Client) unloadChunksOutside(center world.Pos, radius int) {
+	farChunkPositions := make([]world.Pos, 0)
+	for pos := range c.chunksLoaded {
+		if abs(pos.X-center.X) > radius || abs(pos.Y-center.Y) > radius {
+			farChunkPositions = append(farChunkPositions, pos)
+			delete(c.chunksLoaded, pos)
+			delete(c.chunksPending, pos)
+		}
+	}
+	if len(farChunkPositions) == 0 {
+		return
+	}
+
+	c.dropTrainsOutsideLoadedChunks()
+	// Updates for chunks we couldn't unsubscribe from are only wasted, and a
+	// resumed session forgets about them anyway
+	c.trySend(outgoingMessage{
+		unsubscribeChunksMessage: &message.UnsubscribeChunksMessage{
+			Positions: farChunkPositions,
+		},
+	})
+}
+
+// dropTrainsOutsideLoadedChunks forgets trains we no longer get updates for
+func (c *Client) dropTrainsOutsideLoadedChunks() {
+	kept := c.w.Trains[:0]
+	for _, t := range c.w.Trains {
+		if c.trainInLoadedChunk(t) {
+			kept = append(kept, t)
+			continue
+		}
+		for _, car := range t.Cars {
+			c.w.UnsetOccupied(world.Pos{X: car.X, Y: car.Y})
+		}
+	}
+	clear(c.w.Trains[len(kept):])
+	c.w.Trains = kept
+}
+
+func (c *Client) trainInLoadedChunk(t *trains.Train) bool {
+	for _, car := range t.Cars {
+		if _, ok := c.chunksLoaded[world.TileToChunkPos(world.Pos{X: car.X, Y: car.Y})]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
 }
 
 func (c *Client) getChunks(positions []world.Pos) {
@@ -234,14 +728,38 @@ func (c *Client) getChunks(positions []world.Pos) {
 		// Technically we don't have it yet but it's been requested to avoid requesting it again
 		// Might need to make this more intelligent later
 		c.chunksLoaded[coord] = struct{}{}
+		c.chunksPending[coord] = struct{}{}
 	}
-	if len(missingChunkPositions) == 0 {
+	// Pending chunks are asked for again when we reconnect
+	if len(missingChunkPositions) == 0 || c.offline {
 		return
 	}
 
-	c.nm.outgoingCh <- outgoingMessage{
-		getChunksMessage: &message.GetChunksMessage{
-			Positions: missingChunkPositions,
-		},
+	c.requestChunks(missingChunkPositions)
+}
+
+// requestChunks asks the server for chunks already marked pending. If the
+// request can't be queued they are forgotten so they get asked for again
+func (c *Client) requestChunks(positions []world.Pos) {
+	sent := c.trySend(outgoingMessage{
+		getChunksMessage: &message.GetChunksMessage{Positions: positions},
+	})
+	if sent {
+		return
+	}
+	for _, pos := range positions {
+		delete(c.chunksLoaded, pos)
+		delete(c.chunksPending, pos)
+	}
+}
+
+// trySend queues a message for the server without blocking. It returns false
+// if the queue is full, which happens when the connection has been down a while
+func (c *Client) trySend(msg outgoingMessage) bool {
+	select {
+	case c.nm.outgoingCh <- msg:
+		return true
+	default:
+		return false
 	}
 }
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/world"
+)
+
+func TestNothingIsAskedForWhileDisconnected(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	c.enterCursorMode(toolInspect)
+	c.handleIncomingMessage(incomingMessage{disconnected: true})
+
+	c.getChunks([]world.Pos{{X: 1, Y: 1}})
+	c.refreshBlocks()
+	c.refreshInspection()
+	if msgs := sent(c); len(msgs) != 0 {
+		t.Fatalf("sent %+v while disconnected", msgs)
+	}
+
+	c.handleIncomingMessage(incomingMessage{reconnected: true})
+	msgs := sent(c)
+	if len(msgs) != 1 || msgs[0].getChunksMessage == nil || len(msgs[0].getChunksMessage.Positions) != 1 {
+		t.Fatalf("sent %+v after reconnecting, want the chunk asked for", msgs)
+	}
+	c.refreshBlocks()
+	c.refreshInspection()
+	if msgs := sent(c); len(msgs) != 2 {
+		t.Errorf("sent %+v after reconnecting, want the blocks and tile asked about", msgs)
+	}
+}
+
+func TestFullQueueDoesntBlock(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	queue := c.nm.outgoingCh
+	c.nm.outgoingCh = make(chan outgoingMessage)
+
+	c.getChunks([]world.Pos{{X: 1, Y: 1}})
+	c.refreshBlocks()
+	if c.blocks.waiting {
+		t.Error("waiting for blocks that were never asked for")
+	}
+	if _, ok := c.chunksPending[world.Pos{X: 1, Y: 1}]; ok {
+		t.Error("chunk that was never asked for is pending")
+	}
+
+	// Both are asked for again once there is room
+	c.nm.outgoingCh = queue
+	c.blocks.requestedAt = c.blocks.requestedAt.Add(-blocksEvery)
+	c.getChunks([]world.Pos{{X: 1, Y: 1}})
+	c.refreshBlocks()
+	if msgs := sent(c); len(msgs) != 2 || msgs[0].getChunksMessage == nil || msgs[1].getBlocksMessage == nil {
+		t.Errorf("sent %+v, want the chunk and blocks asked for", msgs)
+	}
+}
+
+func TestFailedRunStillSaysItsDone(t *testing.T) {
+	// No terminal to draw on
+	t.Setenv("TERM", "no-such-terminal")
+	c, quitCh := New()
+	if err := c.Run(); err == nil {
+		t.Fatal("ran without a terminal")
+	}
+	select {
+	case <-quitCh:
+	default:
+		t.Error("quit channel still open, a local engine would never stop")
+	}
+}
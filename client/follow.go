@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/google/uuid"
+)
+
+// followState is the train the camera is locked to, if any
+type followState struct {
+	trainID uuid.UUID
+	// centredOn is where the locomotive was when we last moved the camera,
+	// so we only move it again when the train does
+	centredOn world.Pos
+	centred   bool
+}
+
+func (f *followState) active() bool {
+	return f.trainID != uuid.Nil
+}
+
+// followNext locks the camera to the next train along, or the previous one
+// with a negative step. Only trains we have loaded can be picked, /trains and
+// /tp find the others
+func (c *Client) followNext(step int) {
+	if len(c.w.Trains) == 0 {
+		c.addChatMessage(ChatMessage{Message: "There are no trains nearby to follow"})
+		return
+	}
+
+	// Trains come and go from the world as chunks load, sorting them keeps
+	// the order we cycle through the same
+	ids := make([]uuid.UUID, 0, len(c.w.Trains))
+	for _, t := range c.w.Trains {
+		ids = append(ids, t.ID)
+	}
+	slices.SortFunc(ids, func(a, b uuid.UUID) int {
+		return bytes.Compare(a[:], b[:])
+	})
+
+	next := 0
+	if i := slices.Index(ids, c.follow.trainID); i >= 0 {
+		next = (i + step + len(ids)) % len(ids)
+	} else if step < 0 {
+		next = len(ids) - 1
+	}
+	c.follow = followState{trainID: ids[next]}
+	c.updateFollow()
+}
+
+func (c *Client) stopFollowing() {
+	c.follow = followState{}
+}
+
+// updateFollow recentres the camera on the followed train when it moves.
+// The camera looks a little ahead of the train so the chunks it is heading
+// into are loaded before it gets there
+func (c *Client) updateFollow() {
+	if !c.follow.active() {
+		return
+	}
+	t := c.w.TrainByID(c.follow.trainID)
+	if t == nil || len(t.Cars) == 0 {
+		// Out of sight, which happens for a moment when we reconnect
+		return
+	}
+
+	loco := locomotive(t)
+	pos := world.Pos{X: loco.X, Y: loco.Y}
+	if c.follow.centred && c.follow.centredOn == pos {
+		return
+	}
+	c.follow.centredOn, c.follow.centred = pos, true
+
+	width, height := c.r.ViewSize()
+	lookAhead := min(width, height) / 4
+	target := pos
+	if dir := t.TravelDir(); t.IsMoving && dir != types.DirNone {
+		for range lookAhead {
+			target = target.Neighbour(dir)
+		}
+	}
+	c.centreCamera(target)
+}
+
+// locomotive returns the train's first locomotive, or its lead car if it has
+// none
+func locomotive(t *trains.Train) *trains.TrainCar {
+	for _, car := range t.Cars {
+		if car.Type == trains.CarTypeLocomotive {
+			return car
+		}
+	}
+	return t.Lead()
+}
+
+// followInfoLines describes the followed train for the info panel
+func (c *Client) followInfoLines() []string {
+	help := []string{"", "f/F: next/prev train", "esc: stop following"}
+	t := c.w.TrainByID(c.follow.trainID)
+	if t == nil {
+		lines := []string{
+			"Following train " + shortID(c.follow.trainID.String()),
+			"Waiting for it to come into view",
+		}
+		return append(lines, help...)
+	}
+
+	loco := locomotive(t)
+	lines := []string{"Following", ""}
+	lines = append(lines, trainInfoLines(t)...)
+	lines = append(lines, "", fmt.Sprintf("Locomotive at %d,%d", loco.X, loco.Y))
+	return append(lines, help...)
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+	"github.com/google/uuid"
+)
+
+// addTrain puts a stopped eastbound locomotive and cargo car at x, y
+func addTrain(w *world.World, x, y int) *trains.Train {
+	t := &trains.Train{
+		ID: uuid.New(),
+		Cars: []*trains.TrainCar{
+			{X: x, Y: y, Direction: types.DirEast, Type: trains.CarTypeLocomotive},
+			{X: x - 1, Y: y, Direction: types.DirEast, Type: trains.CarTypeCargo},
+		},
+	}
+	w.AddTrain(t)
+	return t
+}
+
+// centredOn reports whether the middle of the view is on pos
+func centredOn(c *Client, pos world.Pos) bool {
+	width, height := c.r.ViewSize()
+	return c.camPos == world.Pos{X: pos.X - width/2, Y: pos.Y - height/2}
+}
+
+func TestFollowCyclesThroughTrains(t *testing.T) {
+	w := world.New(1000, 1000)
+	all := []*trains.Train{addTrain(w, 100, 100), addTrain(w, 150, 150), addTrain(w, 200, 200)}
+	slices.SortFunc(all, func(a, b *trains.Train) int { return bytes.Compare(a.ID[:], b.ID[:]) })
+	c := testClient(t, w)
+
+	for _, want := range []*trains.Train{all[0], all[1], all[2], all[0]} {
+		c.handleKey(runeKey('f'))
+		if c.follow.trainID != want.ID {
+			t.Fatalf("following %s, want %s", c.follow.trainID, want.ID)
+		}
+		if lead := want.Lead(); !centredOn(c, world.Pos{X: lead.X, Y: lead.Y}) {
+			t.Errorf("camera at %v, want it on the stopped train at %d,%d", c.camPos, lead.X, lead.Y)
+		}
+	}
+
+	c.handleKey(runeKey('F'))
+	if c.follow.trainID != all[2].ID {
+		t.Errorf("following %s going back, want %s", c.follow.trainID, all[2].ID)
+	}
+}
+
+func TestFollowWithoutTrains(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	c.handleKey(runeKey('f'))
+	if c.follow.active() || len(c.chatMessages) != 1 {
+		t.Error("player wasn't told there is nothing to follow")
+	}
+}
+
+func TestFollowLooksAhead(t *testing.T) {
+	w := world.New(1000, 1000)
+	train := addTrain(w, 100, 100)
+	c := testClient(t, w)
+	c.followNext(1)
+
+	// Moving east, the camera leads by a quarter of the 20 tile high view
+	train.IsMoving = true
+	train.Cars[0].X, train.Cars[1].X = 101, 100
+	c.updateFollow()
+	if !centredOn(c, world.Pos{X: 106, Y: 100}) {
+		t.Errorf("camera at %v, want it ahead of the train", c.camPos)
+	}
+
+	// It isn't moved again until the train does
+	c.camPos = world.Pos{X: 5, Y: 5}
+	c.updateFollow()
+	if c.camPos != (world.Pos{X: 5, Y: 5}) {
+		t.Error("camera moved while the train stood still")
+	}
+}
+
+func TestFollowStops(t *testing.T) {
+	w := world.New(1000, 1000)
+	addTrain(w, 100, 100)
+
+	for name, stop := range map[string]func(c *Client){
+		"arrow":  func(c *Client) { c.handleKey(key(tcell.KeyLeft)) },
+		"escape": func(c *Client) { c.handleKey(key(tcell.KeyEscape)) },
+		"teleport": func(c *Client) {
+			c.handleIncomingMessage(incomingMessage{moveCameraMessage: &message.MoveCameraMessage{Pos: world.Pos{X: 500, Y: 500}}})
+		},
+	} {
+		c := testClient(t, w)
+		c.followNext(1)
+		stop(c)
+		if c.follow.active() {
+			t.Errorf("%s didn't stop following", name)
+		}
+	}
+}
+
+func TestMinimapOnlyStopsFollowingWhenTheCameraMoves(t *testing.T) {
+	w := world.New(1000, 1000)
+	addTrain(w, 100, 100)
+	c := testClient(t, w)
+	c.followNext(1)
+
+	c.handleKey(runeKey('m'))
+	c.handleKey(key(tcell.KeyEscape))
+	if !c.follow.active() {
+		t.Fatal("opening and closing the minimap stopped following")
+	}
+	c.handleKey(runeKey('m'))
+	c.handleKey(key(tcell.KeyRight))
+	if c.follow.active() {
+		t.Error("moving the camera from the minimap didn't stop following")
+	}
+}
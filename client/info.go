@@ -0,0 +1,189 @@
+package client
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/world"
+)
+
+// inspectEvery is how often we ask the server about the tile under the cursor
+// while it sits still, block occupants change as trains move
+const inspectEvery = time.Second
+
+// refreshInspection asks the server for the details of the tile under the
+// cursor when the cursor moves, and every so often while it doesn't
+func (c *Client) refreshInspection() {
+	if !c.build.active() || !c.hasFeature(message.CapabilityTileInspect) || c.offline {
+		return
+	}
+	cursor := c.build.cursor
+	if c.inspected != nil && c.inspected.Pos == cursor && time.Since(c.inspectedAt) < inspectEvery {
+		return
+	}
+	if time.Since(c.inspectedAt) < inspectEvery/10 {
+		// Don't flood the server while the cursor is flying about
+		return
+	}
+
+	c.inspectedAt = time.Now()
+	c.trySend(outgoingMessage{
+		inspectTileMessage: &message.InspectTileMessage{Pos: cursor},
+	})
+}
+
+// infoLines describes whatever is under the cursor for the info panel
+func (c *Client) infoLines() []string {
+	lines := c.selectionLines()
+	if c.blocks.overlay {
+		lines = append(lines, blockLegend()...)
+	}
+	return lines
+}
+
+func (c *Client) selectionLines() []string {
+	if !c.build.active() && c.follow.active() {
+		return c.followInfoLines()
+	}
+	if !c.build.active() {
+		return []string{
+			"Nothing selected",
+			"",
+			"i: inspect   b: build",
+			"m: map       +/-/wheel: zoom",
+			"click: select  drag: pan",
+			"f: follow a train",
+			"o: block overlay",
+			"enter: chat  q: quit",
+		}
+	}
+
+	pos := c.build.cursor
+	if !c.w.InBounds(pos) {
+		return nil
+	}
+	lines := c.tileInfoLines(pos)
+	if t := c.trainAt(pos); t != nil {
+		lines = append(lines, "")
+		lines = append(lines, trainInfoLines(t)...)
+	}
+	return lines
+}
+
+func (c *Client) tileInfoLines(pos world.Pos) []string {
+	tile := c.w.TileAt(pos)
+	lines := []string{
+		fmt.Sprintf("Tile %d,%d", pos.X, pos.Y),
+		"Type: " + tile.Type.String(),
+	}
+
+	track, ok := c.w.Tracks[pos]
+	if !ok {
+		return lines
+	}
+	lines = append(lines, fmt.Sprintf("Track: %c %s", trackChar(track.Direction), track.Direction))
+	if track.HasSignal {
+		lines = append(lines, "Signal: facing "+track.SignalDir.String())
+	} else {
+		lines = append(lines, "Signal: none")
+	}
+
+	// Blocks only live on the server so these come from the last inspection
+	if !c.hasFeature(message.CapabilityTileInspect) {
+		lines = append(lines, "Block: unknown")
+		return lines
+	}
+	info := c.inspected
+	if info == nil || info.Pos != pos {
+		lines = append(lines, "Block: ...")
+		return lines
+	}
+	if info.BlockID == "" {
+		lines = append(lines, "Block: none")
+		return lines
+	}
+	lines = append(lines, "Block: "+shortID(info.BlockID))
+	if info.OccupiedBy == "" {
+		lines = append(lines, "Occupied by: nobody")
+	} else {
+		lines = append(lines, "Occupied by: train "+shortID(info.OccupiedBy))
+	}
+	return lines
+}
+
+func trainInfoLines(t *trains.Train) []string {
+	lines := []string{
+		"Train " + shortID(t.ID.String()),
+		"Status: " + trainStatus(t),
+		fmt.Sprintf("Speed: %d", t.Speed),
+		fmt.Sprintf("Cars: %d", len(t.Cars)),
+	}
+
+	// Composition as counts of each car type, in car type order
+	counts := make(map[trains.CarType]int)
+	for _, car := range t.Cars {
+		counts[car.Type]++
+	}
+	carTypes := make([]trains.CarType, 0, len(counts))
+	for carType := range counts {
+		carTypes = append(carTypes, carType)
+	}
+	slices.Sort(carTypes)
+	for _, carType := range carTypes {
+		lines = append(lines, fmt.Sprintf("  %d x %s", counts[carType], carType))
+	}
+
+	if len(t.Orders) == 0 {
+		return append(lines, "Orders: none")
+	}
+	title := "Orders:"
+	if t.RepeatOrders {
+		title = "Orders (repeating):"
+	}
+	lines = append(lines, title)
+	for i, order := range t.Orders {
+		marker := "  "
+		if i == t.CurrentOrder {
+			marker = "> "
+		}
+		lines = append(lines, marker+order.String())
+	}
+	return lines
+}
+
+func trainStatus(t *trains.Train) string {
+	switch {
+	case t.Blocked:
+		return "blocked"
+	case t.WaitTicks > 0:
+		return fmt.Sprintf("waiting %d ticks", t.WaitTicks)
+	case t.IsMoving && t.IsReversing:
+		return "moving in reverse"
+	case t.IsMoving:
+		return "moving"
+	default:
+		return "stopped"
+	}
+}
+
+// trainAt returns the train with a car on pos, nil if there isn't one
+func (c *Client) trainAt(pos world.Pos) *trains.Train {
+	for _, t := range c.w.Trains {
+		for _, car := range t.Cars {
+			if car.X == pos.X && car.Y == pos.Y {
+				return t
+			}
+		}
+	}
+	return nil
+}
+
+// shortID trims a UUID down to something that fits in the panel
+func shortID(id string) string {
+	id, _, _ = strings.Cut(id, "-")
+	return id
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+	"github.com/google/uuid"
+)
+
+func TestInfoLinesForTrack(t *testing.T) {
+	w := world.New(100, 100)
+	pos := world.Pos{X: 5, Y: 5}
+	w.AddTrack(pos, &types.Track{Direction: types.DirEast | types.DirWest, HasSignal: true, SignalDir: types.DirEast})
+	c := testClient(t, w)
+	c.enterCursorMode(toolInspect)
+	c.build.cursor = pos
+
+	lines := c.infoLines()
+	if lines[0] != "Tile 5,5" || lines[1] != "Type: Track" || !slices.Contains(lines, "Signal: facing "+types.Dir(types.DirEast).String()) {
+		t.Errorf("got %q, want the tile, its type and the signal", lines)
+	}
+	if lines[len(lines)-1] != "Block: ..." {
+		t.Errorf("got %q before the server answered, want the block pending", lines[len(lines)-1])
+	}
+
+	blockID, trainID := uuid.NewString(), uuid.NewString()
+	c.handleIncomingMessage(incomingMessage{tileInfoMessage: &message.TileInfoMessage{Pos: pos, BlockID: blockID, OccupiedBy: trainID}})
+	lines = c.infoLines()
+	if !slices.Contains(lines, "Block: "+shortID(blockID)) || !slices.Contains(lines, "Occupied by: train "+shortID(trainID)) {
+		t.Errorf("got %q, want the block and the train in it", lines)
+	}
+
+	// An answer about another tile isn't used
+	c.build.cursor = world.Pos{X: 6, Y: 5}
+	w.AddTrack(c.build.cursor, &types.Track{Direction: types.DirEast | types.DirWest})
+	if lines := c.infoLines(); lines[len(lines)-1] != "Block: ..." {
+		t.Errorf("got %q for the next tile, want the block pending", lines[len(lines)-1])
+	}
+}
+
+func TestInfoLinesForTrain(t *testing.T) {
+	w := world.New(100, 100)
+	train := &trains.Train{
+		ID:           uuid.New(),
+		IsMoving:     true,
+		Blocked:      true,
+		RepeatOrders: true,
+		CurrentOrder: 1,
+		Orders: []trains.Order{
+			{Type: trains.OrderGoTo, X: 3, Y: 4},
+			{Type: trains.OrderWait, Ticks: 20},
+		},
+		Cars: []*trains.TrainCar{
+			{X: 5, Y: 5, Type: trains.CarTypeLocomotive},
+			{X: 4, Y: 5, Type: trains.CarTypeCargo},
+			{X: 3, Y: 5, Type: trains.CarTypeCargo},
+		},
+	}
+	w.AddTrain(train)
+	c := testClient(t, w)
+	c.enterCursorMode(toolInspect)
+	c.build.cursor = world.Pos{X: 4, Y: 5}
+
+	lines := c.infoLines()
+	for _, want := range []string{
+		"Train " + shortID(train.ID.String()),
+		"Status: blocked",
+		"Cars: 3",
+		"  1 x Locomotive",
+		"  2 x Cargo",
+		"Orders (repeating):",
+		"> Wait 20 ticks",
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("%q missing from %q", want, lines)
+		}
+	}
+}
+
+func TestRefreshInspection(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	c.refreshInspection()
+	if msgs := sent(c); len(msgs) != 0 {
+		t.Fatalf("asked about %+v without a cursor", msgs)
+	}
+
+	c.enterCursorMode(toolInspect)
+	c.refreshInspection()
+	msgs := sent(c)
+	if len(msgs) != 1 || msgs[0].inspectTileMessage == nil || msgs[0].inspectTileMessage.Pos != c.build.cursor {
+		t.Fatalf("sent %+v, want the tile under the cursor inspected", msgs)
+	}
+
+	// The cursor moving straight away doesn't flood the server
+	c.handleKey(key(tcell.KeyRight))
+	c.refreshInspection()
+	if msgs := sent(c); len(msgs) != 0 {
+		t.Errorf("sent %+v straight after the last inspection", msgs)
+	}
+}
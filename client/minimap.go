@@ -0,0 +1,199 @@
+package client
+
+import (
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+)
+
+const (
+	// minimapMaxHeight caps how much of the info panel the minimap takes
+	minimapMaxHeight = 16
+	// minimapSamples is how many tiles along each side of a minimap cell are
+	// looked at to decide what the cell shows
+	minimapSamples = 4
+)
+
+var (
+	unknownStyle = tcell.StyleDefault.Foreground(tcell.ColorDimGray)
+	unknownChar  = '░'
+)
+
+// minimapLayout is where the minimap sits on screen and how many tiles each
+// of its cells covers
+type minimapLayout struct {
+	x, y, width, height int
+	scale               int
+}
+
+type minimapCell struct {
+	ch    rune
+	style tcell.Style
+}
+
+func (r *SimpleRenderer) minimapLayout() (minimapLayout, bool) {
+	termWidth, _ := r.screen.Size()
+	_, viewHeight := r.viewCells()
+	layout := minimapLayout{
+		x:      termWidth - infoPanelWidth + 1,
+		width:  infoPanelWidth - 1,
+		height: min(minimapMaxHeight, viewHeight/2),
+	}
+	layout.y = viewHeight - layout.height
+	if layout.width <= 0 || layout.height <= 0 || r.w.Width == 0 || r.w.Height == 0 {
+		return layout, false
+	}
+
+	// Same scale both ways so the map isn't stretched, rounding up so the
+	// whole world fits
+	layout.scale = max(
+		(r.w.Width+layout.width-1)/layout.width,
+		(r.w.Height+layout.height-1)/layout.height,
+		1,
+	)
+	return layout, true
+}
+
+// MinimapToWorld returns the middle of the tiles under a minimap cell, false
+// if the screen cell isn't on the minimap
+func (r *SimpleRenderer) MinimapToWorld(x, y int) (world.Pos, bool) {
+	layout, ok := r.minimapLayout()
+	if !ok {
+		return world.Pos{}, false
+	}
+	col, row := x-layout.x, y-layout.y
+	if col < 0 || col >= layout.width || row < 0 || row >= layout.height {
+		return world.Pos{}, false
+	}
+	pos := world.Pos{
+		X: col*layout.scale + layout.scale/2,
+		Y: (layout.height-1-row)*layout.scale + layout.scale/2, // Flip Y
+	}
+	return pos, r.w.InBounds(pos)
+}
+
+// MinimapScale is how many tiles each minimap cell covers
+func (r *SimpleRenderer) MinimapScale() int {
+	layout, ok := r.minimapLayout()
+	if !ok {
+		return 1
+	}
+	return layout.scale
+}
+
+func (r *SimpleRenderer) renderMinimap(frame Frame) {
+	layout, ok := r.minimapLayout()
+	if !ok {
+		return
+	}
+
+	titleStyle := tcell.StyleDefault.Foreground(tcell.ColorWhite)
+	title := " Map "
+	if frame.MinimapActive {
+		title = " Map  arrows: move  esc: leave "
+		titleStyle = titleStyle.Background(tcell.ColorNavy)
+	}
+	for px := layout.x; px < layout.x+layout.width; px++ {
+		r.screen.SetContent(px, layout.y-1, '─', nil, tcell.StyleDefault.Foreground(tcell.ColorWhite))
+	}
+	for i, ch := range []rune(title) {
+		if i+1 >= layout.width {
+			break
+		}
+		r.screen.SetContent(layout.x+1+i, layout.y-1, ch, nil, titleStyle)
+	}
+
+	// cellFor returns the minimap cell covering pos, false if it is off the map
+	cellFor := func(pos world.Pos) (int, int, bool) {
+		col, row := pos.X/layout.scale, pos.Y/layout.scale
+		if col < 0 || col >= layout.width || row < 0 || row >= layout.height {
+			return 0, 0, false
+		}
+		return col, layout.height - 1 - row, true // Flip Y
+	}
+
+	cells := make([][]minimapCell, layout.height)
+	for row := range cells {
+		cells[row] = make([]minimapCell, layout.width)
+		for col := range cells[row] {
+			cells[row][col] = minimapCell{ch: ' ', style: tcell.StyleDefault}
+		}
+	}
+
+	// Terrain is whatever most of the sampled tiles are
+	step := max(layout.scale/minimapSamples, 1)
+	for row := 0; row < layout.height; row++ {
+		for col := 0; col < layout.width; col++ {
+			origin := world.Pos{X: col * layout.scale, Y: (layout.height - 1 - row) * layout.scale}
+			if !r.w.InBounds(origin) {
+				continue
+			}
+
+			summary := r.summariseCell(origin, layout.scale, step, frame.Known)
+			cell := &cells[row][col]
+			if summary.unknown {
+				cell.ch, cell.style = unknownChar, unknownStyle
+				continue
+			}
+			cell.ch, cell.style = minimapTerrain(summary.tileType)
+		}
+	}
+
+	// Track and trains are thin enough to be missed by sampling so they are
+	// drawn over the top from the world itself
+	for pos := range r.w.Tracks {
+		if frame.Known != nil && !frame.Known(world.TileToChunkPos(pos)) {
+			continue
+		}
+		if col, row, ok := cellFor(pos); ok {
+			cells[row][col].ch = '+'
+			cells[row][col].style = tcell.StyleDefault.Foreground(tcell.ColorSilver)
+		}
+	}
+	for _, t := range r.w.Trains {
+		for _, car := range t.Cars {
+			if col, row, ok := cellFor(world.Pos{X: car.X, Y: car.Y}); ok {
+				cells[row][col].ch = '█'
+				cells[row][col].style = tcell.StyleDefault.Foreground(tcell.ColorRed)
+			}
+		}
+	}
+
+	// Outline the part of the world on screen
+	viewWidth, viewHeight := r.ViewSize()
+	minCol, maxRow, _ := cellFor(frame.CamPos)
+	maxCol, minRow, _ := cellFor(world.Pos{
+		X: min(frame.CamPos.X+viewWidth-1, r.w.Width-1),
+		Y: min(frame.CamPos.Y+viewHeight-1, r.w.Height-1),
+	})
+	for row := minRow; row <= maxRow; row++ {
+		for col := minCol; col <= maxCol; col++ {
+			if row == minRow || row == maxRow || col == minCol || col == maxCol {
+				cells[row][col].style = cells[row][col].style.Background(tcell.ColorDarkSlateGray)
+			}
+		}
+	}
+
+	for row := range cells {
+		for col, cell := range cells[row] {
+			r.screen.SetContent(layout.x+col, layout.y+row, cell.ch, nil, cell.style)
+		}
+	}
+}
+
+func minimapTerrain(tileType types.TileType) (rune, tcell.Style) {
+	switch tileType {
+	case types.TileWater:
+		return '~', tcell.StyleDefault.Foreground(tcell.ColorBlue)
+	case types.TileTree:
+		return 'T', tcell.StyleDefault.Foreground(tcell.ColorDarkGreen)
+	case types.TileMountain:
+		return '^', tcell.StyleDefault.Foreground(tcell.ColorSlateGray)
+	case types.TileIron:
+		return '*', tcell.StyleDefault.Foreground(tcell.ColorRosyBrown)
+	case types.TileTrack:
+		return '+', tcell.StyleDefault.Foreground(tcell.ColorSilver)
+	default:
+		return '.', tcell.StyleDefault.Foreground(tcell.ColorYellowGreen)
+	}
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+)
+
+// The test client's minimap is 34x10 cells, so a 100x100 world is drawn at
+// 10 tiles a cell
+
+func TestMinimapToWorld(t *testing.T) {
+	c := testClient(t, world.New(100, 100))
+	r := c.r.(*SimpleRenderer)
+	layout, ok := r.minimapLayout()
+	if !ok || layout.scale != 10 {
+		t.Fatalf("got layout %+v, want 10 tiles a cell", layout)
+	}
+
+	if pos, ok := r.MinimapToWorld(layout.x, layout.y+layout.height-1); !ok || pos != (world.Pos{X: 5, Y: 5}) {
+		t.Errorf("bottom left cell got %v, %v, want the middle of its tiles", pos, ok)
+	}
+	if pos, ok := r.MinimapToWorld(layout.x+2, layout.y); !ok || pos != (world.Pos{X: 25, Y: 95}) {
+		t.Errorf("top row got %v, %v, want the top of the world", pos, ok)
+	}
+	// Past the right of the world
+	if _, ok := r.MinimapToWorld(layout.x+20, layout.y); ok {
+		t.Error("cell past the edge of the world is on the map")
+	}
+	if _, ok := r.MinimapToWorld(0, 0); ok {
+		t.Error("main view is on the minimap")
+	}
+}
+
+func TestMinimapClickMovesCamera(t *testing.T) {
+	c := testClient(t, world.New(200, 200))
+	layout, _ := c.r.(*SimpleRenderer).minimapLayout()
+	pos, _ := c.r.MinimapToWorld(layout.x+5, layout.y+2)
+
+	c.handleMouse(tcell.NewEventMouse(layout.x+5, layout.y+2, tcell.Button1, tcell.ModNone))
+	width, height := c.r.ViewSize()
+	if want := (world.Pos{X: pos.X - width/2, Y: pos.Y - height/2}); c.camPos != want {
+		t.Errorf("camera at %v, want %v centred on %v", c.camPos, want, pos)
+	}
+}
+
+func TestMinimapKeys(t *testing.T) {
+	c := testClient(t, world.New(500, 500))
+	c.handleKey(runeKey('m'))
+	if !c.minimapActive {
+		t.Fatal("m didn't select the minimap")
+	}
+
+	scale := c.r.MinimapScale()
+	c.handleKey(key(tcell.KeyUp))
+	c.handleKey(key(tcell.KeyRight))
+	if c.camPos != (world.Pos{X: scale, Y: scale}) {
+		t.Errorf("camera at %v, want it moved a cell of %d tiles each way", c.camPos, scale)
+	}
+
+	c.handleKey(key(tcell.KeyEscape))
+	if c.minimapActive {
+		t.Fatal("escape didn't leave the minimap")
+	}
+	c.handleKey(key(tcell.KeyUp))
+	if c.camPos.Y != scale+c.camSpeed {
+		t.Errorf("camera at %v, want the arrows back to moving by %d", c.camPos, c.camSpeed)
+	}
+}
+
+func TestMinimapDrawsUnknownChunks(t *testing.T) {
+	w := world.New(100, 100)
+	w.AddTrack(world.Pos{X: 25, Y: 5}, &types.Track{Direction: types.DirEast | types.DirWest})
+	w.AddTrack(world.Pos{X: 75, Y: 5}, &types.Track{Direction: types.DirEast | types.DirWest})
+	c := testClient(t, w)
+	r := c.r.(*SimpleRenderer)
+
+	// Only the first chunk has arrived
+	r.Render(Frame{Known: func(chunkPos world.Pos) bool { return chunkPos == world.Pos{} }})
+
+	layout, _ := r.minimapLayout()
+	bottom := layout.y + layout.height - 1
+	for col, want := range map[int]rune{0: '.', 2: '+', 7: unknownChar} {
+		if got, _, _, _ := r.screen.GetContent(layout.x+col, bottom); got != want {
+			t.Errorf("minimap column %d got %q, want %q", col, got, want)
+		}
+	}
+
+	r.Render(Frame{Known: func(world.Pos) bool { return false }})
+	if got, _, _, _ := r.screen.GetContent(0, 0); got != unknownChar {
+		t.Errorf("main view got %q for an unknown chunk, want %q", got, unknownChar)
+	}
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+)
+
+// mouseState is a press of the left button on the map, kept until it is let
+// go so we can tell a click from a drag
+type mouseState struct {
+	pressed bool
+	dragged bool
+	// grabbed is the tile under the pointer when the button went down,
+	// dragging keeps it under the pointer
+	grabbed        world.Pos
+	pressX, pressY int
+}
+
+// handleMouse zooms with the wheel, jumps to wherever the minimap is clicked
+// and otherwise pans and selects, or builds when a build tool is picked up
+func (c *Client) handleMouse(ev *tcell.EventMouse) {
+	x, y := ev.Position()
+	buttons := ev.Buttons()
+
+	switch {
+	case buttons&tcell.WheelUp != 0:
+		c.zoomAt(-1, x, y)
+		return
+	case buttons&tcell.WheelDown != 0:
+		c.zoomAt(1, x, y)
+		return
+	}
+
+	// A drag that wanders over the minimap carries on panning
+	if pos, ok := c.r.MinimapToWorld(x, y); ok && !c.mouse.pressed {
+		if buttons&tcell.Button1 != 0 {
+			c.stopFollowing()
+			c.centreCamera(pos)
+		}
+		return
+	}
+
+	switch c.build.tool {
+	case toolTrack, toolDelete:
+		c.handleBuildMouse(ev)
+	default:
+		c.handleMapMouse(ev)
+	}
+}
+
+// handleMapMouse pans the map when it is dragged and selects whatever is
+// clicked on for the info panel
+func (c *Client) handleMapMouse(ev *tcell.EventMouse) {
+	x, y := ev.Position()
+	pressed := ev.Buttons()&tcell.Button1 != 0
+
+	switch {
+	case pressed && !c.mouse.pressed:
+		if _, onMap := c.r.ScreenToWorld(c.camPos, x, y); !onMap {
+			return
+		}
+		c.mouse = mouseState{
+			pressed: true,
+			grabbed: c.cellToWorld(x, y),
+			pressX:  x,
+			pressY:  y,
+		}
+	case pressed:
+		if !c.mouse.dragged && x == c.mouse.pressX && y == c.mouse.pressY {
+			return
+		}
+		c.mouse.dragged = true
+		c.stopFollowing()
+		c.placeCamera(c.mouse.grabbed, x, y)
+	case c.mouse.pressed:
+		// Let go without moving, so it was a click
+		if !c.mouse.dragged {
+			c.selectAt(c.mouse.grabbed)
+		}
+		c.mouse = mouseState{}
+	}
+}
+
+// selectAt moves the inspect cursor to a clicked tile. Zoomed out a cell
+// covers a block of tiles, so we pick out a train or track in it if there is
+// one as those are what the cell shows
+func (c *Client) selectAt(pos world.Pos) {
+	if zoom := c.r.Zoom(); zoom > 1 {
+		pos = c.pickInBlock(pos, zoom)
+	}
+	if !c.w.InBounds(pos) {
+		return
+	}
+	if c.build.tool != toolInspect {
+		c.build.tool = toolInspect
+		c.build.path = nil
+	}
+	c.build.cursor = pos
+}
+
+func (c *Client) pickInBlock(origin world.Pos, size int) world.Pos {
+	inBlock := func(pos world.Pos) bool {
+		return pos.X >= origin.X && pos.X < origin.X+size && pos.Y >= origin.Y && pos.Y < origin.Y+size
+	}
+	for _, t := range c.w.Trains {
+		for _, car := range t.Cars {
+			if pos := (world.Pos{X: car.X, Y: car.Y}); inBlock(pos) {
+				return pos
+			}
+		}
+	}
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			pos := world.Pos{X: origin.X + dx, Y: origin.Y + dy}
+			if _, ok := c.w.Tracks[pos]; ok {
+				return pos
+			}
+		}
+	}
+	return origin
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+)
+
+func mouse(c *Client, x, y int, buttons tcell.ButtonMask) {
+	c.handleMouse(tcell.NewEventMouse(x, y, buttons, tcell.ModNone))
+}
+
+func TestClickSelects(t *testing.T) {
+	c := testClient(t, world.New(1000, 1000))
+	c.camPos = world.Pos{X: 100, Y: 100}
+
+	mouse(c, 5, 19, tcell.Button1)
+	mouse(c, 5, 19, tcell.ButtonNone)
+	if c.build.tool != toolInspect || c.build.cursor != (world.Pos{X: 105, Y: 100}) {
+		t.Errorf("got tool %d with the cursor at %v, want the clicked tile inspected", c.build.tool, c.build.cursor)
+	}
+	if c.camPos != (world.Pos{X: 100, Y: 100}) {
+		t.Errorf("click moved the camera to %v", c.camPos)
+	}
+}
+
+func TestDragPans(t *testing.T) {
+	c := testClient(t, world.New(1000, 1000))
+	c.camPos = world.Pos{X: 100, Y: 100}
+
+	mouse(c, 10, 10, tcell.Button1)
+	mouse(c, 5, 12, tcell.Button1)
+	// The grabbed tile stays under the pointer
+	if c.camPos != (world.Pos{X: 105, Y: 102}) {
+		t.Errorf("camera at %v, want it dragged along with the pointer", c.camPos)
+	}
+	mouse(c, 5, 12, tcell.ButtonNone)
+	if c.build.active() {
+		t.Error("drag selected a tile")
+	}
+}
+
+func TestWheelZoomsAtPointer(t *testing.T) {
+	c := testClient(t, world.New(1000, 1000))
+	c.camPos = world.Pos{X: 100, Y: 100}
+	under := c.cellToWorld(30, 4)
+
+	mouse(c, 30, 4, tcell.WheelDown)
+	if c.r.Zoom() != 2 {
+		t.Fatalf("zoom is %d after scrolling down, want 2", c.r.Zoom())
+	}
+	if got := c.cellToWorld(30, 4); got != under {
+		t.Errorf("pointer is over %v after zooming, want %v", got, under)
+	}
+
+	mouse(c, 30, 4, tcell.WheelUp)
+	if c.r.Zoom() != 1 {
+		t.Errorf("zoom is %d after scrolling back, want 1", c.r.Zoom())
+	}
+}
+
+func TestZoomedOutClickPicksTrack(t *testing.T) {
+	w := world.New(1000, 1000)
+	track := world.Pos{X: 103, Y: 102}
+	w.AddTrack(track, &types.Track{Direction: types.DirEast | types.DirWest})
+	c := testClient(t, w)
+	c.r.SetZoom(4)
+	c.camPos = world.Pos{X: 100, Y: 100}
+
+	// The bottom left cell covers 100,100 to 103,103
+	mouse(c, 0, 19, tcell.Button1)
+	mouse(c, 0, 19, tcell.ButtonNone)
+	if c.build.cursor != track {
+		t.Errorf("cursor at %v, want the track in the clicked cell", c.build.cursor)
+	}
+}
+
+func TestEnterOpensChatAfterClicking(t *testing.T) {
+	c := testClient(t, world.New(1000, 1000))
+	mouse(c, 5, 5, tcell.Button1)
+	mouse(c, 5, 5, tcell.ButtonNone)
+	if c.build.tool != toolInspect {
+		t.Fatalf("got tool %d, want the click to inspect", c.build.tool)
+	}
+
+	c.handleKey(key(tcell.KeyEnter))
+	if !c.chatInput.active {
+		t.Error("enter didn't open chat while inspecting")
+	}
+}
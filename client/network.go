@@ -1,7 +1,9 @@
 package client
 
 import (
-	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/danharasymiw/bit-rail/message"
 	"github.com/gorilla/websocket"
@@ -9,65 +11,242 @@ import (
 )
 
 type incomingMessage struct {
-	chatMessage        *message.ChatMessage
-	chunksMessage      *message.ChunksMessage
-	initialLoadMessage *message.InitialLoadMessage
+	chatMessage          *message.ChatMessage
+	chunksMessage        *message.ChunksMessage
+	initialLoadMessage   *message.InitialLoadMessage
+	trainUpdatesMessage  *message.TrainUpdatesMessage
+	trackUpdatesMessage  *message.TrackUpdatesMessage
+	buildRejectedMessage *message.BuildRejectedMessage
+	loginResultMessage   *message.LoginResultMessage
+	moveCameraMessage    *message.MoveCameraMessage
+	tileInfoMessage      *message.TileInfoMessage
+	blocksMessage        *message.BlocksMessage
+	blockUpdatesMessage  *message.BlockUpdatesMessage
+
+	// disconnected and reconnected aren't messages, they tell the client the
+	// connection dropped and came back
+	disconnected bool
+	reconnected  bool
 }
 
 type outgoingMessage struct {
-	loginMessage     *message.LoginMessage
-	chatMessage      *message.ChatMessage
-	getChunksMessage *message.GetChunksMessage
+	loginMessage             *message.LoginMessage
+	chatMessage              *message.ChatMessage
+	getChunksMessage         *message.GetChunksMessage
+	unsubscribeChunksMessage *message.UnsubscribeChunksMessage
+	buildTrackMessage        *message.BuildTrackMessage
+	buildTrackPathMessage    *message.BuildTrackPathMessage
+	removeTrackMessage       *message.RemoveTrackMessage
+	inspectTileMessage       *message.InspectTileMessage
+	getBlocksMessage         *message.GetBlocksMessage
 }
 
+const (
+	serverURL = "ws://localhost:2977/ws"
+
+	reconnectMinBackoff = 250 * time.Millisecond
+	reconnectMaxBackoff = 10 * time.Second
+)
+
 type clientNetworkManager struct {
-	ws         *websocket.Conn
+	codec      message.Codec
+	login      message.LoginMessage
 	incomingCh chan incomingMessage
 	outgoingCh chan outgoingMessage
+	dialer     websocket.Dialer
+
+	// ws is nil while we are reconnecting, wsCond is signalled when it changes
+	ws     *websocket.Conn
+	wsMu   sync.Mutex
+	wsCond *sync.Cond
+	closed bool
+	quitCh chan struct{}
+
+	// Session state, only touched by the read goroutine
+	sessionToken string
+	loaded       bool
+	// lastTick is the newest tick we've received updates for
+	lastTick atomic.Uint64
 }
 
-func newClientNetworkManager() (*clientNetworkManager, error) {
-	ws, _, err := websocket.DefaultDialer.Dial("ws://localhost:2977/ws", nil)
+func newClientNetworkManager(codec message.Codec, login message.LoginMessage) (*clientNetworkManager, error) {
+	nm := &clientNetworkManager{
+		codec:      codec,
+		login:      login,
+		incomingCh: make(chan incomingMessage, 100),
+		outgoingCh: make(chan outgoingMessage, 100),
+		dialer:     websocket.Dialer{HandshakeTimeout: 5 * time.Second},
+		quitCh:     make(chan struct{}),
+	}
+	nm.wsCond = sync.NewCond(&nm.wsMu)
+
+	ws, err := nm.connect()
 	if err != nil {
 		return nil, err
 	}
-	return &clientNetworkManager{
-		ws:         ws,
-		incomingCh: make(chan incomingMessage, 100),
-		outgoingCh: make(chan outgoingMessage, 100),
-	}, nil
+	nm.ws = ws
+	return nm, nil
 }
 
 func (nm *clientNetworkManager) start() {
-	go nm.readLoop()
+	go nm.run()
 	go nm.writeLoop()
 }
 
-func (nm *clientNetworkManager) readLoop() {
+// connect dials the server and logs in, resuming our session if we have one
+func (nm *clientNetworkManager) connect() (*websocket.Conn, error) {
+	ws, _, err := nm.dialer.Dial(serverURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	login := nm.login
+	// Without the world loaded there is nothing to resume
+	if nm.loaded {
+		login.SessionToken = nm.sessionToken
+		login.LastTick = nm.lastTick.Load()
+	}
+	frame, err := nm.codec.Encode(message.MessageTypeLogin, &login)
+	if err != nil {
+		ws.Close()
+		return nil, err
+	}
+	if err := ws.WriteMessage(nm.codec.FrameType(), frame); err != nil {
+		ws.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+// run reads from the server, reconnecting with backoff whenever the
+// connection drops, until we are closed or the server turns us away
+func (nm *clientNetworkManager) run() {
 	defer close(nm.incomingCh)
 
+	ws := nm.ws
+	for {
+		if !nm.readLoop(ws) {
+			return
+		}
+		nm.dropConn(ws)
+
+		select {
+		case nm.incomingCh <- incomingMessage{disconnected: true}:
+		case <-nm.quitCh:
+			return
+		}
+
+		ws = nm.reconnect()
+		if ws == nil {
+			return
+		}
+		select {
+		case nm.incomingCh <- incomingMessage{reconnected: true}:
+		case <-nm.quitCh:
+			ws.Close()
+			return
+		}
+
+		nm.wsMu.Lock()
+		nm.ws = ws
+		nm.wsCond.Broadcast()
+		nm.wsMu.Unlock()
+	}
+}
+
+// reconnect keeps trying to get back to the server, it returns nil if we are
+// closed first
+func (nm *clientNetworkManager) reconnect() *websocket.Conn {
+	backoff := reconnectMinBackoff
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-nm.quitCh:
+			return nil
+		}
+
+		ws, err := nm.connect()
+		if err == nil {
+			return ws
+		}
+		logrus.Debugf("Reconnect failed, trying again in %v: %v", backoff, err)
+		backoff = min(backoff*2, reconnectMaxBackoff)
+	}
+}
+
+// dropConn forgets the connection so the writer waits for a new one
+func (nm *clientNetworkManager) dropConn(ws *websocket.Conn) {
+	ws.Close()
+	nm.wsMu.Lock()
+	if nm.ws == ws {
+		nm.ws = nil
+	}
+	nm.wsMu.Unlock()
+}
+
+// conn waits for a live connection, it returns nil once we are closed
+func (nm *clientNetworkManager) conn() *websocket.Conn {
+	nm.wsMu.Lock()
+	defer nm.wsMu.Unlock()
+	for nm.ws == nil && !nm.closed {
+		nm.wsCond.Wait()
+	}
+	if nm.closed {
+		return nil
+	}
+	return nm.ws
+}
+
+// readLoop passes messages from the connection to the client until it
+// drops. It returns false if we shouldn't reconnect
+func (nm *clientNetworkManager) readLoop(ws *websocket.Conn) bool {
 	for {
-		var msg message.Message
-		err := nm.ws.ReadJSON(&msg)
+		_, frame, err := ws.ReadMessage()
 		if err != nil {
 			logrus.Debugf("WebSocket read error: %v", err)
-			return
+			select {
+			case <-nm.quitCh:
+				return false
+			default:
+				return true
+			}
+		}
+
+		msgType, body, err := nm.codec.Decode(frame)
+		if err != nil {
+			logrus.Errorf("Error decoding message: %v", err)
+			continue
 		}
 
 		var incoming incomingMessage
 
-		switch msg.Type {
+		switch msgType {
+		case message.MessageTypeLoginResult:
+			var loginResultMsg message.LoginResultMessage
+			if err := nm.codec.DecodeBody(body, &loginResultMsg); err != nil {
+				logrus.Errorf("Error unmarshaling login result message: %v", err)
+				continue
+			}
+			incoming.loginResultMessage = &loginResultMsg
+			if !loginResultMsg.Accepted {
+				nm.incomingCh <- incoming
+				return false
+			}
+			nm.sessionToken = loginResultMsg.SessionToken
+
 		case message.MessageTypeInitialLoad:
 			var initialLoadMsg message.InitialLoadMessage
-			if err := json.Unmarshal(msg.Data, &initialLoadMsg); err != nil {
+			if err := nm.codec.DecodeBody(body, &initialLoadMsg); err != nil {
 				logrus.Errorf("Error unmarshaling initial load message: %v", err)
 				continue
 			}
 			incoming.initialLoadMessage = &initialLoadMsg
+			nm.loaded = true
+			nm.lastTick.Store(initialLoadMsg.Tick)
 
 		case message.MessageTypeChat:
 			var chatMsg message.ChatMessage
-			if err := json.Unmarshal(msg.Data, &chatMsg); err != nil {
+			if err := nm.codec.DecodeBody(body, &chatMsg); err != nil {
 				logrus.Errorf("Error unmarshaling chat message: %v", err)
 				continue
 			}
@@ -75,14 +254,72 @@ func (nm *clientNetworkManager) readLoop() {
 
 		case message.MessageTypeChunks:
 			var chunksMsg message.ChunksMessage
-			if err := json.Unmarshal(msg.Data, &chunksMsg); err != nil {
+			if err := nm.codec.DecodeBody(body, &chunksMsg); err != nil {
 				logrus.Errorf("Error unmarshaling chunks message: %v", err)
 				continue
 			}
 			incoming.chunksMessage = &chunksMsg
 
+		case message.MessageTypeTrainUpdates:
+			var trainUpdatesMsg message.TrainUpdatesMessage
+			if err := nm.codec.DecodeBody(body, &trainUpdatesMsg); err != nil {
+				logrus.Errorf("Error unmarshaling train updates message: %v", err)
+				continue
+			}
+			incoming.trainUpdatesMessage = &trainUpdatesMsg
+			nm.seenTick(trainUpdatesMsg.Tick)
+
+		case message.MessageTypeTrackUpdates:
+			var trackUpdatesMsg message.TrackUpdatesMessage
+			if err := nm.codec.DecodeBody(body, &trackUpdatesMsg); err != nil {
+				logrus.Errorf("Error unmarshaling track updates message: %v", err)
+				continue
+			}
+			incoming.trackUpdatesMessage = &trackUpdatesMsg
+			nm.seenTick(trackUpdatesMsg.Tick)
+
+		case message.MessageTypeBuildRejected:
+			var buildRejectedMsg message.BuildRejectedMessage
+			if err := nm.codec.DecodeBody(body, &buildRejectedMsg); err != nil {
+				logrus.Errorf("Error unmarshaling build rejected message: %v", err)
+				continue
+			}
+			incoming.buildRejectedMessage = &buildRejectedMsg
+
+		case message.MessageTypeMoveCamera:
+			var moveCameraMsg message.MoveCameraMessage
+			if err := nm.codec.DecodeBody(body, &moveCameraMsg); err != nil {
+				logrus.Errorf("Error unmarshaling move camera message: %v", err)
+				continue
+			}
+			incoming.moveCameraMessage = &moveCameraMsg
+
+		case message.MessageTypeTileInfo:
+			var tileInfoMsg message.TileInfoMessage
+			if err := nm.codec.DecodeBody(body, &tileInfoMsg); err != nil {
+				logrus.Errorf("Error unmarshaling tile info message: %v", err)
+				continue
+			}
+			incoming.tileInfoMessage = &tileInfoMsg
+
+		case message.MessageTypeBlocks:
+			var blocksMsg message.BlocksMessage
+			if err := nm.codec.DecodeBody(body, &blocksMsg); err != nil {
+				logrus.Errorf("Error unmarshaling blocks message: %v", err)
+				continue
+			}
+			incoming.blocksMessage = &blocksMsg
+
+		case message.MessageTypeBlockUpdates:
+			var blockUpdatesMsg message.BlockUpdatesMessage
+			if err := nm.codec.DecodeBody(body, &blockUpdatesMsg); err != nil {
+				logrus.Errorf("Error unmarshaling block updates message: %v", err)
+				continue
+			}
+			incoming.blockUpdatesMessage = &blockUpdatesMsg
+
 		default:
-			logrus.Debugf("Unknown message type: %d", msg.Type)
+			logrus.Debugf("Unknown message type: %d", msgType)
 			continue
 		}
 
@@ -90,43 +327,81 @@ func (nm *clientNetworkManager) readLoop() {
 	}
 }
 
+func (nm *clientNetworkManager) seenTick(tick uint64) {
+	if tick > nm.lastTick.Load() {
+		nm.lastTick.Store(tick)
+	}
+}
+
 func (nm *clientNetworkManager) writeLoop() {
 	for outgoing := range nm.outgoingCh {
 		var msgType message.MessageType
-		var data []byte
-		var err error
+		var body any
 
 		// Determine message type and marshal
 		if outgoing.loginMessage != nil {
 			msgType = message.MessageTypeLogin
-			data, err = json.Marshal(outgoing.loginMessage)
+			body = outgoing.loginMessage
 		} else if outgoing.chatMessage != nil {
 			msgType = message.MessageTypeChat
-			data, err = json.Marshal(outgoing.chatMessage)
+			body = outgoing.chatMessage
 		} else if outgoing.getChunksMessage != nil {
 			msgType = message.MessageTypeGetChunks
-			data, err = json.Marshal(outgoing.getChunksMessage)
+			body = outgoing.getChunksMessage
+		} else if outgoing.unsubscribeChunksMessage != nil {
+			msgType = message.MessageTypeUnsubscribeChunks
+			body = outgoing.unsubscribeChunksMessage
+		} else if outgoing.buildTrackMessage != nil {
+			msgType = message.MessageTypeBuildTrack
+			body = outgoing.buildTrackMessage
+		} else if outgoing.buildTrackPathMessage != nil {
+			msgType = message.MessageTypeBuildTrackPath
+			body = outgoing.buildTrackPathMessage
+		} else if outgoing.removeTrackMessage != nil {
+			msgType = message.MessageTypeRemoveTrack
+			body = outgoing.removeTrackMessage
+		} else if outgoing.inspectTileMessage != nil {
+			msgType = message.MessageTypeInspectTile
+			body = outgoing.inspectTileMessage
+		} else if outgoing.getBlocksMessage != nil {
+			msgType = message.MessageTypeGetBlocks
+			body = outgoing.getBlocksMessage
 		} else {
 			logrus.Warn("Unknown outgoing message type")
 			continue
 		}
+		frame, err := nm.codec.Encode(msgType, body)
 		if err != nil {
 			logrus.Errorf("Error marshaling message: %v", err)
 			continue
 		}
 
-		msg := message.Message{
-			Type: msgType,
-			Data: data,
-		}
-		if err := nm.ws.WriteJSON(msg); err != nil {
-			logrus.Debugf("WebSocket write error: %v", err)
-			return
+		// Hold on to the message until it goes out, reconnecting if we need to
+		for {
+			ws := nm.conn()
+			if ws == nil {
+				return
+			}
+			if err := ws.WriteMessage(nm.codec.FrameType(), frame); err != nil {
+				logrus.Debugf("WebSocket write error: %v", err)
+				nm.dropConn(ws)
+				continue
+			}
+			break
 		}
 	}
 }
 
 func (nm *clientNetworkManager) close() {
+	nm.wsMu.Lock()
+	nm.closed = true
+	ws := nm.ws
+	nm.wsCond.Broadcast()
+	nm.wsMu.Unlock()
+
+	close(nm.quitCh)
 	close(nm.outgoingCh)
-	nm.ws.Close()
+	if ws != nil {
+		ws.Close()
+	}
 }
@@ -1,6 +1,9 @@
 package client
 
 import (
+	"fmt"
+
+	"github.com/danharasymiw/bit-rail/message"
 	"github.com/danharasymiw/bit-rail/trains"
 	"github.com/danharasymiw/bit-rail/types"
 	"github.com/danharasymiw/bit-rail/world"
@@ -12,20 +15,84 @@ type ChatMessage struct {
 	Message string
 }
 
+// ChatPanel is what the chat panel shows
+type ChatPanel struct {
+	Messages []ChatMessage
+	// Scroll is how many messages back from the newest the panel is showing
+	Scroll int
+
+	Typing bool
+	Input  []rune
+	Cursor int
+}
+
+// BuildOverlay is what build mode draws over the map
+type BuildOverlay struct {
+	Active   bool
+	Deleting bool
+	Cursor   world.Pos
+	// Preview is the track the current drag would lay
+	Preview map[world.Pos]types.Dir
+	// PreviewErr is why the preview can't be built, empty if it can
+	PreviewErr string
+	// Rejected is a tile the server recently refused to build on
+	Rejected *world.Pos
+	Help     string
+}
+
+// Frame is everything the renderer draws in one go
+type Frame struct {
+	CamPos world.Pos
+	Chat   ChatPanel
+	Build  BuildOverlay
+	// Info is the text in the info panel, a line per entry
+	Info []string
+	// Known reports whether we have a chunk's contents, anything else is
+	// drawn as unknown rather than the grass the world starts out as
+	Known func(chunkPos world.Pos) bool
+	// MinimapActive is set while the arrow keys move around the minimap
+	MinimapActive bool
+	// Blocks is what the server last said about the blocks of the track on
+	// screen, it sets the colour of signals
+	Blocks map[world.Pos]*message.BlockState
+	// BlockOverlay colours track by whether its block is free
+	BlockOverlay bool
+}
+
 type Renderer interface {
-	Render(camPos world.Pos, chatMessages []ChatMessage)
+	Render(frame Frame)
 	Screen() tcell.Screen
+	// ViewSize is how many tiles of the map fit on screen at the current zoom
+	ViewSize() (width, height int)
+	// Zoom is how many tiles along each side a screen cell covers
+	Zoom() int
+	SetZoom(zoom int)
+	// ScreenToWorld returns the tile under a screen cell, false if the cell
+	// isn't on the map
+	ScreenToWorld(camPos world.Pos, x, y int) (world.Pos, bool)
+	// MinimapToWorld returns the tile a minimap cell stands for, false if the
+	// screen cell isn't on the minimap
+	MinimapToWorld(x, y int) (world.Pos, bool)
+	// MinimapScale is how many tiles wide each minimap cell is
+	MinimapScale() int
 }
 
+const (
+	infoPanelWidth  = 35
+	chatPanelHeight = 10
+)
+
 type SimpleRenderer struct {
 	screen tcell.Screen
 	w      *world.World
+	zoom   int
 }
 
 func NewSimpleRenderer(screen tcell.Screen, w *world.World) *SimpleRenderer {
 	return &SimpleRenderer{
 		screen: screen,
 		w:      w,
+		zoom:   1,
 	}
 }
 
@@ -33,34 +100,107 @@ func (r *SimpleRenderer) Screen() tcell.Screen {
 	return r.screen
 }
 
-func (r *SimpleRenderer) Render(camPos world.Pos, chatMessages []ChatMessage) {
+func (r *SimpleRenderer) ViewSize() (int, int) {
+	width, height := r.viewCells()
+	return width * r.zoom, height * r.zoom
+}
+
+// viewCells is how many screen cells the map takes up
+func (r *SimpleRenderer) viewCells() (int, int) {
 	termWidth, termHeight := r.screen.Size()
+	return max(termWidth-infoPanelWidth, 0), max(termHeight-chatPanelHeight, 0)
+}
+
+func (r *SimpleRenderer) Zoom() int {
+	return r.zoom
+}
+
+func (r *SimpleRenderer) SetZoom(zoom int) {
+	r.zoom = max(zoom, 1)
+}
+
+// ScreenToWorld returns the bottom left tile of the cell when zoomed out
+func (r *SimpleRenderer) ScreenToWorld(camPos world.Pos, x, y int) (world.Pos, bool) {
+	width, height := r.viewCells()
+	if x < 0 || x >= width || y < 0 || y >= height {
+		return world.Pos{}, false
+	}
+	pos := world.Pos{X: camPos.X + x*r.zoom, Y: camPos.Y + (height-1-y)*r.zoom} // Flip Y
+	return pos, r.w.InBounds(pos)
+}
 
-	infoPanelWidth := 35
-	chatPanelHeight := 10
-	worldWidth := termWidth - infoPanelWidth
-	worldHeight := termHeight - chatPanelHeight
+// worldToScreen returns the cell a tile is drawn in, false if it is off screen
+func (r *SimpleRenderer) worldToScreen(camPos world.Pos, pos world.Pos) (int, int, bool) {
+	width, height := r.viewCells()
+	if pos.X < camPos.X || pos.Y < camPos.Y {
+		return 0, 0, false
+	}
+	x, y := (pos.X-camPos.X)/r.zoom, (pos.Y-camPos.Y)/r.zoom
+	if x >= width || y >= height {
+		return 0, 0, false
+	}
+	return x, height - 1 - y, true // Flip Y
+}
 
-	r.renderRegion(camPos, worldWidth, worldHeight)
-	r.renderTrains(camPos, worldWidth, worldHeight)
-	r.renderInfoPanel(worldWidth, 0, infoPanelWidth, worldHeight)
-	r.renderChatPanel(0, worldHeight, termWidth, chatPanelHeight, chatMessages)
+func (r *SimpleRenderer) Render(frame Frame) {
+	termWidth, _ := r.screen.Size()
+	worldWidth, worldHeight := r.viewCells()
+	camPos := frame.CamPos
+
+	r.renderRegion(camPos, worldWidth, worldHeight, frame)
+	r.renderTrains(camPos)
+	if frame.Build.Active {
+		r.renderBuildOverlay(camPos, worldWidth, frame.Build)
+	}
+	r.renderZoomLabel(worldHeight)
+	infoHeight := worldHeight
+	if layout, ok := r.minimapLayout(); ok {
+		infoHeight = layout.y - 1
+	}
+	r.renderInfoPanel(worldWidth, 0, infoPanelWidth, worldHeight, infoHeight, frame.Info)
+	r.renderMinimap(frame)
+	r.renderChatPanel(0, worldHeight, termWidth, chatPanelHeight, frame.Chat)
 
 	r.screen.Show()
 }
 
-func (r *SimpleRenderer) renderRegion(pos world.Pos, width, height int) {
-	for relY, row := range r.w.Tiles[pos.Y : pos.Y+height] {
-		for relX, tile := range row[pos.X : pos.X+width] {
-			worldPos := world.Pos{X: pos.X + relX, Y: pos.Y + relY}
-			ch, style := r.getTileChar(worldPos, tile)
-			screenY := height - 1 - relY // Flip Y
-			r.screen.SetContent(relX, screenY, ch, nil, style)
+// renderRegion draws the map a cell at a time, when zoomed out each cell
+// stands for a block of tiles
+func (r *SimpleRenderer) renderRegion(pos world.Pos, width, height int, frame Frame) {
+	known := frame.Known
+	for cellY := 0; cellY < height; cellY++ {
+		for cellX := 0; cellX < width; cellX++ {
+			worldPos := world.Pos{X: pos.X + cellX*r.zoom, Y: pos.Y + cellY*r.zoom}
+			ch, style := ' ', tcell.StyleDefault
+			switch {
+			case !r.w.InBounds(worldPos):
+				// The world is smaller than the screen
+			case r.zoom == 1 && known != nil && !known(world.TileToChunkPos(worldPos)):
+				ch, style = unknownChar, unknownStyle
+			case r.zoom == 1:
+				ch, style = r.getTileChar(worldPos, r.w.TileAt(worldPos))
+				if track, ok := r.w.Tracks[worldPos]; ok && track.HasSignal {
+					ch, style = signalChar(worldPos, track, frame.Blocks)
+				}
+			default:
+				summary := r.summariseCell(worldPos, r.zoom, 1, known)
+				ch, style = r.cellChar(world.Pos{X: worldPos.X / r.zoom, Y: worldPos.Y / r.zoom}, summary)
+			}
+			if frame.BlockOverlay && r.w.InBounds(worldPos) {
+				if bg, ok := r.blockBackground(worldPos, r.zoom, frame.Blocks); ok {
+					style = style.Background(bg)
+				}
+			}
+			screenY := height - 1 - cellY // Flip Y
+			r.screen.SetContent(cellX, screenY, ch, nil, style)
 		}
 	}
 }
 
-func (r *SimpleRenderer) renderTrains(pos world.Pos, width, height int) {
+// renderTrains draws trains over the map, when zoomed out a cell with any
+// car in it shows the car so trains are never hidden
+func (r *SimpleRenderer) renderTrains(pos world.Pos) {
+	width, height := r.ViewSize()
 	for _, t := range r.w.Trains {
 		// Assuming train limits of 100 - check the first car to see if its
 		// even possible to be on screen
@@ -71,20 +211,31 @@ func (r *SimpleRenderer) renderTrains(pos world.Pos, width, height int) {
 			}
 		}
 		for _, c := range t.Cars {
-			if c.X < pos.X || c.X >= pos.X+width || c.Y < pos.Y || c.Y >= pos.Y+height {
+			screenX, screenY, ok := r.worldToScreen(pos, world.Pos{X: c.X, Y: c.Y})
+			if !ok {
 				continue // Skip this car
 			}
 
 			ch, col := r.getTrainCarChar(c)
 			style := tcell.StyleDefault.Foreground(col)
-			screenX := c.X - pos.X
-			screenY := height - 1 - (c.Y - pos.Y)
-
 			r.screen.SetContent(screenX, screenY, ch, nil, style)
 		}
 	}
 }
 
+// renderZoomLabel shows the zoom in the bottom left corner of the map when
+// zoomed out
+func (r *SimpleRenderer) renderZoomLabel(height int) {
+	if r.zoom == 1 {
+		return
+	}
+	label := fmt.Sprintf(" 1:%d ", r.zoom)
+	style := tcell.StyleDefault.Background(tcell.ColorNavy).Foreground(tcell.ColorWhite)
+	for i, ch := range label {
+		r.screen.SetContent(i, height-1, ch, nil, style)
+	}
+}
+
 var (
 	grassChars  = []rune(".,'`:")
 	grassColors = []tcell.Color{
@@ -133,39 +284,90 @@ func (r *SimpleRenderer) getTileChar(pos world.Pos, t *types.Tile) (rune, tcell.
 		fgCol = mountainColors[(pos.X^pos.Y)%len(mountainColors)]
 
 	case types.TileTrack:
-		var trackChar rune
-		track := r.w.Tracks[pos]
-		switch track.Direction {
-		case types.DirNorth | types.DirSouth:
-			trackChar = '║' // vertical
-		case types.DirEast | types.DirWest:
-			trackChar = '═' // horizontal
-		case types.DirNorth | types.DirEast:
-			trackChar = '╚' // curve NE
-		case types.DirNorth | types.DirWest:
-			trackChar = '╝' // curve NW
-		case types.DirSouth | types.DirEast:
-			trackChar = '╔' // curve SE
-		case types.DirSouth | types.DirWest:
-			trackChar = '╗' // curve SW
-		case types.DirNorth | types.DirEast | types.DirWest:
-			trackChar = '╩' // T junction pointing up
-		case types.DirSouth | types.DirEast | types.DirWest:
-			trackChar = '╦' // T junction pointing down
-		case types.DirNorth | types.DirSouth | types.DirEast:
-			trackChar = '╠' // T junction pointing left
-		case types.DirNorth | types.DirSouth | types.DirWest:
-			trackChar = '╣' // T junction pointing right
-		case types.DirNorth | types.DirSouth | types.DirEast | types.DirWest:
-			trackChar = '╬' // cross
-		default:
-			trackChar = ' '
-		}
-		return trackChar, tcell.StyleDefault.Foreground(tcell.ColorGray)
+		return trackChar(r.w.Tracks[pos].Direction), tcell.StyleDefault.Foreground(tcell.ColorGray)
 	}
 	return ch, tcell.StyleDefault.Foreground(fgCol)
 }
 
+// trackChar picks the box drawing character for a track's directions
+func trackChar(dir types.Dir) rune {
+	switch dir {
+	case types.DirNorth | types.DirSouth, types.DirNorth, types.DirSouth:
+		return '║' // vertical, or a dead end
+	case types.DirEast | types.DirWest, types.DirEast, types.DirWest:
+		return '═' // horizontal, or a dead end
+	case types.DirNorth | types.DirEast:
+		return '╚' // curve NE
+	case types.DirNorth | types.DirWest:
+		return '╝' // curve NW
+	case types.DirSouth | types.DirEast:
+		return '╔' // curve SE
+	case types.DirSouth | types.DirWest:
+		return '╗' // curve SW
+	case types.DirNorth | types.DirEast | types.DirWest:
+		return '╩' // T junction pointing up
+	case types.DirSouth | types.DirEast | types.DirWest:
+		return '╦' // T junction pointing down
+	case types.DirNorth | types.DirSouth | types.DirEast:
+		return '╠' // T junction pointing left
+	case types.DirNorth | types.DirSouth | types.DirWest:
+		return '╣' // T junction pointing right
+	case types.DirNorth | types.DirSouth | types.DirEast | types.DirWest:
+		return '╬' // cross
+	default:
+		return ' '
+	}
+}
+
+func (r *SimpleRenderer) renderBuildOverlay(camPos world.Pos, width int, build BuildOverlay) {
+	toScreen := func(pos world.Pos) (int, int, bool) {
+		return r.worldToScreen(camPos, pos)
+	}
+
+	previewStyle := tcell.StyleDefault.Foreground(tcell.ColorAqua)
+	if build.PreviewErr != "" {
+		previewStyle = tcell.StyleDefault.Foreground(tcell.ColorRed)
+	}
+	for pos, dir := range build.Preview {
+		if x, y, ok := toScreen(pos); ok {
+			ch := trackChar(dir)
+			if dir == types.DirNone {
+				ch = '·'
+			}
+			r.screen.SetContent(x, y, ch, nil, previewStyle)
+		}
+	}
+
+	if build.Rejected != nil {
+		if x, y, ok := toScreen(*build.Rejected); ok {
+			ch, _, _, _ := r.screen.GetContent(x, y)
+			r.screen.SetContent(x, y, ch, nil, tcell.StyleDefault.Background(tcell.ColorDarkRed))
+		}
+	}
+
+	if x, y, ok := toScreen(build.Cursor); ok {
+		ch, _, style, _ := r.screen.GetContent(x, y)
+		if build.Deleting {
+			style = style.Background(tcell.ColorRed)
+		} else {
+			style = style.Reverse(true)
+		}
+		r.screen.SetContent(x, y, ch, nil, style)
+	}
+
+	status := build.Help
+	if build.PreviewErr != "" {
+		status = build.PreviewErr
+	}
+	statusStyle := tcell.StyleDefault.Background(tcell.ColorNavy).Foreground(tcell.ColorWhite)
+	for i, ch := range []rune(status) {
+		if i >= width {
+			break
+		}
+		r.screen.SetContent(i, 0, ch, nil, statusStyle)
+	}
+}
+
 func (r *SimpleRenderer) getTrainCarChar(c *trains.TrainCar) (rune, tcell.Color) {
 	switch c.Type {
 	case trains.CarTypeLocomotive:
@@ -177,7 +379,9 @@ func (r *SimpleRenderer) getTrainCarChar(c *trains.TrainCar) (rune, tcell.Color)
 	}
 }
 
-func (r *SimpleRenderer) renderInfoPanel(x, y, width, height int) {
+// renderInfoPanel draws the panel's border down the full height but only
+// writes lines into the top textHeight rows, leaving room for the minimap
+func (r *SimpleRenderer) renderInfoPanel(x, y, width, height, textHeight int, lines []string) {
 	borderStyle := tcell.StyleDefault.Foreground(tcell.ColorWhite)
 
 	// Draw border
@@ -198,11 +402,24 @@ func (r *SimpleRenderer) renderInfoPanel(x, y, width, height int) {
 		}
 	}
 
-	// TODO: Add actual info content here
-
+	textStyle := tcell.StyleDefault.Foreground(tcell.ColorWhite)
+	for i, line := range lines {
+		lineY := y + 2 + i
+		if lineY >= y+textHeight {
+			break
+		}
+		col := 0
+		for _, ch := range line {
+			if col >= width-3 {
+				break
+			}
+			r.screen.SetContent(x+2+col, lineY, ch, nil, textStyle)
+			col++
+		}
+	}
 }
 
-func (r *SimpleRenderer) renderChatPanel(x, y, width, height int, chatMessages []ChatMessage) {
+func (r *SimpleRenderer) renderChatPanel(x, y, width, height int, chat ChatPanel) {
 	borderStyle := tcell.StyleDefault.Foreground(tcell.ColorWhite)
 
 	// Draw top border
@@ -212,6 +429,9 @@ func (r *SimpleRenderer) renderChatPanel(x, y, width, height int, chatMessages [
 
 	// Title
 	title := " Chat "
+	if chat.Scroll > 0 {
+		title = fmt.Sprintf(" Chat (%d newer, PgDn) ", chat.Scroll)
+	}
 	for i, ch := range title {
 		r.screen.SetContent(x+2+i, y, ch, nil, borderStyle)
 	}
@@ -225,14 +445,18 @@ func (r *SimpleRenderer) renderChatPanel(x, y, width, height int, chatMessages [
 
 	// Render chat messages (bottom-up, most recent at bottom)
 	availableHeight := height - 1 // Subtract border
+	if chat.Typing {
+		availableHeight-- // Leave the last line for the input
+	}
+	endIdx := len(chat.Messages) - chat.Scroll
 	startIdx := 0
-	if len(chatMessages) > availableHeight {
-		startIdx = len(chatMessages) - availableHeight
+	if endIdx > availableHeight {
+		startIdx = endIdx - availableHeight
 	}
 
 	lineY := y + 1
-	for i := startIdx; i < len(chatMessages) && lineY < y+height; i++ {
-		msg := chatMessages[i]
+	for i := startIdx; i < endIdx && lineY < y+height; i++ {
+		msg := chat.Messages[i]
 
 		// Format: [Author] Message
 		var displayText string
@@ -255,4 +479,31 @@ func (r *SimpleRenderer) renderChatPanel(x, y, width, height int, chatMessages [
 
 		lineY++
 	}
+
+	if !chat.Typing {
+		r.screen.HideCursor()
+		return
+	}
+	r.renderChatInput(x+1, y+height-1, width-2, chat.Input, chat.Cursor)
+}
+
+// renderChatInput draws the line being typed, scrolling it sideways so the
+// cursor is always on screen
+func (r *SimpleRenderer) renderChatInput(x, y, width int, input []rune, cursor int) {
+	const prompt = "> "
+	promptStyle := tcell.StyleDefault.Foreground(tcell.ColorYellow)
+	for i, ch := range prompt {
+		r.screen.SetContent(x+i, y, ch, nil, promptStyle)
+	}
+	x += len(prompt)
+	width -= len(prompt)
+
+	offset := 0
+	if cursor >= width {
+		offset = cursor - width + 1
+	}
+	for col := 0; col < width && offset+col < len(input); col++ {
+		r.screen.SetContent(x+col, y, input[offset+col], nil, tcell.StyleDefault)
+	}
+	r.screen.ShowCursor(x+cursor-offset, y)
 }
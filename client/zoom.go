@@ -0,0 +1,68 @@
+package client
+
+import (
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+)
+
+// zoomLevels are how many tiles along each side a screen cell can cover,
+// closest first
+var zoomLevels = []int{1, 2, 4, 8}
+
+// cellSummary is what the square of tiles under a zoomed out cell looks like
+type cellSummary struct {
+	tileType types.TileType
+	// unknown is set when most of the square is in chunks we don't have
+	unknown bool
+	// track is every direction the track in the square runs, DirNone if the
+	// square has no track
+	track types.Dir
+}
+
+// summariseCell looks at every step'th tile of the size x size square starting
+// at origin, settling on whichever tile type most of them are
+func (r *SimpleRenderer) summariseCell(origin world.Pos, size, step int, known func(chunkPos world.Pos) bool) cellSummary {
+	var counts [types.NumTileTypes]int
+	summary := cellSummary{tileType: types.TileGrass}
+	unknown, bestCount := 0, 0
+	for dy := 0; dy < size; dy += step {
+		for dx := 0; dx < size; dx += step {
+			pos := world.Pos{X: origin.X + dx, Y: origin.Y + dy}
+			if !r.w.InBounds(pos) {
+				continue
+			}
+			if known != nil && !known(world.TileToChunkPos(pos)) {
+				unknown++
+				continue
+			}
+			if track, ok := r.w.Tracks[pos]; ok {
+				summary.track |= track.Direction
+			}
+			tileType := r.w.TileAt(pos).Type
+			if int(tileType) >= len(counts) {
+				continue
+			}
+			counts[tileType]++
+			if counts[tileType] > bestCount {
+				summary.tileType, bestCount = tileType, counts[tileType]
+			}
+		}
+	}
+	summary.unknown = unknown > bestCount
+	return summary
+}
+
+// cellChar picks what a zoomed out cell shows. Track wins over the terrain
+// around it so networks stay visible however far out we are
+func (r *SimpleRenderer) cellChar(cellPos world.Pos, summary cellSummary) (rune, tcell.Style) {
+	switch {
+	case summary.track != types.DirNone:
+		return trackChar(summary.track), tcell.StyleDefault.Foreground(tcell.ColorGray)
+	case summary.unknown:
+		return unknownChar, unknownStyle
+	}
+	// The terrain characters vary with position, using the cell rather than a
+	// tile keeps that variety when zoomed out
+	return r.getTileChar(cellPos, &types.Tile{Type: summary.tileType})
+}
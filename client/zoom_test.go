@@ -0,0 +1,131 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/gdamore/tcell"
+)
+
+func fill(w *world.World, min, max world.Pos, tileType types.TileType) {
+	for y := min.Y; y <= max.Y; y++ {
+		for x := min.X; x <= max.X; x++ {
+			w.Tiles[y][x].Type = tileType
+		}
+	}
+}
+
+func TestSummariseCellPicksMostCommonTile(t *testing.T) {
+	w := world.New(8, 8)
+	r := &SimpleRenderer{w: w}
+
+	// Three of the four rows are water
+	fill(w, world.Pos{X: 0, Y: 1}, world.Pos{X: 3, Y: 3}, types.TileWater)
+	if got := r.summariseCell(world.Pos{}, 4, 1, nil); got.tileType != types.TileWater {
+		t.Errorf("got %s, want water", got.tileType)
+	}
+	// Only one row of the next square down is water
+	if got := r.summariseCell(world.Pos{X: 0, Y: 3}, 4, 1, nil); got.tileType != types.TileGrass {
+		t.Errorf("got %s, want grass", got.tileType)
+	}
+}
+
+func TestSummariseCellSamplesEveryStep(t *testing.T) {
+	w := world.New(8, 8)
+	r := &SimpleRenderer{w: w}
+	// Mountains only on the tiles a step of 2 looks at
+	for _, pos := range []world.Pos{{X: 0, Y: 0}, {X: 2, Y: 0}, {X: 0, Y: 2}, {X: 2, Y: 2}} {
+		w.Tiles[pos.Y][pos.X].Type = types.TileMountain
+	}
+
+	if got := r.summariseCell(world.Pos{}, 4, 2, nil); got.tileType != types.TileMountain {
+		t.Errorf("stepping by 2 got %s, want mountain", got.tileType)
+	}
+	if got := r.summariseCell(world.Pos{}, 4, 1, nil); got.tileType != types.TileGrass {
+		t.Errorf("looking at every tile got %s, want grass", got.tileType)
+	}
+}
+
+func TestSummariseCellKeepsTrack(t *testing.T) {
+	w := world.New(8, 8)
+	r := &SimpleRenderer{w: w}
+	w.AddTrack(world.Pos{X: 1, Y: 1}, &types.Track{Direction: types.DirEast | types.DirWest})
+	w.AddTrack(world.Pos{X: 2, Y: 1}, &types.Track{Direction: types.DirWest | types.DirNorth})
+
+	got := r.summariseCell(world.Pos{}, 4, 1, nil)
+	if want := types.Dir(types.DirEast | types.DirWest | types.DirNorth); got.track != want {
+		t.Errorf("track got %s, want %s", got.track, want)
+	}
+	// A couple of tiles of track doesn't make the square track
+	if got.tileType != types.TileGrass {
+		t.Errorf("got %s, want grass", got.tileType)
+	}
+	if got := r.summariseCell(world.Pos{X: 4, Y: 4}, 4, 1, nil); got.track != types.DirNone {
+		t.Errorf("square without track got %s", got.track)
+	}
+}
+
+func TestSummariseCellUnknownChunks(t *testing.T) {
+	w := world.New(2*world.ChunkSize, world.ChunkSize)
+	r := &SimpleRenderer{w: w}
+	// One column of the square is in the first chunk, the rest in the second
+	origin := world.Pos{X: world.ChunkSize - 1, Y: 0}
+	onlyChunk := func(chunkPos world.Pos) func(world.Pos) bool {
+		return func(pos world.Pos) bool { return pos == chunkPos }
+	}
+
+	if got := r.summariseCell(origin, 4, 1, onlyChunk(world.Pos{X: 0})); !got.unknown {
+		t.Error("square mostly in a missing chunk isn't unknown")
+	}
+	if got := r.summariseCell(origin, 4, 1, onlyChunk(world.Pos{X: 1})); got.unknown {
+		t.Error("square mostly in a known chunk is unknown")
+	}
+}
+
+func TestSummariseCellIgnoresTilesOffTheWorld(t *testing.T) {
+	w := world.New(6, 6)
+	r := &SimpleRenderer{w: w}
+	fill(w, world.Pos{X: 4, Y: 4}, world.Pos{X: 5, Y: 5}, types.TileWater)
+
+	// Only the water corner of the square is in the world
+	got := r.summariseCell(world.Pos{X: 4, Y: 4}, 4, 1, func(world.Pos) bool { return true })
+	if got.tileType != types.TileWater || got.unknown {
+		t.Errorf("got %+v, want known water", got)
+	}
+}
+
+func TestZoomKeepsTheMiddleOfTheView(t *testing.T) {
+	c := testClient(t, world.New(1000, 1000))
+	c.camPos = world.Pos{X: 100, Y: 100}
+	middle := func() world.Pos {
+		width, height := c.viewCells()
+		return c.cellToWorld(width/2, height/2)
+	}
+	before := middle()
+
+	c.handleKey(runeKey('-'))
+	if c.r.Zoom() != 2 {
+		t.Fatalf("zoom is %d after zooming out, want 2", c.r.Zoom())
+	}
+	if width, height := c.r.ViewSize(); width != 80 || height != 40 {
+		t.Errorf("view is %dx%d tiles, want twice as much of the world", width, height)
+	}
+	if got := middle(); got != before {
+		t.Errorf("middle of the view moved from %v to %v", before, got)
+	}
+
+	// The camera moves the same number of cells
+	camPos := c.camPos
+	c.handleKey(key(tcell.KeyRight))
+	if c.camPos.X != camPos.X+2*c.camSpeed {
+		t.Errorf("camera moved %d tiles, want %d", c.camPos.X-camPos.X, 2*c.camSpeed)
+	}
+
+	for range len(zoomLevels) + 1 {
+		c.handleKey(runeKey('+'))
+	}
+	if c.r.Zoom() != zoomLevels[0] {
+		t.Errorf("zoom is %d, want it to stop at %d", c.r.Zoom(), zoomLevels[0])
+	}
+}
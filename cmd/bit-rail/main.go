@@ -1,12 +1,21 @@
 package main
 
 import (
+	"crypto/rand"
+	"errors"
 	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/danharasymiw/bit-rail/accounts"
 	"github.com/danharasymiw/bit-rail/client"
 	"github.com/danharasymiw/bit-rail/engine"
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/world"
 	"github.com/danharasymiw/bit-rail/world/test_worlds"
 	"github.com/sirupsen/logrus"
 )
@@ -14,33 +23,143 @@ import (
 func main() {
 	serverMode := flag.Bool("server", false, "Run as headless server")
 	localMode := flag.Bool("local", false, "Run server and client together")
+	loadPath := flag.String("load", "", "Load the world from this save file instead of generating one")
+	savePath := flag.String("save", "", "Save the world to this file periodically and when the server shuts down")
+	autosaveTicks := flag.Uint64("autosave-ticks", 2000, "Ticks between autosaves, 0 to only save on shutdown")
+	autosaveKeep := flag.Int("autosave-keep", 3, "Number of previous saves to keep alongside the latest")
+	jsonProtocol := flag.Bool("json-protocol", false, "Talk to the server in JSON instead of binary, for debugging")
+	accountsPath := flag.String("accounts", "", "File to keep player accounts in, accounts are forgotten on restart without one")
+	username := flag.String("user", "", "Account to log in as, defaults to your system username")
+	password := flag.String("password", os.Getenv("BIT_RAIL_PASSWORD"), "Account password, defaults to $BIT_RAIL_PASSWORD")
+	register := flag.Bool("register", false, "Create the account if it doesn't exist yet")
+	operators := flag.String("op", "", "Comma separated accounts that can run admin commands")
 	flag.Parse()
 
 	if *serverMode {
-		w := test_worlds.NewPerlinWorld(123, 123)
+		w := loadWorld(*loadPath)
 		eng := engine.New(w, 150*time.Millisecond)
-		eng.Run(make(chan struct{}), make(chan struct{}))
+		if *savePath != "" {
+			eng.EnableAutosave(*savePath, *autosaveTicks, *autosaveKeep)
+		}
+		store := useAccounts(eng, *accountsPath)
+		makeOperators(store, strings.Split(*operators, ","))
+
+		quitCh := make(chan struct{})
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigCh
+			close(quitCh)
+		}()
+
+		eng.Run(quitCh, make(chan struct{}))
 	} else if *localMode {
-		w := test_worlds.NewPerlinWorld(123, 123)
+		w := loadWorld(*loadPath)
 		eng := engine.New(w, 150*time.Millisecond)
+		if *savePath != "" {
+			eng.EnableAutosave(*savePath, *autosaveTicks, *autosaveKeep)
+		}
+
+		store := useAccounts(eng, *accountsPath)
 
 		c, quitCh := client.New()
+		if *jsonProtocol {
+			c.SetCodec(message.JSONCodec{})
+		}
+		if *password == "" {
+			c.SetCredentials(*username, "", false)
+			localPassword, localRegister, err := localCredentials(store, c.Username())
+			if err != nil {
+				log.Fatalf("Failed to set up the local account: %v", err)
+			}
+			c.SetCredentials(*username, localPassword, localRegister)
+		} else {
+			c.SetCredentials(*username, *password, *register)
+		}
+		// It's your game so you get the admin commands
+		makeOperators(store, append(strings.Split(*operators, ","), c.Username()))
 		readyCh := make(chan struct{})
+		doneCh := make(chan struct{})
 
-		go eng.Run(quitCh, readyCh)
+		go func() {
+			eng.Run(quitCh, readyCh)
+			close(doneCh)
+		}()
 
 		// Wait for server to be ready
 		<-readyCh
 		logrus.Info("Server ready, starting client...")
 
+		// The client stops the engine when it exits, even if it failed
 		if err := c.Run(); err != nil {
 			logrus.Printf("Client error: %v", err)
 		}
+
+		// Let the engine finish its shutdown save
+		<-doneCh
 	} else {
 		// Default: Run as client only
 		c, _ := client.New()
+		if *jsonProtocol {
+			c.SetCodec(message.JSONCodec{})
+		}
+		c.SetCredentials(*username, *password, *register)
 		if err := c.Run(); err != nil {
 			log.Fatal(err)
 		}
 	}
 }
+
+func useAccounts(eng *engine.Engine, path string) *accounts.Store {
+	store := accounts.NewMemoryStore()
+	if path == "" {
+		logrus.Warn("No accounts file given, accounts will be forgotten when the server stops")
+	} else {
+		var err error
+		store, err = accounts.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open accounts in %s: %v", path, err)
+		}
+	}
+	eng.UseAccounts(store)
+	return store
+}
+
+// localCredentials makes up a password for the local player, as local games
+// don't need a real one. An account they already have keeps its ID and is
+// given the new password, so the same accounts file works every time
+func localCredentials(store *accounts.Store, username string) (password string, register bool, err error) {
+	password = rand.Text()
+	err = store.SetPassword(username, password)
+	if errors.Is(err, accounts.ErrUnknownAccount) {
+		return password, true, nil
+	}
+	return password, false, err
+}
+
+// makeOperators gives the accounts admin commands, including ones that haven't
+// been registered yet
+func makeOperators(store *accounts.Store, usernames []string) {
+	for _, name := range usernames {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if err := store.SetOperator(name, true); err != nil {
+			logrus.WithField("username", name).Warnf("Failed to make operator: %v", err)
+		}
+	}
+}
+
+func loadWorld(path string) *world.World {
+	if path == "" {
+		return test_worlds.NewPerlinWorld(123, 123)
+	}
+
+	w, err := world.LoadFile(path)
+	if err != nil {
+		log.Fatalf("Failed to load world from %s: %v", path, err)
+	}
+	logrus.Infof("Loaded world from %s", path)
+	return w
+}
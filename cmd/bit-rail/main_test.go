@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/accounts"
+)
+
+// TestLocalCredentialsAcrossRestarts starts local mode twice against the same
+// accounts file, logging in the way the server does each time
+func TestLocalCredentialsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+
+	var ids []string
+	for start := range 2 {
+		store, err := accounts.Open(path)
+		if err != nil {
+			t.Fatalf("start %d: open: %v", start, err)
+		}
+		password, register, err := localCredentials(store, "alice")
+		if err != nil {
+			t.Fatalf("start %d: %v", start, err)
+		}
+		if register != (start == 0) {
+			t.Errorf("start %d: register is %v, want it only the first time", start, register)
+		}
+
+		var account *accounts.Account
+		if register {
+			account, err = store.Register("alice", password)
+		} else {
+			account, err = store.Authenticate("alice", password)
+		}
+		if err != nil {
+			t.Fatalf("start %d: login: %v", start, err)
+		}
+		ids = append(ids, account.ID.String())
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("got account %s then %s, want the same one both times", ids[0], ids[1])
+	}
+}
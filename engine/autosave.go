@@ -0,0 +1,149 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/sirupsen/logrus"
+)
+
+type autosaver struct {
+	path       string
+	everyTicks uint64
+	keep       int
+
+	// saveCh hands snapshots to the goroutine writing them to disk, so a slow
+	// disk never holds up a tick
+	saveCh chan snapshot
+	doneCh chan struct{}
+}
+
+// snapshot is the world serialised on the tick goroutine, ready to be written
+type snapshot struct {
+	tick uint64
+	data []byte
+}
+
+// EnableAutosave saves the world to path every everyTicks ticks and when the
+// engine shuts down. The previous keep saves are kept alongside it as path.1,
+// path.2 and so on, newest first. everyTicks of 0 only saves on shutdown
+func (e *Engine) EnableAutosave(path string, everyTicks uint64, keep int) {
+	e.autosaver = &autosaver{
+		path:       path,
+		everyTicks: everyTicks,
+		keep:       keep,
+		saveCh:     make(chan snapshot, 1),
+		doneCh:     make(chan struct{}),
+	}
+	go e.autosaver.writeLoop()
+}
+
+func (e *Engine) autosaveDue() bool {
+	return e.autosaver != nil && e.autosaver.everyTicks > 0 && e.tickCount%e.autosaver.everyTicks == 0
+}
+
+// autosave serialises the world and queues it to be written. A save is
+// skipped if the last one is still being written, the next will catch up
+func (e *Engine) autosave() {
+	if e.autosaver == nil {
+		return
+	}
+	snap, ok := e.snapshot()
+	if !ok {
+		return
+	}
+	select {
+	case e.autosaver.saveCh <- snap:
+	default:
+		logrus.WithField("tick", e.tickCount).Warn("Skipping autosave, the last one is still being written")
+	}
+}
+
+// finishAutosave saves the world one last time and waits for it to be written
+func (e *Engine) finishAutosave() {
+	if e.autosaver == nil {
+		return
+	}
+	if snap, ok := e.snapshot(); ok {
+		e.autosaver.saveCh <- snap
+	}
+	close(e.autosaver.saveCh)
+	<-e.autosaver.doneCh
+}
+
+func (e *Engine) snapshot() (snapshot, bool) {
+	var buf bytes.Buffer
+	if err := e.w.Save(&buf); err != nil {
+		logrus.WithField("tick", e.tickCount).WithError(err).Error("Failed to serialise world")
+		return snapshot{}, false
+	}
+	return snapshot{tick: e.tickCount, data: buf.Bytes()}, true
+}
+
+func (a *autosaver) writeLoop() {
+	defer close(a.doneCh)
+	for snap := range a.saveCh {
+		entry := logrus.WithField("path", a.path).WithField("tick", snap.tick)
+		if err := rotateSnapshots(a.path, a.keep); err != nil {
+			entry.WithError(err).Warn("Failed to rotate previous saves")
+		}
+		if err := world.WriteSaveFile(a.path, snap.data); err != nil {
+			entry.WithError(err).Error("Failed to save world")
+			continue
+		}
+		entry.Debug("Saved world")
+	}
+}
+
+// rotateSnapshots shifts path.1 .. path.(keep-1) up by one and links the
+// current save in as path.1, so the save about to be written can replace path
+// without losing it. Filesystems without hard links get a copy instead
+func rotateSnapshots(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	for i := keep - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", path, i)
+		to := fmt.Sprintf("%s.%d", path, i+1)
+		if err := os.Rename(from, to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	first := path + ".1"
+	if err := os.Remove(first); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(path, first); err == nil {
+		return nil
+	}
+	return copyFile(path, first)
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(to)
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,117 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+)
+
+func TestAutosaveDue(t *testing.T) {
+	e := New(world.New(4, 4), time.Millisecond)
+	e.tickCount = 10
+	if e.autosaveDue() {
+		t.Error("due without autosave enabled")
+	}
+
+	e.EnableAutosave(filepath.Join(t.TempDir(), "world.sav"), 5, 1)
+	if !e.autosaveDue() {
+		t.Error("not due on a multiple of the interval")
+	}
+	e.tickCount = 11
+	if e.autosaveDue() {
+		t.Error("due between intervals")
+	}
+
+	e.EnableAutosave(filepath.Join(t.TempDir(), "world.sav"), 0, 1)
+	e.tickCount = 0
+	if e.autosaveDue() {
+		t.Error("due when only saving on shutdown")
+	}
+}
+
+func TestAutosaveKeepsPreviousSaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "world.sav")
+	w := world.New(10, 10)
+	e := New(w, time.Millisecond)
+
+	// Each save has one more track than the last so they can be told apart.
+	// Saves are written in the background, finishing waits for each one
+	for x := range 4 {
+		e.EnableAutosave(path, 1, 2)
+		w.AddTrack(world.Pos{X: x, Y: 1}, &types.Track{Direction: types.DirEast | types.DirWest})
+		e.finishAutosave()
+	}
+
+	for file, tracks := range map[string]int{path: 4, path + ".1": 3, path + ".2": 2} {
+		saved, err := world.LoadFile(file)
+		if err != nil {
+			t.Fatalf("loading %s: %v", filepath.Base(file), err)
+		}
+		if len(saved.Tracks) != tracks {
+			t.Errorf("%s has %d tracks, want %d", filepath.Base(file), len(saved.Tracks), tracks)
+		}
+	}
+	for _, file := range []string{path + ".3", path + ".tmp"} {
+		if _, err := os.Stat(file); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s was left behind", filepath.Base(file))
+		}
+	}
+}
+
+func TestAutosaveDoesntWaitForTheWriter(t *testing.T) {
+	e := New(world.New(4, 4), time.Millisecond)
+	// No writer is running, so the first save fills the queue and the rest
+	// have to be skipped rather than block the tick
+	e.autosaver = &autosaver{path: filepath.Join(t.TempDir(), "world.sav"), saveCh: make(chan snapshot, 1)}
+
+	done := make(chan struct{})
+	go func() {
+		e.autosave()
+		e.autosave()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("autosave blocked on a busy writer")
+	}
+	if len(e.autosaver.saveCh) != 1 {
+		t.Errorf("%d saves queued, want 1", len(e.autosaver.saveCh))
+	}
+}
+
+func TestRotateSnapshotsWithoutSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "world.sav")
+	if err := rotateSnapshots(path, 3); err != nil {
+		t.Fatalf("rotating before the first save: %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := os.Stat(fmt.Sprintf("%s.%d", path, i)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("rotating nothing made %s.%d", filepath.Base(path), i)
+		}
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "world.sav"), filepath.Join(dir, "world.sav.1")
+	if err := os.WriteFile(from, []byte("saved"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyFile(from, to); err != nil {
+		t.Fatalf("copy: %v", err)
+	}
+	if got, err := os.ReadFile(to); err != nil || string(got) != "saved" {
+		t.Errorf("copy has %q (%v), want the original's contents", got, err)
+	}
+	if err := copyFile(filepath.Join(dir, "missing"), to); err == nil {
+		t.Error("copying a file that doesn't exist didn't fail")
+	}
+}
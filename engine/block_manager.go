@@ -1,41 +1,80 @@
 package engine
 
 import (
+	"slices"
+
+	"github.com/danharasymiw/bit-rail/trains"
 	"github.com/danharasymiw/bit-rail/types"
 	"github.com/danharasymiw/bit-rail/world"
 )
 
 type blockManager struct {
 	w *world.World
+
+	// tiles are the tracks in every block that has been worked out, so a
+	// block can be found without going through the whole world. blocks is
+	// the other way round and remembers a block after its track is gone
+	tiles  map[*types.Block][]world.Pos
+	blocks map[world.Pos]*types.Block
+	// chunks caches chunksOf, blocks change hands far more often than shape
+	chunks map[*types.Block][]world.Pos
+	// changed are the blocks that were claimed or released since the last
+	// takeChanged
+	changed map[*types.Block]struct{}
+	// reserved are the blocks each train claimed ahead of itself, keyed by
+	// occupier ID
+	reserved map[string][]*types.Block
 }
 
 func newBlockManager(w *world.World) *blockManager {
-	return &blockManager{
-		w: w,
+	bm := &blockManager{
+		w:        w,
+		tiles:    make(map[*types.Block][]world.Pos),
+		blocks:   make(map[world.Pos]*types.Block),
+		chunks:   make(map[*types.Block][]world.Pos),
+		changed:  make(map[*types.Block]struct{}),
+		reserved: make(map[string][]*types.Block),
 	}
+	// Saves come with their blocks already worked out
+	for pos, track := range w.Tracks {
+		if track.Block != nil {
+			bm.tiles[track.Block] = append(bm.tiles[track.Block], pos)
+			bm.blocks[pos] = track.Block
+		}
+	}
+	return bm
 }
 
-func (bm *blockManager) calculateBlock(x, y int, track *types.Track) *types.Block {
-	maxDistance := 250
+// blockAt returns the block for the track at pos, calculating it if needed
+func (bm *blockManager) blockAt(pos world.Pos) *types.Block {
+	track := bm.w.Tracks[pos]
+	if track == nil {
+		return nil
+	}
+	if track.Block == nil {
+		bm.calculateBlock(pos.X, pos.Y, track)
+	}
+	return track.Block
+}
 
+// calculateBlock floods outwards from the track, stopping at signals, and
+// assigns every track reached to the same block
+func (bm *blockManager) calculateBlock(x, y int, track *types.Track) *types.Block {
 	type QueueItem struct {
-		track       *types.Track
-		pos         world.Pos
-		enteredFrom types.Dir
-		distance    int
+		track *types.Track
+		pos   world.Pos
 	}
 
 	var queue []QueueItem
 	queue = append(queue,
 		QueueItem{
-			track:       track,
-			pos:         world.Pos{X: x, Y: y},
-			enteredFrom: types.OppositeDir(track.SignalDir),
-			distance:    0,
+			track: track,
+			pos:   world.Pos{X: x, Y: y},
 		})
 
 	var (
 		tracksInFlood []*types.Track
+		positions     []world.Pos
 		foundBlock    *types.Block
 		visited       = map[*types.Track]bool{}
 	)
@@ -43,47 +82,190 @@ func (bm *blockManager) calculateBlock(x, y int, track *types.Track) *types.Bloc
 		curr := queue[0]
 		queue = queue[1:]
 
-		if visited[curr.track] || curr.distance > maxDistance {
+		if visited[curr.track] {
 			continue
 		}
 		visited[curr.track] = true
 
-		if curr.track.Block != nil {
+		// Reuse a block found in the flood so existing occupancy is kept
+		if curr.track.Block != nil && foundBlock == nil {
 			foundBlock = curr.track.Block
-			break
-		}
-		if curr.track.HasSignal && curr.track.SignalDir&curr.enteredFrom != 0 {
-			if foundBlock == nil {
-				foundBlock = types.NewBlock()
-			}
-			tracksInFlood = append(tracksInFlood, curr.track)
-			continue
 		}
+		tracksInFlood = append(tracksInFlood, curr.track)
+		positions = append(positions, curr.pos)
 
 		for d := types.Dir(types.DirNorth); d <= types.DirWest; d <<= 1 {
 			if curr.track.Direction&d == 0 {
 				continue
 			}
+			// A signal splits the track into two blocks at the edge it faces
+			if curr.track.HasSignal && curr.track.SignalDir&d != 0 {
+				continue
+			}
 
-			nextPos := nextPos(curr.pos, d)
-			neighbourTile := bm.w.TileAt(nextPos)
-			// TODO: do we really need this check? We can just check if the track is in the map?
-			if neighbourTile.Type != types.TileTrack {
+			nextPos := curr.pos.Neighbour(d)
+			neighbour, ok := bm.w.Tracks[nextPos]
+			if !ok || neighbour.Direction&types.OppositeDir(d) == 0 {
 				continue
 			}
-			if neighbour := bm.w.Tracks[nextPos]; neighbour.Direction&types.OppositeDir(d) != 0 {
-				queue = append(queue, QueueItem{
-					track:       neighbour,
-					pos:         nextPos,
-					enteredFrom: types.OppositeDir(d),
-					distance:    curr.distance + 1,
-				})
+			if neighbour.HasSignal && neighbour.SignalDir&types.OppositeDir(d) != 0 {
+				continue
 			}
+			queue = append(queue, QueueItem{
+				track: neighbour,
+				pos:   nextPos,
+			})
 		}
+	}
 
+	if foundBlock == nil {
+		foundBlock = types.NewBlock()
 	}
 	for _, track := range tracksInFlood {
 		track.Block = foundBlock
 	}
+	bm.tiles[foundBlock] = positions
+	delete(bm.chunks, foundBlock)
+	for _, pos := range positions {
+		bm.blocks[pos] = foundBlock
+	}
 	return foundBlock
 }
+
+// trackChanged throws away the blocks around pos so they get recalculated the
+// next time they're needed. Callers need to re-occupy blocks for trains
+func (bm *blockManager) trackChanged(pos world.Pos) {
+	stale := map[*types.Block]bool{}
+	for _, p := range []world.Pos{pos, pos.Neighbour(types.DirNorth), pos.Neighbour(types.DirEast), pos.Neighbour(types.DirSouth), pos.Neighbour(types.DirWest)} {
+		if block, ok := bm.blocks[p]; ok {
+			stale[block] = true
+		}
+	}
+	for block := range stale {
+		for _, p := range bm.tiles[block] {
+			if track, ok := bm.w.Tracks[p]; ok && track.Block == block {
+				track.Block = nil
+			}
+			delete(bm.blocks, p)
+		}
+		delete(bm.tiles, block)
+		delete(bm.chunks, block)
+		delete(bm.changed, block)
+	}
+}
+
+// claim hands the block to the occupier, nil frees it
+func (bm *blockManager) claim(block *types.Block, occupier types.Occupier) {
+	block.OccupiedBy = occupier
+	bm.changed[block] = struct{}{}
+}
+
+// takeChanged returns the blocks claimed or released since it was last called
+func (bm *blockManager) takeChanged() []*types.Block {
+	if len(bm.changed) == 0 {
+		return nil
+	}
+	blocks := make([]*types.Block, 0, len(bm.changed))
+	for block := range bm.changed {
+		blocks = append(blocks, block)
+	}
+	clear(bm.changed)
+	return blocks
+}
+
+// chunksOf returns the chunks the block has track in
+func (bm *blockManager) chunksOf(block *types.Block) []world.Pos {
+	if chunks, ok := bm.chunks[block]; ok {
+		return chunks
+	}
+	var chunks []world.Pos
+	for _, pos := range bm.tiles[block] {
+		if chunk := world.TileToChunkPos(pos); !slices.Contains(chunks, chunk) {
+			chunks = append(chunks, chunk)
+		}
+	}
+	bm.chunks[block] = chunks
+	return chunks
+}
+
+// isFree reports whether the train may cross from one tile to the next
+// without running into a block held by a different train
+func (bm *blockManager) isFree(t *trains.Train, from, to world.Pos) bool {
+	next := bm.blockAt(to)
+	if next == nil || next == bm.blockAt(from) {
+		return true
+	}
+	return next.OccupiedBy == nil || next.OccupiedBy.OccupierID() == t.OccupierID()
+}
+
+// tryEnter claims the block at to for the train if it is crossing into it from
+// another block. It returns false if the block is held by a different train
+func (bm *blockManager) tryEnter(t *trains.Train, from, to world.Pos) bool {
+	if !bm.isFree(t, from, to) {
+		return false
+	}
+	if next := bm.blockAt(to); next != nil && next != bm.blockAt(from) {
+		bm.claim(next, t)
+	}
+	return true
+}
+
+// leave releases the block at pos once none of the train's cars are left in it
+func (bm *blockManager) leave(t *trains.Train, pos world.Pos) {
+	block := bm.blockAt(pos)
+	if block == nil || block.OccupiedBy == nil || block.OccupiedBy.OccupierID() != t.OccupierID() {
+		return
+	}
+	if bm.hasCarIn(t, block) {
+		return
+	}
+	bm.claim(block, nil)
+}
+
+// hasCarIn reports whether any of the train's cars are in the block
+func (bm *blockManager) hasCarIn(t *trains.Train, block *types.Block) bool {
+	for _, c := range t.Cars {
+		if bm.blockAt(world.Pos{X: c.X, Y: c.Y}) == block {
+			return true
+		}
+	}
+	return false
+}
+
+// reserve claims the free blocks ahead of the train so nothing else can take
+// them while it is committed to entering. Blocks it reserved before that it no
+// longer needs are released, unless its cars have got into them since
+func (bm *blockManager) reserve(t *trains.Train, ahead []*types.Block) {
+	id := t.OccupierID()
+	for _, block := range bm.reserved[id] {
+		if slices.Contains(ahead, block) || block.OccupiedBy == nil || block.OccupiedBy.OccupierID() != id {
+			continue
+		}
+		// Blocks thrown away by a track change were released with them
+		if _, ok := bm.tiles[block]; !ok || bm.hasCarIn(t, block) {
+			continue
+		}
+		bm.claim(block, nil)
+	}
+
+	for _, block := range ahead {
+		if block.OccupiedBy == nil {
+			bm.claim(block, t)
+		}
+	}
+	if len(ahead) == 0 {
+		delete(bm.reserved, id)
+		return
+	}
+	bm.reserved[id] = ahead
+}
+
+// occupy claims every free block the train currently sits in
+func (bm *blockManager) occupy(t *trains.Train) {
+	for _, c := range t.Cars {
+		block := bm.blockAt(world.Pos{X: c.X, Y: c.Y})
+		if block != nil && block.OccupiedBy == nil {
+			bm.claim(block, t)
+		}
+	}
+}
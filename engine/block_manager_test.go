@@ -0,0 +1,227 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/google/uuid"
+)
+
+func TestCalculateBlockSplitsAtSignals(t *testing.T) {
+	tests := []struct {
+		name    string
+		signals map[int]types.Dir
+		// blocks gives the block index for each x along the line
+		blocks []int
+	}{
+		{"no signals", nil, []int{0, 0, 0, 0, 0, 0, 0, 0}},
+		{"facing east", map[int]types.Dir{3: types.DirEast}, []int{0, 0, 0, 0, 1, 1, 1, 1}},
+		{"facing west", map[int]types.Dir{3: types.DirWest}, []int{0, 0, 0, 1, 1, 1, 1, 1}},
+		{"two signals", map[int]types.Dir{1: types.DirEast, 5: types.DirWest}, []int{0, 0, 1, 1, 1, 2, 2, 2}},
+		{"back to back", map[int]types.Dir{3: types.DirEast, 4: types.DirWest}, []int{0, 0, 0, 0, 1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := world.New(10, 10)
+			straightLine(w, 2, 0, len(tt.blocks)-1)
+			for x, dir := range tt.signals {
+				track := w.Tracks[world.Pos{X: x, Y: 2}]
+				track.HasSignal, track.SignalDir = true, dir
+			}
+			bm := newBlockManager(w)
+
+			seen := map[int]*types.Block{}
+			for x, want := range tt.blocks {
+				block := bm.blockAt(world.Pos{X: x, Y: 2})
+				if block == nil {
+					t.Fatalf("no block at x %d", x)
+				}
+				if seen[want] == nil {
+					seen[want] = block
+				}
+				if block != seen[want] {
+					t.Errorf("x %d isn't in block %d", x, want)
+				}
+			}
+			if n := len(tt.blocks); len(seen) != tt.blocks[n-1]+1 {
+				t.Errorf("got %d blocks, want %d", len(seen), tt.blocks[n-1]+1)
+			}
+		})
+	}
+
+	w := world.New(10, 10)
+	if block := newBlockManager(w).blockAt(world.Pos{X: 1, Y: 1}); block != nil {
+		t.Error("grass has a block")
+	}
+}
+
+func testTrainAt(xs ...int) *trains.Train {
+	t := &trains.Train{ID: uuid.New()}
+	for _, x := range xs {
+		t.Cars = append(t.Cars, &trains.TrainCar{X: x, Y: 2, Direction: types.DirEast})
+	}
+	return t
+}
+
+func TestBlocksHoldOneTrain(t *testing.T) {
+	w := world.New(10, 10)
+	straightLine(w, 2, 0, 9)
+	w.Tracks[world.Pos{X: 4, Y: 2}].HasSignal = true
+	w.Tracks[world.Pos{X: 4, Y: 2}].SignalDir = types.DirEast
+	bm := newBlockManager(w)
+	west, east := world.Pos{X: 4, Y: 2}, world.Pos{X: 5, Y: 2}
+
+	a, b := testTrainAt(4, 3), testTrainAt(2)
+	bm.occupy(a)
+	if bm.blockAt(west).OccupiedBy != a {
+		t.Fatal("train doesn't hold the block it starts in")
+	}
+	bm.occupy(b)
+	if bm.blockAt(west).OccupiedBy != a {
+		t.Fatal("a second train took over an occupied block")
+	}
+
+	tests := []struct {
+		name     string
+		train    *trains.Train
+		from, to world.Pos
+		want     bool
+	}{
+		{"into a free block", a, west, east, true},
+		{"into a block another train holds", b, west, east, false},
+		{"into a block it holds", a, west, east, true},
+		{"within a block", b, world.Pos{X: 1, Y: 2}, world.Pos{X: 2, Y: 2}, true},
+		{"off the track", b, world.Pos{X: 9, Y: 2}, world.Pos{X: 9, Y: 3}, true},
+	}
+	for _, tt := range tests {
+		if got := bm.tryEnter(tt.train, tt.from, tt.to); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if bm.blockAt(east).OccupiedBy != a {
+		t.Error("entering didn't claim the block")
+	}
+}
+
+func TestLeaveReleasesBlockOnceEmpty(t *testing.T) {
+	w := world.New(10, 10)
+	straightLine(w, 2, 0, 9)
+	w.Tracks[world.Pos{X: 4, Y: 2}].HasSignal = true
+	w.Tracks[world.Pos{X: 4, Y: 2}].SignalDir = types.DirEast
+	bm := newBlockManager(w)
+	west, east := world.Pos{X: 4, Y: 2}, world.Pos{X: 5, Y: 2}
+
+	a := testTrainAt(4, 3)
+	bm.occupy(a)
+	bm.tryEnter(a, west, east)
+
+	// The locomotive is across but the car behind is still in the block
+	a.Cars[0].X, a.Cars[1].X = 5, 4
+	bm.leave(a, world.Pos{X: 3, Y: 2})
+	if bm.blockAt(west).OccupiedBy != a {
+		t.Fatal("block was released while a car was still in it")
+	}
+
+	// Leaving doesn't release a block someone else holds
+	other := testTrainAt(1)
+	bm.leave(other, west)
+	if bm.blockAt(west).OccupiedBy != a {
+		t.Fatal("another train released the block")
+	}
+
+	a.Cars[0].X, a.Cars[1].X = 6, 5
+	bm.leave(a, west)
+	if bm.blockAt(west).OccupiedBy != nil {
+		t.Error("block wasn't released once the train left")
+	}
+	if bm.blockAt(east).OccupiedBy != a {
+		t.Error("leaving released the block the train is in")
+	}
+	if !bm.tryEnter(other, world.Pos{X: 3, Y: 2}, west) {
+		t.Error("released block couldn't be entered")
+	}
+}
+
+func TestBlocksRecalculatedAfterTrackChanges(t *testing.T) {
+	w := world.New(10, 10)
+	straightLine(w, 2, 0, 9)
+	train := eastbound(w, 2, 2, false)
+	e := New(w, time.Millisecond)
+	blockAt := func(x int) *types.Block { return e.bm.blockAt(world.Pos{X: x, Y: 2}) }
+	if blockAt(0) != blockAt(9) || blockAt(9).OccupiedBy != train {
+		t.Fatal("line without signals should be one block held by the train")
+	}
+
+	signal := world.Pos{X: 4, Y: 2}
+	w.Tracks[signal].HasSignal = true
+	w.Tracks[signal].SignalDir = types.DirEast
+	e.trackChanged(signal)
+	if blockAt(4) == blockAt(5) {
+		t.Fatal("new signal didn't split the block")
+	}
+	if blockAt(0) != blockAt(4) || blockAt(5) != blockAt(9) {
+		t.Error("signal split the line in the wrong place")
+	}
+	if blockAt(0).OccupiedBy != train {
+		t.Error("train lost its block")
+	}
+	if blockAt(9).OccupiedBy != nil {
+		t.Error("block the train isn't in is held")
+	}
+
+	gap := world.Pos{X: 7, Y: 2}
+	w.RemoveTrack(gap)
+	e.trackChanged(gap)
+	if blockAt(6) == blockAt(8) {
+		t.Error("track either side of a gap is in the same block")
+	}
+	if blockAt(0) != blockAt(4) || blockAt(0).OccupiedBy != train {
+		t.Error("block away from the gap changed")
+	}
+
+	w.Tracks[signal].HasSignal = false
+	e.trackChanged(signal)
+	if blockAt(0) != blockAt(6) {
+		t.Error("removing the signal didn't join the blocks")
+	}
+}
+
+func TestReserveReleasesBlocksNoLongerNeeded(t *testing.T) {
+	w := world.New(12, 10)
+	straightLine(w, 2, 0, 11)
+	for _, x := range []int{4, 7} {
+		w.Tracks[world.Pos{X: x, Y: 2}].HasSignal = true
+		w.Tracks[world.Pos{X: x, Y: 2}].SignalDir = types.DirEast
+	}
+	bm := newBlockManager(w)
+	middle, east := bm.blockAt(world.Pos{X: 6, Y: 2}), bm.blockAt(world.Pos{X: 9, Y: 2})
+
+	a := testTrainAt(3, 2)
+	bm.occupy(a)
+	bm.reserve(a, []*types.Block{middle, east})
+	if middle.OccupiedBy != a || east.OccupiedBy != a {
+		t.Fatal("free blocks ahead weren't reserved")
+	}
+
+	// The train's cars have got into the middle block, so only the one past
+	// it is let go
+	a.Cars[0].X, a.Cars[1].X = 5, 4
+	bm.reserve(a, nil)
+	if middle.OccupiedBy != a {
+		t.Error("reservation the train has entered was released")
+	}
+	if east.OccupiedBy != nil {
+		t.Error("reservation the train no longer needs was kept")
+	}
+
+	// Blocks another train holds are left alone
+	b := testTrainAt(10)
+	bm.occupy(b)
+	bm.reserve(a, []*types.Block{east})
+	if east.OccupiedBy != b {
+		t.Error("reserved a block another train holds")
+	}
+}
@@ -0,0 +1,195 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/sirupsen/logrus"
+)
+
+// permission is who is allowed to run a command
+type permission int
+
+const (
+	permPlayer permission = iota
+	permOperator
+)
+
+// command is something players can run by typing /name in chat. Whatever run
+// returns is sent back to just the player that ran it
+type command struct {
+	name  string
+	usage string
+	help  string
+	perm  permission
+	run   func(e *Engine, playerMsg playerMessage, args []string) (string, error)
+}
+
+type commandRegistry map[string]*command
+
+func newCommandRegistry() commandRegistry {
+	r := make(commandRegistry)
+	r.register(&command{
+		name: "help",
+		help: "List the commands you can use",
+		perm: permPlayer,
+		run:  runHelp,
+	})
+	r.register(&command{
+		name: "who",
+		help: "List who is online",
+		perm: permPlayer,
+		run:  runWho,
+	})
+	r.register(&command{
+		name: "trains",
+		help: "List every train and what it is doing",
+		perm: permPlayer,
+		run:  runTrains,
+	})
+	r.register(&command{
+		name:  "tp",
+		usage: "x y",
+		help:  "Move your view to x, y",
+		perm:  permPlayer,
+		run:   runTeleport,
+	})
+	r.register(&command{
+		name: "pause",
+		help: "Pause or unpause the game for everyone",
+		perm: permOperator,
+		run:  runPause,
+	})
+	return r
+}
+
+func (r commandRegistry) register(cmd *command) {
+	r[cmd.name] = cmd
+}
+
+// runCommand parses a chat line starting with / and runs the command in it
+func (e *Engine) runCommand(playerMsg playerMessage, line string) {
+	entry := logrus.WithField("player", playerMsg.playerID).WithField("command", line)
+
+	fields := strings.Fields(strings.TrimPrefix(line, "/"))
+	if len(fields) == 0 {
+		return
+	}
+	name, args := strings.ToLower(fields[0]), fields[1:]
+
+	cmd, ok := e.commands[name]
+	if !ok || !playerMsg.player.allowed(cmd.perm) {
+		e.reply(playerMsg, fmt.Sprintf("Unknown command /%s, try /help", name))
+		return
+	}
+
+	reply, err := cmd.run(e, playerMsg, args)
+	if err != nil {
+		entry.Debugf("Command failed: %v", err)
+		reply = err.Error()
+	} else {
+		entry.Info("Player ran command")
+	}
+	e.reply(playerMsg, reply)
+}
+
+// reply sends chat lines to just the player, without an author so the client
+// shows them as coming from the game
+func (e *Engine) reply(playerMsg playerMessage, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		if line == "" {
+			continue
+		}
+		e.nm.send(playerMsg.player, outgoingMessage{chatMessage: &message.ChatMessage{Message: line}})
+	}
+}
+
+func (p *playerConnection) allowed(perm permission) bool {
+	return perm == permPlayer || p.operator
+}
+
+func runHelp(e *Engine, playerMsg playerMessage, args []string) (string, error) {
+	names := make([]string, 0, len(e.commands))
+	for name, cmd := range e.commands {
+		if playerMsg.player.allowed(cmd.perm) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		cmd := e.commands[name]
+		usage := "/" + cmd.name
+		if cmd.usage != "" {
+			usage += " " + cmd.usage
+		}
+		fmt.Fprintf(&sb, "%-12s %s\n", usage, cmd.help)
+	}
+	return sb.String(), nil
+}
+
+func runWho(e *Engine, playerMsg playerMessage, args []string) (string, error) {
+	names := e.nm.usernames()
+	slices.Sort(names)
+	return fmt.Sprintf("%d online: %s", len(names), strings.Join(names, ", ")), nil
+}
+
+func runTrains(e *Engine, playerMsg playerMessage, args []string) (string, error) {
+	if len(e.w.Trains) == 0 {
+		return "There are no trains", nil
+	}
+
+	var sb strings.Builder
+	for _, t := range e.w.Trains {
+		lead := t.Lead()
+		status := "stopped"
+		if t.IsMoving {
+			status = fmt.Sprintf("moving at %d", t.Speed)
+		}
+		fmt.Fprintf(&sb, "%s at %d,%d %s", t.ID.String()[:8], lead.X, lead.Y, status)
+		if order := t.Order(); order != nil {
+			fmt.Fprintf(&sb, ", %s", order)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+func runTeleport(e *Engine, playerMsg playerMessage, args []string) (string, error) {
+	if !playerMsg.player.has(message.CapabilityMoveCamera) {
+		return "", fmt.Errorf("your client can't be moved by the server")
+	}
+	if len(args) != 2 {
+		return "", fmt.Errorf("usage: /tp x y")
+	}
+	x, errX := strconv.Atoi(args[0])
+	y, errY := strconv.Atoi(args[1])
+	if errX != nil || errY != nil {
+		return "", fmt.Errorf("usage: /tp x y")
+	}
+	pos := world.Pos{X: x, Y: y}
+	if !e.w.InBounds(pos) {
+		return "", fmt.Errorf("%d,%d is outside the world", x, y)
+	}
+
+	e.nm.send(playerMsg.player, outgoingMessage{moveCameraMessage: &message.MoveCameraMessage{Pos: pos}})
+	return fmt.Sprintf("Moved to %d,%d", x, y), nil
+}
+
+func runPause(e *Engine, playerMsg playerMessage, args []string) (string, error) {
+	e.paused = !e.paused
+
+	state := "unpaused"
+	if e.paused {
+		state = "paused"
+	}
+	e.nm.broadcast(outgoingMessage{chatMessage: &message.ChatMessage{
+		Message: fmt.Sprintf("%s %s the game", playerMsg.player.username, state),
+	}})
+	return "", nil
+}
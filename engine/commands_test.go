@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/world"
+)
+
+// runChat sends a chat line as alice and returns what was sent back to her
+func runChat(t *testing.T, e *Engine, line string, operator bool) []outgoingMessage {
+	t.Helper()
+	msg, replies := fromPlayer(&incomingMessage{chatMessage: &message.ChatMessage{Message: line}})
+	msg.player.username = "alice"
+	msg.player.operator = operator
+	e.handlePlayerMessage(msg)
+	return drain(t, replies)
+}
+
+// replyText joins the chat lines in msgs
+func replyText(msgs []outgoingMessage) string {
+	var lines []string
+	for _, msg := range msgs {
+		if msg.chatMessage != nil {
+			lines = append(lines, msg.chatMessage.Message)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestCommandsArentBroadcast(t *testing.T) {
+	e := New(world.New(10, 10), time.Millisecond)
+	reply := replyText(runChat(t, e, "/who", false))
+	if !strings.HasPrefix(reply, "0 online") {
+		t.Errorf("got %q, want the player list", reply)
+	}
+	select {
+	case msg := <-e.nm.broadcastCh:
+		t.Errorf("command was broadcast: %+v", msg)
+	default:
+	}
+}
+
+func TestHelpOnlyListsAllowedCommands(t *testing.T) {
+	e := New(world.New(10, 10), time.Millisecond)
+	if reply := replyText(runChat(t, e, "/help", false)); !strings.Contains(reply, "/tp x y") || strings.Contains(reply, "/pause") {
+		t.Errorf("player got help %q, want /tp without /pause", reply)
+	}
+	if reply := replyText(runChat(t, e, "/HELP", true)); !strings.Contains(reply, "/pause") {
+		t.Errorf("operator got help %q, want /pause", reply)
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	e := New(world.New(10, 10), time.Millisecond)
+	for _, line := range []string{"/nope", "/pause"} {
+		if reply := replyText(runChat(t, e, line, false)); !strings.HasPrefix(reply, "Unknown command") {
+			t.Errorf("%s got %q, want it to be unknown", line, reply)
+		}
+	}
+	if e.paused {
+		t.Error("player without permission paused the game")
+	}
+}
+
+func TestPause(t *testing.T) {
+	e := New(world.New(10, 10), time.Millisecond)
+	runChat(t, e, "/pause", true)
+	if !e.paused {
+		t.Fatal("game wasn't paused")
+	}
+	select {
+	case msg := <-e.nm.broadcastCh:
+		if msg.chatMessage == nil || msg.chatMessage.Message != "alice paused the game" {
+			t.Errorf("got %+v, want everyone told alice paused", msg)
+		}
+	default:
+		t.Error("nobody was told the game was paused")
+	}
+
+	runChat(t, e, "/pause", true)
+	if e.paused {
+		t.Error("game wasn't unpaused")
+	}
+}
+
+func TestTeleport(t *testing.T) {
+	e := New(world.New(10, 10), time.Millisecond)
+	msgs := runChat(t, e, "/tp 3 4", false)
+	var moved *message.MoveCameraMessage
+	for _, msg := range msgs {
+		if msg.moveCameraMessage != nil {
+			moved = msg.moveCameraMessage
+		}
+	}
+	if moved == nil || moved.Pos != (world.Pos{X: 3, Y: 4}) {
+		t.Errorf("got %+v, want the camera moved to 3,4", moved)
+	}
+
+	for _, line := range []string{"/tp", "/tp 3", "/tp a b", "/tp 30 4"} {
+		msgs := runChat(t, e, line, false)
+		if len(msgs) != 1 || msgs[0].moveCameraMessage != nil {
+			t.Errorf("%s got %+v, want just an error", line, msgs)
+		}
+	}
+}
+
+func TestTeleportNeedsMoveCamera(t *testing.T) {
+	e := New(world.New(10, 10), time.Millisecond)
+	msg, replies := fromPlayer(&incomingMessage{chatMessage: &message.ChatMessage{Message: "/tp 3 4"}})
+	msg.player.features = []string{message.CapabilityTrainUpdates}
+	e.handlePlayerMessage(msg)
+
+	msgs := drain(t, replies)
+	if len(msgs) != 1 || msgs[0].chatMessage == nil || !strings.Contains(msgs[0].chatMessage.Message, "can't be moved") {
+		t.Errorf("got %+v, want an error saying the client can't be moved", msgs)
+	}
+}
+
+func TestTrainsCommand(t *testing.T) {
+	w := world.New(10, 10)
+	e := New(w, time.Millisecond)
+	if reply := replyText(runChat(t, e, "/trains", false)); reply != "There are no trains" {
+		t.Errorf("got %q with no trains", reply)
+	}
+
+	straightLine(w, 2, 0, 9)
+	train := eastbound(w, 4, 2, false)
+	reply := replyText(runChat(t, e, "/trains", false))
+	if !strings.HasPrefix(reply, train.ID.String()[:8]+" at 4,2 stopped") {
+		t.Errorf("got %q, want the train's position and that it's stopped", reply)
+	}
+}
@@ -0,0 +1,239 @@
+package engine
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/sirupsen/logrus"
+)
+
+func (e *Engine) handleBuildTrackMessage(playerMsg playerMessage) {
+	msg := playerMsg.message.buildTrackMessage
+	entry := logrus.WithField("player", playerMsg.playerID).WithField("pos", msg.Pos)
+
+	track := msg.Track
+	joins, err := e.validateBuild(msg.Pos, &track)
+	if err != nil {
+		e.rejectBuild(playerMsg, msg.Pos, err)
+		entry.WithError(err).Debug("Rejected track build")
+		return
+	}
+
+	// Only the shape and signal come from the player, the block is ours to work out
+	track.Block = nil
+	e.w.AddTrack(msg.Pos, &track)
+
+	// Neighbours the new track runs into are turned to meet it
+	changed := []world.Pos{msg.Pos}
+	for _, d := range joins {
+		neighbourPos := msg.Pos.Neighbour(d)
+		joined := *e.w.Tracks[neighbourPos]
+		joined.Direction |= types.OppositeDir(d)
+		joined.Block = nil
+		e.w.AddTrack(neighbourPos, &joined)
+		changed = append(changed, neighbourPos)
+	}
+	e.trackChanged(changed...)
+	entry.Debug("Player built track")
+}
+
+func (e *Engine) handleBuildTrackPathMessage(playerMsg playerMessage) {
+	msg := playerMsg.message.buildTrackPathMessage
+	entry := logrus.WithField("player", playerMsg.playerID).WithField("tiles", len(msg.Path))
+
+	var rejectPos world.Pos
+	if len(msg.Path) > 0 {
+		rejectPos = msg.Path[0]
+	}
+	if len(msg.Path) > message.MaxTrackPathLength {
+		e.rejectBuild(playerMsg, rejectPos, fmt.Errorf("track paths can be at most %d tiles", message.MaxTrackPathLength))
+		entry.Debug("Rejected track path that was too long")
+		return
+	}
+
+	plan, err := e.w.PlanTrackPath(msg.Path)
+	if err != nil {
+		e.rejectBuild(playerMsg, rejectPos, err)
+		entry.WithError(err).Debug("Rejected track path")
+		return
+	}
+	for pos, dir := range plan {
+		if track, ok := e.w.Tracks[pos]; ok && track.Direction == dir {
+			continue
+		}
+		if e.w.OccupiedAt(pos) {
+			e.rejectBuild(playerMsg, pos, fmt.Errorf("a train is in the way"))
+			entry.Debug("Rejected track path under a train")
+			return
+		}
+	}
+
+	if changed := e.w.ApplyTrackPlan(plan); len(changed) > 0 {
+		e.trackChanged(changed...)
+	}
+	entry.Debug("Player built track path")
+}
+
+func (e *Engine) handleRemoveTrackMessage(playerMsg playerMessage) {
+	msg := playerMsg.message.removeTrackMessage
+	entry := logrus.WithField("player", playerMsg.playerID).WithField("pos", msg.Pos)
+
+	cuts, err := e.validateRemove(msg.Pos)
+	if err != nil {
+		e.rejectBuild(playerMsg, msg.Pos, err)
+		entry.WithError(err).Debug("Rejected track removal")
+		return
+	}
+
+	e.w.RemoveTrack(msg.Pos)
+
+	// Neighbours that ran into the removed track are cut back to end where it
+	// was. One that did nothing but run into it goes too
+	changed := []world.Pos{msg.Pos}
+	for _, d := range cuts {
+		neighbourPos := msg.Pos.Neighbour(d)
+		cut := *e.w.Tracks[neighbourPos]
+		cut.Direction &^= types.OppositeDir(d)
+		cut.Block = nil
+		if cut.HasSignal && cut.Direction&cut.SignalDir == 0 {
+			cut.HasSignal, cut.SignalDir = false, types.DirNone
+		}
+		if cut.Direction == types.DirNone {
+			e.w.RemoveTrack(neighbourPos)
+		} else {
+			e.w.AddTrack(neighbourPos, &cut)
+		}
+		changed = append(changed, neighbourPos)
+	}
+	e.trackChanged(changed...)
+	entry.Debug("Player removed track")
+}
+
+// validateBuild checks the track can go at pos. It returns the directions of
+// the neighbours the track runs into that need turning to meet it
+func (e *Engine) validateBuild(pos world.Pos, track *types.Track) ([]types.Dir, error) {
+	if !e.w.InBounds(pos) {
+		return nil, fmt.Errorf("position %d,%d is outside the world", pos.X, pos.Y)
+	}
+
+	if !e.w.Buildable(pos) {
+		return nil, fmt.Errorf("can't build track on this terrain")
+	}
+
+	if e.w.OccupiedAt(pos) {
+		return nil, fmt.Errorf("a train is in the way")
+	}
+
+	if track.Direction == types.DirNone || track.Direction > types.DirNorth|types.DirEast|types.DirSouth|types.DirWest {
+		return nil, fmt.Errorf("invalid track direction %d", track.Direction)
+	}
+	if track.HasSignal {
+		if bits.OnesCount8(uint8(track.SignalDir)) != 1 || track.Direction&track.SignalDir == 0 {
+			return nil, fmt.Errorf("signal must face one of the track's directions")
+		}
+	}
+
+	var joins []types.Dir
+	for d := types.Dir(types.DirNorth); d <= types.DirWest; d <<= 1 {
+		neighbourPos := pos.Neighbour(d)
+		if !e.w.InBounds(neighbourPos) {
+			if track.Direction&d != 0 {
+				return nil, fmt.Errorf("track runs off the edge of the world to the %s", d)
+			}
+			continue
+		}
+
+		neighbour, ok := e.w.Tracks[neighbourPos]
+		if !ok {
+			continue
+		}
+		connects := track.Direction&d != 0
+		neighbourConnects := neighbour.Direction&types.OppositeDir(d) != 0
+		switch {
+		case neighbourConnects && !connects:
+			// Cutting the neighbour off would leave it running into the side
+			// of this track
+			return nil, fmt.Errorf("track doesn't line up with its neighbour to the %s", d)
+		case connects && !neighbourConnects:
+			if e.w.OccupiedAt(neighbourPos) {
+				return nil, fmt.Errorf("a train is in the way of joining the track to the %s", d)
+			}
+			joins = append(joins, d)
+		}
+	}
+	return joins, nil
+}
+
+// validateRemove checks the track at pos can be removed. It returns the
+// directions of the neighbours that run into it and need cutting back
+func (e *Engine) validateRemove(pos world.Pos) ([]types.Dir, error) {
+	if !e.w.InBounds(pos) {
+		return nil, fmt.Errorf("position %d,%d is outside the world", pos.X, pos.Y)
+	}
+	if _, ok := e.w.Tracks[pos]; !ok {
+		return nil, fmt.Errorf("there is no track here")
+	}
+	if e.w.OccupiedAt(pos) {
+		return nil, fmt.Errorf("a train is in the way")
+	}
+
+	var cuts []types.Dir
+	for d := types.Dir(types.DirNorth); d <= types.DirWest; d <<= 1 {
+		neighbourPos := pos.Neighbour(d)
+		neighbour, ok := e.w.Tracks[neighbourPos]
+		if !ok || neighbour.Direction&types.OppositeDir(d) == 0 {
+			continue
+		}
+		if e.w.OccupiedAt(neighbourPos) {
+			return nil, fmt.Errorf("a train is in the way of cutting the track to the %s", d)
+		}
+		cuts = append(cuts, d)
+	}
+	return cuts, nil
+}
+
+func (e *Engine) rejectBuild(playerMsg playerMessage, pos world.Pos, err error) {
+	e.nm.send(playerMsg.player, outgoingMessage{
+		buildRejectedMessage: &message.BuildRejectedMessage{
+			Pos:    pos,
+			Reason: err.Error(),
+		},
+	})
+}
+
+// trackChanged recalculates everything that depends on the track at pos and
+// tells every player about the change
+func (e *Engine) trackChanged(positions ...world.Pos) {
+	changes := make([]message.TileChange, 0, len(positions))
+	for _, pos := range positions {
+		e.bm.trackChanged(pos)
+		e.router.trackChanged(pos)
+
+		change := message.TileChange{
+			Pos:  pos,
+			Tile: *e.w.TileAt(pos),
+		}
+		if track, ok := e.w.Tracks[pos]; ok {
+			change.Track = &types.Track{
+				Direction: track.Direction,
+				HasSignal: track.HasSignal,
+				SignalDir: track.SignalDir,
+			}
+		}
+		changes = append(changes, change)
+	}
+	for _, t := range e.w.Trains {
+		e.bm.occupy(t)
+	}
+
+	e.history.record(e.tickCount, changes)
+	e.nm.broadcast(outgoingMessage{
+		trackUpdatesMessage: &message.TrackUpdatesMessage{
+			Tick:    e.tickCount,
+			Changes: changes,
+		},
+	})
+}
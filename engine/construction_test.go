@@ -0,0 +1,237 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+)
+
+// fromPlayer wraps a message as if it came from a player, returning the
+// queue replies to that player go to
+func fromPlayer(msg *incomingMessage) (playerMessage, *sendQueue) {
+	player := &playerConnection{
+		playerID: "alice",
+		features: message.Capabilities,
+		queue:    newSendQueue(),
+		session:  &session{playerID: "alice", subscriptions: make(map[world.Pos]struct{})},
+	}
+	return playerMessage{playerID: player.playerID, message: msg, player: player}, player.queue
+}
+
+func build(e *Engine, pos world.Pos, track types.Track) *sendQueue {
+	msg, replies := fromPlayer(&incomingMessage{buildTrackMessage: &message.BuildTrackMessage{Pos: pos, Track: track}})
+	e.handlePlayerMessage(msg)
+	return replies
+}
+
+func remove(e *Engine, pos world.Pos) *sendQueue {
+	msg, replies := fromPlayer(&incomingMessage{removeTrackMessage: &message.RemoveTrackMessage{Pos: pos}})
+	e.handlePlayerMessage(msg)
+	return replies
+}
+
+func rejection(t *testing.T, queue *sendQueue) *message.BuildRejectedMessage {
+	t.Helper()
+	for _, msg := range drain(t, queue) {
+		if msg.buildRejectedMessage != nil {
+			return msg.buildRejectedMessage
+		}
+	}
+	return nil
+}
+
+func constructionWorld() *Engine {
+	w := world.New(10, 10)
+	w.Tiles[8][8].Type = types.TileWater
+	straightLine(w, 2, 2, 6)
+	eastbound(w, 4, 2, false)
+	return New(w, time.Millisecond)
+}
+
+func TestBuildTrack(t *testing.T) {
+	e := constructionWorld()
+	pos := world.Pos{X: 7, Y: 2}
+	block := &types.Block{ID: types.BlockID{1}}
+
+	replies := build(e, pos, types.Track{Direction: types.DirWest | types.DirEast, Block: block})
+	if rejected := rejection(t, replies); rejected != nil {
+		t.Fatalf("build rejected: %s", rejected.Reason)
+	}
+	track := e.w.Tracks[pos]
+	if track == nil || e.w.TileAt(pos).Type != types.TileTrack {
+		t.Fatal("track wasn't laid")
+	}
+	if track.Block == block {
+		t.Error("player picked the track's block")
+	}
+
+	select {
+	case msg := <-e.nm.broadcastCh:
+		if msg.trackUpdatesMessage == nil || len(msg.trackUpdatesMessage.Changes) != 1 {
+			t.Fatalf("got %+v, want one track change", msg)
+		}
+		change := msg.trackUpdatesMessage.Changes[0]
+		if change.Pos != pos || change.Track == nil || change.Track.Direction != track.Direction {
+			t.Errorf("got change %+v, want the new track", change)
+		}
+	default:
+		t.Error("change wasn't broadcast")
+	}
+}
+
+func TestBuildTrackJoinsNeighbours(t *testing.T) {
+	e := constructionWorld()
+	pos, neighbourPos := world.Pos{X: 6, Y: 3}, world.Pos{X: 6, Y: 2}
+
+	replies := build(e, pos, types.Track{Direction: types.DirNorth | types.DirSouth})
+	if rejected := rejection(t, replies); rejected != nil {
+		t.Fatalf("build rejected: %s", rejected.Reason)
+	}
+	if got := e.w.Tracks[neighbourPos].Direction; got != types.DirEast|types.DirWest|types.DirNorth {
+		t.Errorf("neighbour runs %s, want it turned to meet the new track", got)
+	}
+
+	select {
+	case msg := <-e.nm.broadcastCh:
+		if msg.trackUpdatesMessage == nil || len(msg.trackUpdatesMessage.Changes) != 2 {
+			t.Fatalf("got %+v, want the new track and its neighbour", msg)
+		}
+	default:
+		t.Error("change wasn't broadcast")
+	}
+}
+
+func TestBuildTrackRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		pos   world.Pos
+		track types.Track
+	}{
+		{"outside the world", world.Pos{X: 10, Y: 2}, types.Track{Direction: types.DirEast | types.DirWest}},
+		{"on water", world.Pos{X: 8, Y: 8}, types.Track{Direction: types.DirEast | types.DirWest}},
+		{"under a train", world.Pos{X: 4, Y: 2}, types.Track{Direction: types.DirEast | types.DirWest | types.DirNorth}},
+		{"no direction", world.Pos{X: 5, Y: 5}, types.Track{}},
+		{"bad direction", world.Pos{X: 5, Y: 5}, types.Track{Direction: 1 << 6}},
+		{"signal facing nowhere", world.Pos{X: 5, Y: 5}, types.Track{Direction: types.DirNorth | types.DirSouth, HasSignal: true}},
+		{"signal facing off the track", world.Pos{X: 5, Y: 5}, types.Track{Direction: types.DirNorth | types.DirSouth, HasSignal: true, SignalDir: types.DirEast}},
+		{"off the edge", world.Pos{X: 0, Y: 5}, types.Track{Direction: types.DirEast | types.DirWest}},
+		{"doesn't line up", world.Pos{X: 7, Y: 2}, types.Track{Direction: types.DirNorth | types.DirSouth}},
+		{"joins track under a train", world.Pos{X: 4, Y: 3}, types.Track{Direction: types.DirNorth | types.DirSouth}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := constructionWorld()
+			before := e.w.Tracks[tt.pos]
+
+			if rejection(t, build(e, tt.pos, tt.track)) == nil {
+				t.Fatal("build wasn't rejected")
+			}
+			if e.w.Tracks[tt.pos] != before {
+				t.Error("rejected build changed the track")
+			}
+			select {
+			case msg := <-e.nm.broadcastCh:
+				t.Errorf("rejected build broadcast %+v", msg)
+			default:
+			}
+		})
+	}
+}
+
+func TestRemoveTrack(t *testing.T) {
+	e := constructionWorld()
+	pos := world.Pos{X: 6, Y: 2}
+	spur := world.Pos{X: 6, Y: 1}
+	e.w.AddTrack(spur, &types.Track{Direction: types.DirNorth})
+	e.w.Tracks[world.Pos{X: 5, Y: 2}].HasSignal = true
+	e.w.Tracks[world.Pos{X: 5, Y: 2}].SignalDir = types.DirEast
+
+	if rejected := rejection(t, remove(e, pos)); rejected != nil {
+		t.Fatalf("removal rejected: %s", rejected.Reason)
+	}
+	if _, ok := e.w.Tracks[pos]; ok || e.w.TileAt(pos).Type != types.TileGrass {
+		t.Error("track wasn't removed")
+	}
+
+	// The line is cut back to end where the track was, taking its signal with
+	// it, and the spur that only ran into the track goes too
+	if got := e.w.Tracks[world.Pos{X: 5, Y: 2}]; got.Direction != types.DirWest || got.HasSignal {
+		t.Errorf("neighbour got %+v, want it to run west without the signal", got)
+	}
+	if _, ok := e.w.Tracks[spur]; ok {
+		t.Error("spur into the removed track was left behind")
+	}
+	select {
+	case msg := <-e.nm.broadcastCh:
+		if msg.trackUpdatesMessage == nil || len(msg.trackUpdatesMessage.Changes) != 3 {
+			t.Errorf("got %+v, want the removed track and both neighbours", msg)
+		}
+	default:
+		t.Error("removal wasn't broadcast")
+	}
+
+	for name, pos := range map[string]world.Pos{
+		"no track":             {X: 5, Y: 5},
+		"under a train":        {X: 4, Y: 2},
+		"outside":              {X: -1, Y: 2},
+		"cuts a train's track": {X: 5, Y: 2},
+	} {
+		if rejection(t, remove(e, pos)) == nil {
+			t.Errorf("%s: removal wasn't rejected", name)
+		}
+	}
+}
+
+func buildPath(e *Engine, path ...world.Pos) *sendQueue {
+	msg, replies := fromPlayer(&incomingMessage{buildTrackPathMessage: &message.BuildTrackPathMessage{Path: path}})
+	e.handlePlayerMessage(msg)
+	return replies
+}
+
+func TestBuildTrackPath(t *testing.T) {
+	e := constructionWorld()
+
+	// Carries on from the end of the line and turns north
+	path := []world.Pos{{X: 7, Y: 2}, {X: 8, Y: 2}, {X: 8, Y: 3}}
+	if rejected := rejection(t, buildPath(e, path...)); rejected != nil {
+		t.Fatalf("path rejected: %s", rejected.Reason)
+	}
+	if got := e.w.Tracks[world.Pos{X: 8, Y: 2}]; got == nil || got.Direction != types.DirWest|types.DirNorth {
+		t.Errorf("corner got %+v, want west and north", got)
+	}
+	if got := e.w.Tracks[world.Pos{X: 6, Y: 2}]; got.Direction != types.DirEast|types.DirWest {
+		t.Errorf("end of the line got %s, want it joined on", got.Direction)
+	}
+	select {
+	case msg := <-e.nm.broadcastCh:
+		if msg.trackUpdatesMessage == nil || len(msg.trackUpdatesMessage.Changes) != len(path) {
+			t.Errorf("got %+v, want a change for each tile on the path", msg)
+		}
+	default:
+		t.Error("path wasn't broadcast")
+	}
+
+	// A path that would change the track under a train is turned down whole
+	crossing := []world.Pos{{X: 4, Y: 1}, {X: 4, Y: 2}, {X: 4, Y: 3}}
+	if rejection(t, buildPath(e, crossing...)) == nil {
+		t.Fatal("path under a train wasn't rejected")
+	}
+	if _, ok := e.w.Tracks[world.Pos{X: 4, Y: 1}]; ok {
+		t.Error("rejected path laid track")
+	}
+
+	if rejection(t, buildPath(e, world.Pos{X: 1, Y: 7}, world.Pos{X: 3, Y: 7})) == nil {
+		t.Error("path with a gap wasn't rejected")
+	}
+
+	long := make([]world.Pos, message.MaxTrackPathLength+1)
+	for i := range long {
+		long[i] = world.Pos{X: i % 2, Y: 7}
+	}
+	if rejection(t, buildPath(e, long...)) == nil {
+		t.Error("path longer than the limit wasn't rejected")
+	}
+}
@@ -1,20 +1,33 @@
 package engine
 
 import (
+	"slices"
+	"strings"
 	"time"
+	"unicode"
 
+	"github.com/danharasymiw/bit-rail/accounts"
 	"github.com/danharasymiw/bit-rail/message"
 	"github.com/danharasymiw/bit-rail/trains"
 	"github.com/danharasymiw/bit-rail/types"
 	"github.com/danharasymiw/bit-rail/world"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
 type Engine struct {
-	w       *world.World
-	tickDur time.Duration
-	running bool
-	nm      *networkManager
+	w         *world.World
+	tickDur   time.Duration
+	tickCount uint64
+	running   bool
+	nm        *networkManager
+	bm        *blockManager
+	router    *router
+	autosaver *autosaver
+	history   *changeLog
+	commands  commandRegistry
+	// paused stops the simulation, players can still build and chat
+	paused bool
 }
 
 func New(w *world.World, tickDur time.Duration) *Engine {
@@ -22,11 +35,27 @@ func New(w *world.World, tickDur time.Duration) *Engine {
 		w:       w,
 		tickDur: tickDur,
 	}
-	eng.nm = newNetworkManager()
+	eng.nm = newNetworkManager(accounts.NewMemoryStore())
+	eng.bm = newBlockManager(w)
+	eng.router = newRouter(w)
+	eng.history = newChangeLog(eng.tickCount)
+	eng.commands = newCommandRegistry()
+	for _, t := range w.Trains {
+		eng.bm.occupy(t)
+	}
 	return eng
 }
 
+// UseAccounts has players log in with the accounts in store instead of ones
+// that are forgotten when the server stops. It must be called before Run
+func (e *Engine) UseAccounts(store *accounts.Store) {
+	e.nm.accounts = store
+}
+
 func (e *Engine) Run(quitCh <-chan struct{}, readyCh chan<- struct{}) {
+	// Started before the first tick as broadcast relies on it draining the
+	// channel. Tests without a loop read the channel themselves
+	go e.nm.broadcastLoop()
 	go e.nm.startServer(readyCh)
 
 	ticker := time.NewTicker(e.tickDur)
@@ -38,87 +67,371 @@ func (e *Engine) Run(quitCh <-chan struct{}, readyCh chan<- struct{}) {
 		case incoming := <-e.nm.incomingCh:
 			e.handlePlayerMessage(incoming)
 		case <-ticker.C:
+			if e.paused {
+				continue
+			}
 			e.tick()
+			if e.autosaveDue() {
+				e.autosave()
+			}
 		case <-quitCh:
 			e.running = false
 		}
 	}
 
+	e.finishAutosave()
+
 	// Give goroutines time to clean up
 	time.Sleep(100 * time.Millisecond)
 }
 
 func (e *Engine) tick() {
+	e.tickCount++
+
+	updates := make([]message.TrainState, 0, len(e.w.Trains))
 	for _, t := range e.w.Trains {
-		e.moveTrain(t)
+		prevChunks := trainChunks(t)
+		ordersChanged := e.processOrders(t)
+		speedChanged := e.updateSpeed(t)
+		e.reserveAhead(t)
+		if e.advanceTrain(t) || ordersChanged || speedChanged {
+			state := message.NewTrainState(t)
+			state.Chunks = prevChunks
+			for _, pos := range trainChunks(t) {
+				if !slices.Contains(state.Chunks, pos) {
+					state.Chunks = append(state.Chunks, pos)
+				}
+			}
+			updates = append(updates, state)
+		}
 	}
+	e.broadcastBlockChanges()
+	if len(updates) == 0 {
+		return
+	}
+
+	e.nm.broadcast(outgoingMessage{
+		trainUpdatesMessage: &message.TrainUpdatesMessage{
+			Tick:   e.tickCount,
+			Trains: updates,
+		},
+	})
 }
 
-func (e *Engine) moveTrain(t *trains.Train) {
-	// TODO investigate if this function makes more sense to turn/figure out direction then move
-	// Currently we move, and then figure out out next direction
+// trainChunks returns the chunks the train's cars are in
+func trainChunks(t *trains.Train) []world.Pos {
+	chunks := make([]world.Pos, 0, 1)
+	for _, c := range t.Cars {
+		pos := world.TileToChunkPos(world.Pos{X: c.X, Y: c.Y})
+		if !slices.Contains(chunks, pos) {
+			chunks = append(chunks, pos)
+		}
+	}
+	return chunks
+}
+
+// updateSpeed accelerates the train towards its top speed, braking early
+// enough to stop before anything blocking the track ahead. It reports whether
+// the speed or whether the train is blocked changed
+func (e *Engine) updateSpeed(t *trains.Train) bool {
+	prevSpeed, prevBlocked := t.Speed, t.Blocked
 	if !t.IsMoving {
-		return
+		t.Speed, t.Acceleration, t.Progress = 0, 0, 0
+		t.Blocked = false
+		return prevSpeed != 0 || prevBlocked
 	}
 
-	car := t.Cars[0]
-	moveDir := car.Direction
-	if t.IsReversing {
-		car = t.Cars[len(t.Cars)-1]
-		moveDir = types.OppositeDir(car.Direction)
+	lookAhead := t.StoppingDistance(t.MaxSpeed())/trains.ProgressPerTile + 2
+	distance, obstructed := e.distanceAhead(t, lookAhead)
+	available := distance*trains.ProgressPerTile - t.Progress
+
+	speed := min(t.Speed+t.AccelerationRate(), t.MaxSpeed())
+	if speed+t.StoppingDistance(speed) > available {
+		speed = t.Speed
+	}
+	if speed+t.StoppingDistance(speed) > available {
+		speed = max(t.Speed-t.BrakingRate(), 0)
+	}
+	// Never overrun the space we have, even if that means braking harder than
+	// the train normally could
+	speed = max(min(speed, available), 0)
+	// Braking in whole steps can leave the train short of where it has to
+	// stop, it creeps the rest of the way rather than standing there
+	if speed == 0 && available > 0 {
+		speed = min(t.AccelerationRate(), available)
 	}
 
-	pos := world.Pos{X: car.X, Y: car.Y}
-	dir := car.Direction
-	nextPos := nextPos(pos, dir)
-	nextTile := e.w.TileAt(nextPos)
-	if nextTile.Type != types.TileTrack {
-		return
+	t.Acceleration = speed - t.Speed
+	t.Speed = speed
+	t.Blocked = speed == 0 && obstructed
+	return prevSpeed != t.Speed || prevBlocked != t.Blocked
+}
+
+// distanceAhead counts how many tiles the train can move before it reaches
+// something it has to stop for, up to limit. It also reports whether that
+// something is in the way rather than the train's destination
+func (e *Engine) distanceAhead(t *trains.Train, limit int) (int, bool) {
+	lead := t.Lead()
+	pos := world.Pos{X: lead.X, Y: lead.Y}
+	dir := t.TravelDir()
+
+	atDestination := func(p world.Pos) bool {
+		return t.Destination != nil && p.X == t.Destination.X && p.Y == t.Destination.Y
+	}
+	if atDestination(pos) {
+		return 0, false
 	}
 
-	if e.w.OccupiedAt(nextPos) {
-		return
+	for n := 0; n < limit; n++ {
+		next := pos.Neighbour(dir)
+		if !e.canEnter(t, pos, next) {
+			return n, true
+		}
+		if atDestination(next) {
+			return n + 1, false
+		}
+		pos = next
+		dir = e.nextTravelDir(t, pos, dir)
+	}
+	return limit, false
+}
+
+// reserveAhead reserves the blocks the train would run into while braking to a
+// stand from its speed after this tick's move
+func (e *Engine) reserveAhead(t *trains.Train) {
+	var ahead []*types.Block
+	if t.IsMoving && t.Speed > 0 {
+		reach := t.Progress + t.Speed + t.StoppingDistance(t.Speed)
+		ahead = e.blocksAhead(t, (reach+trains.ProgressPerTile-1)/trains.ProgressPerTile)
+	}
+	e.bm.reserve(t, ahead)
+}
+
+// blocksAhead returns the blocks past the lead car's that the train would
+// enter in the next tiles tiles, stopping at its destination or anything it
+// can't enter
+func (e *Engine) blocksAhead(t *trains.Train, tiles int) []*types.Block {
+	lead := t.Lead()
+	pos := world.Pos{X: lead.X, Y: lead.Y}
+	dir := t.TravelDir()
+	current := e.bm.blockAt(pos)
+
+	var blocks []*types.Block
+	for range tiles {
+		if t.Destination != nil && pos.X == t.Destination.X && pos.Y == t.Destination.Y {
+			break
+		}
+		next := pos.Neighbour(dir)
+		if !e.canEnter(t, pos, next) {
+			break
+		}
+		if block := e.bm.blockAt(next); block != nil && block != current && !slices.Contains(blocks, block) {
+			blocks = append(blocks, block)
+		}
+		pos = next
+		dir = e.nextTravelDir(t, pos, dir)
+	}
+	return blocks
+}
+
+// canEnter reports whether the train can move from one tile onto the next
+func (e *Engine) canEnter(t *trains.Train, from, to world.Pos) bool {
+	if !e.w.InBounds(to) || e.w.TileAt(to).Type != types.TileTrack {
+		return false
+	}
+	if e.w.OccupiedAt(to) {
+		return false
+	}
+	return e.bm.isFree(t, from, to)
+}
+
+// advanceTrain adds the train's speed to its progress and moves it a tile for
+// every full tile of progress. It reports whether the train moved
+func (e *Engine) advanceTrain(t *trains.Train) bool {
+	t.Progress += t.Speed
+
+	moved := false
+	for t.Progress >= trains.ProgressPerTile {
+		if !e.moveTrain(t) {
+			// Something got in the way that we didn't see coming
+			t.Speed, t.Acceleration, t.Progress = 0, 0, 0
+			break
+		}
+		t.Progress -= trains.ProgressPerTile
+		moved = true
+	}
+	return moved
+}
+
+// moveTrain advances the train by one tile and reports whether it moved
+func (e *Engine) moveTrain(t *trains.Train) bool {
+	// TODO investigate if this function makes more sense to turn/figure out direction then move
+	// Currently we move, and then figure out out next direction
+	if !t.IsMoving {
+		return false
 	}
 
-	e.moveCars(t.Cars, moveDir, t.IsReversing)
+	car := t.Lead()
+	moveDir := t.TravelDir()
 
-	car = t.Cars[0]
-	if t.IsReversing {
-		car = t.Cars[len(t.Cars)-1]
-		car.Direction = types.OppositeDir(car.Direction)
+	pos := world.Pos{X: car.X, Y: car.Y}
+	nextPos := pos.Neighbour(moveDir)
+	if !e.canEnter(t, pos, nextPos) || !e.bm.tryEnter(t, pos, nextPos) {
+		return false
 	}
+
+	vacated := e.moveCars(t.Cars, moveDir, t.IsReversing)
+	e.bm.leave(t, vacated)
+
+	car = t.Lead()
 	pos = world.Pos{X: car.X, Y: car.Y}
-	dir = car.Direction
+	t.SetTravelDir(e.nextTravelDir(t, pos, moveDir))
+	return true
+}
+
+// nextTravelDir works out which way a train that arrived at pos heading in dir
+// should leave
+func (e *Engine) nextTravelDir(t *trains.Train, pos world.Pos, dir types.Dir) types.Dir {
 	track := e.w.Tracks[pos]
 	if track == nil {
-		return
+		return dir
 	}
 
 	incFrom := types.OppositeDir(dir)
 	if track.Direction&incFrom == 0 {
-		return
+		return dir
 	}
 
 	outgoing := track.Direction & ^incFrom
 
 	if outgoing != 0 && (outgoing&(outgoing-1)) == 0 {
-		car.Direction = outgoing & -outgoing
-		return
+		return outgoing & -outgoing
+	}
+
+	if t.Destination != nil {
+		dest := world.Pos{X: t.Destination.X, Y: t.Destination.Y}
+		if next, ok := e.router.nextDir(pos, incFrom, dest); ok {
+			return next
+		}
 	}
 
 	if outgoing&dir != 0 {
-		return
+		return dir
 	}
 
 	for d := types.DirNorth; d <= types.DirWest; d <<= 1 {
 		if outgoing&types.Dir(d) != 0 {
-			car.Direction = types.Dir(d)
-			return
+			return types.Dir(d)
+		}
+	}
+	return dir
+}
+
+// processOrders works through the train's orders, going straight on to the
+// next one whenever an order completes so no tick is lost between them. It
+// reports whether the train's order state changed
+func (e *Engine) processOrders(t *trains.Train) bool {
+	changed := false
+	// Each order gets one go per tick, so repeating orders that all complete
+	// straight away can't keep the tick spinning
+	for range len(t.Orders) {
+		order := t.Order()
+		if order == nil {
+			break
+		}
+		complete, orderChanged := e.processOrder(t, order)
+		changed = changed || orderChanged
+		if !complete {
+			break
+		}
+		t.NextOrder()
+		changed = true
+	}
+	return changed
+}
+
+// processOrder works on one order. It reports whether the order is complete
+// and whether the train's order state changed
+func (e *Engine) processOrder(t *trains.Train, order *trains.Order) (bool, bool) {
+	switch order.Type {
+	case trains.OrderGoTo:
+		lead := t.Lead()
+		if lead.X == order.X && lead.Y == order.Y {
+			// Arriving means stopping, whatever the next order does
+			t.Destination = nil
+			t.Speed, t.Acceleration, t.Progress = 0, 0, 0
+			return true, true
+		}
+		if t.Destination == nil || t.Destination.X != order.X || t.Destination.Y != order.Y || !t.IsMoving {
+			t.Destination = &trains.Destination{X: order.X, Y: order.Y}
+			t.IsMoving = true
+			return false, true
+		}
+		return false, false
+
+	case trains.OrderWait:
+		// The wait starts when the train gets to it rather than when the
+		// order before it finishes, so a wait can be the first order
+		changed := false
+		if !t.WaitStarted {
+			t.WaitTicks = order.Ticks
+			t.WaitStarted = true
+			changed = true
+		}
+		if t.WaitTicks == 0 {
+			return true, true
+		}
+		t.WaitTicks--
+		if t.IsMoving {
+			t.IsMoving = false
+			changed = true
+		}
+		return false, changed
+
+	case trains.OrderReverse:
+		e.reverseTrain(t)
+		return true, true
+	}
+	return false, false
+}
+
+// reverseTrain stops the train, swaps which end leads and points the new lead
+// car away from the rest of the train
+func (e *Engine) reverseTrain(t *trains.Train) {
+	// The train has to stop to change direction
+	t.Speed, t.Acceleration, t.Progress = 0, 0, 0
+
+	behind := t.TravelDir()
+	t.IsReversing = !t.IsReversing
+
+	lead := t.Lead()
+	pos := world.Pos{X: lead.X, Y: lead.Y}
+	if len(t.Cars) > 1 {
+		next := t.Cars[1]
+		if t.IsReversing {
+			next = t.Cars[len(t.Cars)-2]
+		}
+		for d := types.Dir(types.DirNorth); d <= types.DirWest; d <<= 1 {
+			if pos.Neighbour(d) == (world.Pos{X: next.X, Y: next.Y}) {
+				behind = d
+			}
 		}
 	}
+
+	ahead := types.OppositeDir(behind)
+	track := e.w.Tracks[pos]
+	if track == nil || track.Direction&ahead != 0 {
+		t.SetTravelDir(ahead)
+		return
+	}
+	if outgoing := track.Direction &^ behind; outgoing != 0 {
+		ahead = outgoing & -outgoing
+	}
+	t.SetTravelDir(ahead)
 }
 
-func (e *Engine) moveCars(cars []*trains.TrainCar, moveDir types.Dir, reverse bool) {
+// moveCars shifts every car one tile along and returns the position the last car vacated
+func (e *Engine) moveCars(cars []*trains.TrainCar, moveDir types.Dir, reverse bool) world.Pos {
 	start, end, step := 0, len(cars), 1
 	if reverse {
 		start, end, step = len(cars)-1, -1, -1
@@ -126,10 +439,10 @@ func (e *Engine) moveCars(cars []*trains.TrainCar, moveDir types.Dir, reverse bo
 
 	car := cars[start]
 
-	newPos := nextPos(world.Pos{X: car.X, Y: car.Y}, moveDir)
+	newPos := world.Pos{X: car.X, Y: car.Y}.Neighbour(moveDir)
 
 	prevPos := world.Pos{X: car.X, Y: car.Y}
-	prevDir := moveDir
+	prevDir := car.Direction
 	car.X, car.Y = newPos.X, newPos.Y
 	e.w.SetOccupied(world.Pos{X: car.X, Y: car.Y})
 
@@ -143,21 +456,7 @@ func (e *Engine) moveCars(cars []*trains.TrainCar, moveDir types.Dir, reverse bo
 		prevPos, prevDir = thisPrevPos, thisPrevDir
 	}
 	e.w.UnsetOccupied(prevPos)
-}
-
-func nextPos(pos world.Pos, dir types.Dir) world.Pos {
-	switch dir {
-	case types.DirNorth:
-		return world.Pos{X: pos.X, Y: pos.Y + 1}
-	case types.DirSouth:
-		return world.Pos{X: pos.X, Y: pos.Y - 1}
-	case types.DirEast:
-		return world.Pos{X: pos.X + 1, Y: pos.Y}
-	case types.DirWest:
-		return world.Pos{X: pos.X - 1, Y: pos.Y}
-	default:
-		return pos
-	}
+	return prevPos
 }
 
 func (e *Engine) getChunksInRegion(worldPos world.Pos) []*world.Chunk {
@@ -183,6 +482,12 @@ func (e *Engine) getChunksInRegion(worldPos world.Pos) []*world.Chunk {
 
 func (e *Engine) handlePlayerMessage(playerMsg playerMessage) {
 	msg := playerMsg.message
+	if capability := msg.requiredCapability(); capability != "" && !playerMsg.player.has(capability) {
+		logrus.WithField("player", playerMsg.playerID).WithField("capability", capability).
+			Debug("Ignoring message for a capability the player didn't agree to")
+		return
+	}
+
 	switch {
 	case msg.chatMessage != nil:
 		e.handleChatMessage(playerMsg)
@@ -190,32 +495,210 @@ func (e *Engine) handlePlayerMessage(playerMsg playerMessage) {
 		e.handleLoginMessage(playerMsg)
 	case msg.getChunksMessage != nil:
 		e.handleGetChunksMessage(playerMsg)
+	case msg.unsubscribeChunksMessage != nil:
+		e.handleUnsubscribeChunksMessage(playerMsg)
+	case msg.buildTrackMessage != nil:
+		e.handleBuildTrackMessage(playerMsg)
+	case msg.buildTrackPathMessage != nil:
+		e.handleBuildTrackPathMessage(playerMsg)
+	case msg.removeTrackMessage != nil:
+		e.handleRemoveTrackMessage(playerMsg)
+	case msg.inspectTileMessage != nil:
+		e.handleInspectTileMessage(playerMsg)
+	case msg.getBlocksMessage != nil:
+		e.handleGetBlocksMessage(playerMsg)
 	}
 }
 
 func (e *Engine) handleChatMessage(playerMsg playerMessage) {
 	entry := logrus.WithField("player", playerMsg.playerID).WithField("message", playerMsg.message.chatMessage.Message)
-	e.nm.broadcastCh <- outgoingMessage{chatMessage: playerMsg.message.chatMessage}
+
+	text := cleanChat(playerMsg.message.chatMessage.Message)
+	if text == "" {
+		return
+	}
+	if strings.HasPrefix(text, "/") {
+		e.runCommand(playerMsg, text)
+		return
+	}
+	// Never trust the client with who sent it
+	e.nm.broadcast(outgoingMessage{chatMessage: &message.ChatMessage{
+		Author:  playerMsg.player.username,
+		Message: text,
+	}})
 	entry.Debug("Player sent chat message")
 }
 
+// cleanChat strips anything that would mess up someone's terminal and cuts
+// the message down to size
+func cleanChat(text string) string {
+	runes := make([]rune, 0, min(len(text), message.MaxChatLength))
+	for _, r := range text {
+		if len(runes) == message.MaxChatLength {
+			break
+		}
+		if unicode.IsControl(r) {
+			continue
+		}
+		runes = append(runes, r)
+	}
+	return strings.TrimSpace(string(runes))
+}
+
 func (e *Engine) handleLoginMessage(playerMsg playerMessage) {
 	entry := logrus.WithField("player", playerMsg.playerID).WithField("message", playerMsg.message.loginMessage.Username)
 
+	if playerMsg.player.resumed && e.resumeSession(playerMsg) {
+		entry.Debug("Player resumed their session")
+		return
+	}
+
+	// Anything the player was subscribed to before is covered by the reload
+	e.nm.unsubscribeAll(playerMsg.playerID)
+
 	camPos := world.Pos{X: e.w.Width / 2, Y: e.w.Height / 2}
 
 	initialLoadMessage := message.InitialLoadMessage{
+		Tick:      e.tickCount,
 		Width:     e.w.Width,
 		Height:    e.w.Height,
 		CameraPos: world.Pos{X: camPos.X, Y: camPos.Y},
 		Chunks:    e.getChunksInRegion(camPos),
-		Trains:    e.w.Trains, // TODO: get trains in region
-		Tracks:    e.w.Tracks, // TODO: get tracks in region
 	}
-	*playerMsg.responseCh <- outgoingMessage{initialLoadMessage: &initialLoadMessage}
+	for _, chunk := range initialLoadMessage.Chunks {
+		e.nm.subscribe(playerMsg.playerID, chunk.Pos)
+	}
+	e.nm.send(playerMsg.player, outgoingMessage{initialLoadMessage: &initialLoadMessage})
 	entry.Debug("Player sent initial load message")
 }
 
+// resumeSession catches a reconnecting player up on what happened in their
+// area since their last tick. It returns false if we no longer have the
+// history and they need a full load
+func (e *Engine) resumeSession(playerMsg playerMessage) bool {
+	lastTick := playerMsg.message.loginMessage.LastTick
+	if lastTick > e.tickCount {
+		return false
+	}
+	changes, ok := e.history.since(lastTick)
+	if !ok {
+		return false
+	}
+
+	if len(changes) > 0 {
+		e.nm.sendInArea(playerMsg.player, outgoingMessage{
+			trackUpdatesMessage: &message.TrackUpdatesMessage{
+				Tick:    e.tickCount,
+				Changes: changes,
+			},
+		})
+	}
+
+	// Trains move every tick so just send where they all are now
+	states := make([]message.TrainState, 0, len(e.w.Trains))
+	for _, t := range e.w.Trains {
+		state := message.NewTrainState(t)
+		state.Chunks = trainChunks(t)
+		states = append(states, state)
+	}
+	e.nm.sendInArea(playerMsg.player, outgoingMessage{
+		trainUpdatesMessage: &message.TrainUpdatesMessage{
+			Tick:   e.tickCount,
+			Trains: states,
+		},
+	})
+	return true
+}
+
+func (e *Engine) handleInspectTileMessage(playerMsg playerMessage) {
+	pos := playerMsg.message.inspectTileMessage.Pos
+	if !e.w.InBounds(pos) {
+		return
+	}
+
+	info := message.TileInfoMessage{Pos: pos}
+	if block := e.bm.blockAt(pos); block != nil {
+		info.BlockID = uuid.UUID(block.ID).String()
+		if block.OccupiedBy != nil {
+			info.OccupiedBy = block.OccupiedBy.OccupierID()
+		}
+	}
+	e.nm.send(playerMsg.player, outgoingMessage{tileInfoMessage: &info})
+}
+
+// handleGetBlocksMessage tells the player which block each track in an area is
+// in, clients need this to draw signals and the block overlay
+func (e *Engine) handleGetBlocksMessage(playerMsg playerMessage) {
+	req := playerMsg.message.getBlocksMessage
+	// Changes after this are pushed as block updates, so this is only asked
+	// when the view moves and can't be made to cover the whole world
+	minPos := world.Pos{X: max(req.Min.X, 0), Y: max(req.Min.Y, 0)}
+	maxPos := world.Pos{
+		X: min(req.Max.X, e.w.Width-1, minPos.X+message.MaxBlocksSpan-1),
+		Y: min(req.Max.Y, e.w.Height-1, minPos.Y+message.MaxBlocksSpan-1),
+	}
+
+	reply := message.BlocksMessage{Min: minPos, Max: maxPos}
+	states := make(map[*types.Block]int)
+	for y := minPos.Y; y <= maxPos.Y; y++ {
+		for x := minPos.X; x <= maxPos.X; x++ {
+			pos := world.Pos{X: x, Y: y}
+			if e.w.TileAt(pos).Type != types.TileTrack {
+				continue
+			}
+			block := e.bm.blockAt(pos)
+			if block == nil {
+				continue
+			}
+
+			i, ok := states[block]
+			if !ok {
+				i = len(reply.Blocks)
+				states[block] = i
+				reply.Blocks = append(reply.Blocks, e.blockState(block))
+			}
+			reply.Blocks[i].Tiles = append(reply.Blocks[i].Tiles, pos)
+		}
+	}
+	e.nm.send(playerMsg.player, outgoingMessage{blocksMessage: &reply})
+}
+
+// broadcastBlockChanges tells players who can see them about the blocks that
+// were claimed or released this tick
+func (e *Engine) broadcastBlockChanges() {
+	changed := e.bm.takeChanged()
+	if len(changed) == 0 {
+		return
+	}
+	states := make([]message.BlockState, 0, len(changed))
+	for _, block := range changed {
+		state := e.blockState(block)
+		state.Chunks = e.bm.chunksOf(block)
+		states = append(states, state)
+	}
+	e.nm.broadcast(outgoingMessage{
+		blockUpdatesMessage: &message.BlockUpdatesMessage{
+			Tick:   e.tickCount,
+			Blocks: states,
+		},
+	})
+}
+
+func (e *Engine) blockState(block *types.Block) message.BlockState {
+	state := message.BlockState{ID: uuid.UUID(block.ID).String()}
+	if block.OccupiedBy == nil {
+		return state
+	}
+	state.OccupiedBy = block.OccupiedBy.OccupierID()
+
+	// Trains reserve blocks ahead of themselves, so one held by a train with
+	// none of its cars inside is only reserved
+	if t, ok := block.OccupiedBy.(*trains.Train); ok {
+		state.Reserved = !e.bm.hasCarIn(t, block)
+	}
+	return state
+}
+
 func (e *Engine) handleGetChunksMessage(playerMsg playerMessage) {
 	entry := logrus.WithField("player", playerMsg.playerID).WithField("message", playerMsg.message.getChunksMessage)
 
@@ -227,8 +710,16 @@ func (e *Engine) handleGetChunksMessage(playerMsg playerMessage) {
 		if pos.X < 0 || pos.Y < 0 || chunkStartX >= e.w.Width || chunkStartY >= e.w.Height {
 			continue
 		}
+		e.nm.subscribe(playerMsg.playerID, pos)
 		chunks = append(chunks, e.w.ChunkAt(pos))
 	}
-	*playerMsg.responseCh <- outgoingMessage{chunksMessage: &message.ChunksMessage{Chunks: chunks}}
+	e.nm.send(playerMsg.player, outgoingMessage{chunksMessage: &message.ChunksMessage{Chunks: chunks}})
 	entry.Debugf("Player requested chunks")
 }
+
+func (e *Engine) handleUnsubscribeChunksMessage(playerMsg playerMessage) {
+	entry := logrus.WithField("player", playerMsg.playerID).WithField("message", playerMsg.message.unsubscribeChunksMessage)
+
+	e.nm.unsubscribe(playerMsg.playerID, playerMsg.message.unsubscribeChunksMessage.Positions...)
+	entry.Debugf("Player unsubscribed from chunks")
+}
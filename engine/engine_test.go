@@ -0,0 +1,552 @@
+package engine
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+)
+
+// straightLine lays east-west track along y from x0 to x1
+func straightLine(w *world.World, y, x0, x1 int) {
+	for x := x0; x <= x1; x++ {
+		w.AddTrack(world.Pos{X: x, Y: y}, &types.Track{Direction: types.DirEast | types.DirWest})
+	}
+}
+
+// eastbound puts a two car train on the line with its locomotive at x
+func eastbound(w *world.World, x, y int, moving bool) *trains.Train {
+	t := &trains.Train{
+		IsMoving: moving,
+		Cars: []*trains.TrainCar{
+			{X: x, Y: y, Direction: types.DirEast, Type: trains.CarTypeLocomotive},
+			{X: x - 1, Y: y, Direction: types.DirEast, Type: trains.CarTypeCargo},
+		},
+	}
+	w.AddTrain(t)
+	return t
+}
+
+func TestTickBroadcastsTrainsThatChanged(t *testing.T) {
+	w := world.New(20, 20)
+	straightLine(w, 2, 0, 19)
+	straightLine(w, 5, 0, 19)
+	moving := eastbound(w, 3, 2, true)
+	eastbound(w, 3, 5, false)
+	e := New(w, time.Millisecond)
+
+	e.tick()
+
+	// Block changes come first when the train claims the blocks it is in
+	for {
+		select {
+		case msg := <-e.nm.broadcastCh:
+			if msg.blockUpdatesMessage != nil {
+				continue
+			}
+			updates := msg.trainUpdatesMessage
+			if updates == nil {
+				t.Fatal("tick didn't broadcast train updates")
+			}
+			if updates.Tick != 1 {
+				t.Errorf("got tick %d, want 1", updates.Tick)
+			}
+			if len(updates.Trains) != 1 || updates.Trains[0].ID != moving.ID {
+				t.Fatalf("got %d trains, want just the moving one", len(updates.Trains))
+			}
+			if updates.Trains[0].Speed != moving.Speed || moving.Speed <= 0 {
+				t.Errorf("got speed %d, want the train's speed %d picking up", updates.Trains[0].Speed, moving.Speed)
+			}
+			return
+		default:
+			t.Fatal("nothing was broadcast")
+		}
+	}
+}
+
+func TestTrainPicksUpSpeedAndMoves(t *testing.T) {
+	w := world.New(40, 20)
+	straightLine(w, 2, 0, 39)
+	train := eastbound(w, 3, 2, true)
+	e := New(w, time.Millisecond)
+
+	lastSpeed, lastX := 0, 3
+	runUntil(t, e, 500, func() bool {
+		if train.Speed < lastSpeed && train.Lead().X < 30 {
+			t.Fatalf("train slowed from %d to %d with clear track ahead", lastSpeed, train.Speed)
+		}
+		if x := train.Lead().X; x < lastX || x > lastX+1 {
+			t.Fatalf("train jumped from %d to %d", lastX, x)
+		}
+		lastSpeed, lastX = train.Speed, train.Lead().X
+		return train.Speed == train.MaxSpeed()
+	})
+	if train.Cars[1].X != train.Lead().X-1 {
+		t.Error("car didn't follow the locomotive")
+	}
+
+	// It stops at the end of the line without running off it
+	runUntil(t, e, 500, func() bool { return train.Speed == 0 })
+	if x := train.Lead().X; x != 39 {
+		t.Errorf("train stopped at %d, want the end of the line at 39", x)
+	}
+}
+
+func TestTrainUpdatesNameTheChunksBothSides(t *testing.T) {
+	w := world.New(2*world.ChunkSize, 10)
+	straightLine(w, 2, 0, 2*world.ChunkSize-1)
+	train := eastbound(w, world.ChunkSize-1, 2, true)
+	e := New(w, time.Millisecond)
+
+	// The train starts the tick its last car crosses over in both chunks
+	var crossing []world.Pos
+	for range 500 {
+		e.tick()
+		select {
+		case msg := <-e.nm.broadcastCh:
+			if train.Cars[1].X == world.ChunkSize {
+				crossing = msg.trainUpdatesMessage.Trains[0].Chunks
+			}
+		default:
+		}
+		if crossing != nil {
+			break
+		}
+	}
+	if len(crossing) != 2 {
+		t.Errorf("got chunks %v for the tick the train crossed, want the one it left and the one it entered", crossing)
+	}
+}
+
+func TestTickQuietWhenNothingMoves(t *testing.T) {
+	w := world.New(20, 20)
+	straightLine(w, 2, 0, 19)
+	straightLine(w, 5, 0, 10)
+	eastbound(w, 3, 2, false)
+	// Blocked by the end of the line
+	eastbound(w, 10, 5, true)
+	e := New(w, time.Millisecond)
+
+	// The first tick finds the train is blocked, after that nothing changes
+	e.tick()
+	drainBroadcasts(e)
+	e.tick()
+
+	select {
+	case msg := <-e.nm.broadcastCh:
+		t.Errorf("broadcast %+v when no train moved", msg)
+	default:
+	}
+	if e.tickCount != 2 {
+		t.Errorf("tick count is %d, want 2", e.tickCount)
+	}
+}
+
+// runUntil ticks the engine until done reports true, giving up after limit ticks
+func runUntil(t *testing.T, e *Engine, limit int, done func() bool) {
+	t.Helper()
+	for range limit {
+		if done() {
+			return
+		}
+		e.tick()
+		drainBroadcasts(e)
+	}
+	if !done() {
+		t.Fatalf("still not done after %d ticks", limit)
+	}
+}
+
+func drainBroadcasts(e *Engine) {
+	for {
+		select {
+		case <-e.nm.broadcastCh:
+		default:
+			return
+		}
+	}
+}
+
+func TestTrainBrakesBehindStoppedTrain(t *testing.T) {
+	w := world.New(40, 20)
+	straightLine(w, 2, 0, 39)
+	train := eastbound(w, 3, 2, true)
+	eastbound(w, 30, 2, false)
+	e := New(w, time.Millisecond)
+
+	runUntil(t, e, 500, func() bool { return train.Speed > 0 && train.Acceleration < 0 })
+	runUntil(t, e, 500, func() bool { return train.Speed == 0 })
+	if x := train.Lead().X; x != 28 {
+		t.Errorf("train stopped at %d, want it right behind the car at 29", x)
+	}
+	if !train.Blocked {
+		t.Error("train stuck behind another isn't blocked")
+	}
+}
+
+func TestTrainReachesNearbyDestinations(t *testing.T) {
+	for tiles := 1; tiles <= 6; tiles++ {
+		w := world.New(40, 20)
+		straightLine(w, 2, 0, 39)
+		train := eastbound(w, 3, 2, false)
+		train.Orders = []trains.Order{{Type: trains.OrderGoTo, X: 3 + tiles, Y: 2}}
+		e := New(w, time.Millisecond)
+
+		runUntil(t, e, 500, func() bool { return train.Order() == nil })
+		if x := train.Lead().X; x != 3+tiles {
+			t.Errorf("%d tiles away: train stopped at %d, want %d", tiles, x, 3+tiles)
+		}
+	}
+}
+
+func TestTrainReservesTheBlockAhead(t *testing.T) {
+	w := world.New(60, 20)
+	straightLine(w, 2, 0, 59)
+	signal := world.Pos{X: 30, Y: 2}
+	w.Tracks[signal].HasSignal = true
+	w.Tracks[signal].SignalDir = types.DirEast
+	train := eastbound(w, 3, 2, true)
+	e := New(w, time.Millisecond)
+	beyond := e.bm.blockAt(world.Pos{X: 31, Y: 2})
+
+	runUntil(t, e, 500, func() bool { return beyond.OccupiedBy != nil })
+	if x := train.Lead().X; x >= 31 {
+		t.Fatalf("block was claimed when the train entered it at %d, want it reserved ahead of time", x)
+	}
+	if state := e.blockState(beyond); state.OccupiedBy != train.OccupierID() || !state.Reserved {
+		t.Errorf("got %+v, want it reserved by the train", state)
+	}
+
+	runUntil(t, e, 500, func() bool { return train.Lead().X >= 31 })
+	if state := e.blockState(beyond); state.Reserved {
+		t.Error("block is still only reserved with the train in it")
+	}
+}
+
+func TestStoppedTrainReleasesReservations(t *testing.T) {
+	w := world.New(60, 20)
+	straightLine(w, 2, 0, 59)
+	w.Tracks[world.Pos{X: 30, Y: 2}].HasSignal = true
+	w.Tracks[world.Pos{X: 30, Y: 2}].SignalDir = types.DirEast
+	train := eastbound(w, 3, 2, true)
+	e := New(w, time.Millisecond)
+	beyond := e.bm.blockAt(world.Pos{X: 31, Y: 2})
+
+	runUntil(t, e, 500, func() bool { return beyond.OccupiedBy != nil })
+	train.IsMoving = false
+	e.tick()
+	if beyond.OccupiedBy != nil {
+		t.Error("stopped train kept the block ahead reserved")
+	}
+}
+
+func TestTrainWorksThroughOrders(t *testing.T) {
+	w := world.New(40, 20)
+	straightLine(w, 2, 0, 39)
+	train := eastbound(w, 3, 2, false)
+	train.Orders = []trains.Order{
+		{Type: trains.OrderGoTo, X: 14, Y: 2},
+		{Type: trains.OrderWait, Ticks: 5},
+		{Type: trains.OrderGoTo, X: 28, Y: 2},
+	}
+	e := New(w, time.Millisecond)
+
+	lead := train.Lead()
+	runUntil(t, e, 500, func() bool {
+		if train.CurrentOrder == 0 && lead.X > 14 {
+			t.Fatalf("train passed x 14 at %d without finishing its go to", lead.X)
+		}
+		return train.CurrentOrder == 1
+	})
+
+	waitingAt, waited := lead.X, 0
+	runUntil(t, e, 500, func() bool {
+		if lead.X != waitingAt {
+			t.Fatalf("train moved to %d while waiting", lead.X)
+		}
+		waited++
+		return train.CurrentOrder == 2
+	})
+	if waited < 5 {
+		t.Errorf("waited %d ticks, want at least 5", waited)
+	}
+
+	runUntil(t, e, 500, func() bool { return train.Order() == nil })
+	if lead.X != 28 || train.IsMoving {
+		t.Errorf("train ended at %d moving %v, want it stopped at 28", lead.X, train.IsMoving)
+	}
+	if train.Destination != nil {
+		t.Error("destination kept after the last go to")
+	}
+}
+
+func TestWaitCanBeTheFirstOrder(t *testing.T) {
+	w := world.New(40, 20)
+	straightLine(w, 2, 0, 39)
+	train := eastbound(w, 3, 2, true)
+	train.Orders = []trains.Order{
+		{Type: trains.OrderWait, Ticks: 5},
+		{Type: trains.OrderGoTo, X: 10, Y: 2},
+	}
+	e := New(w, time.Millisecond)
+
+	waited := 0
+	runUntil(t, e, 500, func() bool {
+		if train.Lead().X != 3 {
+			t.Fatalf("train moved to %d while waiting", train.Lead().X)
+		}
+		waited++
+		return train.CurrentOrder == 1
+	})
+	if waited < 5 {
+		t.Errorf("waited %d ticks, want at least 5", waited)
+	}
+}
+
+func TestGoToThenWaitStartsOnArrival(t *testing.T) {
+	w := world.New(40, 20)
+	straightLine(w, 2, 0, 39)
+	train := eastbound(w, 3, 2, false)
+	train.Orders = []trains.Order{
+		{Type: trains.OrderGoTo, X: 12, Y: 2},
+		{Type: trains.OrderWait, Ticks: 5},
+	}
+	e := New(w, time.Millisecond)
+
+	runUntil(t, e, 500, func() bool { return train.CurrentOrder == 1 })
+	if x := train.Lead().X; x != 12 || !train.WaitStarted {
+		t.Errorf("train finished its go to at %d with the wait started %v, want the wait started at 12", x, train.WaitStarted)
+	}
+	if train.Speed != 0 || train.Progress != 0 || train.IsMoving {
+		t.Errorf("arrived with speed %d progress %d moving %v, want it stopped", train.Speed, train.Progress, train.IsMoving)
+	}
+}
+
+func TestGoToThenReverseHeadsBack(t *testing.T) {
+	w := world.New(40, 20)
+	straightLine(w, 2, 0, 39)
+	train := eastbound(w, 3, 2, false)
+	train.Orders = []trains.Order{
+		{Type: trains.OrderGoTo, X: 20, Y: 2},
+		{Type: trains.OrderReverse},
+		{Type: trains.OrderGoTo, X: 8, Y: 2},
+	}
+	e := New(w, time.Millisecond)
+
+	runUntil(t, e, 500, func() bool { return train.CurrentOrder != 0 })
+	if train.CurrentOrder != 2 || !train.IsReversing || train.Cars[0].X != 20 {
+		t.Fatalf("train finished its go to on order %d reversing %v at %d, want it reversed at 20 and on the next go to", train.CurrentOrder, train.IsReversing, train.Cars[0].X)
+	}
+	// It stopped to reverse and has had one tick to pick up speed since
+	if train.Speed != train.AccelerationRate() || train.Progress != train.Speed {
+		t.Errorf("reversed with speed %d progress %d, want it starting again from a stand", train.Speed, train.Progress)
+	}
+
+	runUntil(t, e, 500, func() bool { return train.Order() == nil })
+	if x := train.Lead().X; x != 8 {
+		t.Errorf("train ended at %d, want 8", x)
+	}
+}
+
+func TestReverseOrder(t *testing.T) {
+	w := world.New(40, 20)
+	straightLine(w, 2, 0, 39)
+	train := eastbound(w, 20, 2, false)
+	train.Orders = []trains.Order{
+		{Type: trains.OrderReverse},
+		{Type: trains.OrderGoTo, X: 5, Y: 2},
+	}
+	e := New(w, time.Millisecond)
+
+	runUntil(t, e, 500, func() bool { return train.CurrentOrder == 1 })
+	if !train.IsReversing || train.Lead() != train.Cars[1] || train.TravelDir() != types.DirWest {
+		t.Fatalf("reversed train leads with %+v heading %s, want the last car heading west", *train.Lead(), train.TravelDir())
+	}
+
+	runUntil(t, e, 500, func() bool { return train.Order() == nil })
+	if lead := train.Lead(); lead.X != 5 || train.Cars[0].X != 6 {
+		t.Errorf("train ended with cars at %d and %d, want 6 and 5", train.Cars[0].X, lead.X)
+	}
+	// The cars still face the way they did before reversing
+	for _, car := range train.Cars {
+		if car.Direction != types.DirEast {
+			t.Errorf("car at %d faces %s, want east", car.X, car.Direction)
+		}
+	}
+}
+
+func TestReverseStopsTheTrain(t *testing.T) {
+	w := world.New(40, 20)
+	straightLine(w, 2, 0, 39)
+	train := eastbound(w, 20, 2, true)
+	train.Speed, train.Acceleration, train.Progress = 500, 40, 300
+	e := New(w, time.Millisecond)
+
+	e.reverseTrain(train)
+	if train.Speed != 0 || train.Acceleration != 0 || train.Progress != 0 {
+		t.Errorf("reversed with speed %d acceleration %d progress %d, want all 0", train.Speed, train.Acceleration, train.Progress)
+	}
+}
+
+func TestChatIsCleanedAndSignedByTheServer(t *testing.T) {
+	e := New(world.New(4, 4), time.Millisecond)
+	chat := func(text string) *message.ChatMessage {
+		msg, _ := fromPlayer(&incomingMessage{chatMessage: &message.ChatMessage{Author: "mallory", Message: text}})
+		msg.player.username = "alice"
+		e.handlePlayerMessage(msg)
+		select {
+		case out := <-e.nm.broadcastCh:
+			return out.chatMessage
+		default:
+			return nil
+		}
+	}
+
+	got := chat("  hi\x1b[2J there\n")
+	if got == nil || got.Author != "alice" || got.Message != "hi[2J there" {
+		t.Errorf("got %+v, want alice's message without the control characters", got)
+	}
+	if got := chat(strings.Repeat("é", message.MaxChatLength+10)); got == nil || utf8.RuneCountInString(got.Message) != message.MaxChatLength {
+		t.Error("long message wasn't cut down to size")
+	}
+	if got := chat(" \t\x07"); got != nil {
+		t.Errorf("blank message was broadcast: %+v", got)
+	}
+}
+
+func TestInspectTile(t *testing.T) {
+	w := world.New(20, 10)
+	straightLine(w, 2, 0, 19)
+	w.Tracks[world.Pos{X: 10, Y: 2}].HasSignal = true
+	w.Tracks[world.Pos{X: 10, Y: 2}].SignalDir = types.DirEast
+	train := eastbound(w, 4, 2, false)
+	e := New(w, time.Millisecond)
+
+	inspect := func(pos world.Pos) *message.TileInfoMessage {
+		msg, replies := fromPlayer(&incomingMessage{inspectTileMessage: &message.InspectTileMessage{Pos: pos}})
+		e.handlePlayerMessage(msg)
+		msgs := drain(t, replies)
+		if len(msgs) == 0 {
+			return nil
+		}
+		return msgs[0].tileInfoMessage
+	}
+
+	occupied := inspect(world.Pos{X: 7, Y: 2})
+	if occupied == nil || occupied.BlockID == "" || occupied.OccupiedBy != train.OccupierID() {
+		t.Errorf("got %+v, want the block the train is in", occupied)
+	}
+	free := inspect(world.Pos{X: 15, Y: 2})
+	if free == nil || free.BlockID == "" || free.BlockID == occupied.BlockID || free.OccupiedBy != "" {
+		t.Errorf("got %+v, want the empty block past the signal", free)
+	}
+	if grass := inspect(world.Pos{X: 5, Y: 5}); grass == nil || grass.BlockID != "" {
+		t.Errorf("got %+v for grass, want no block", grass)
+	}
+	if outside := inspect(world.Pos{X: 30, Y: 5}); outside != nil {
+		t.Errorf("got %+v for a tile outside the world", outside)
+	}
+}
+
+func TestGetBlocks(t *testing.T) {
+	w := world.New(20, 10)
+	straightLine(w, 2, 0, 19)
+	w.Tracks[world.Pos{X: 10, Y: 2}].HasSignal = true
+	w.Tracks[world.Pos{X: 10, Y: 2}].SignalDir = types.DirEast
+	train := eastbound(w, 4, 2, false)
+	e := New(w, time.Millisecond)
+
+	msg, replies := fromPlayer(&incomingMessage{getBlocksMessage: &message.GetBlocksMessage{
+		Min: world.Pos{X: -5, Y: 0},
+		Max: world.Pos{X: 12, Y: 5},
+	}})
+	e.handlePlayerMessage(msg)
+	msgs := drain(t, replies)
+	if len(msgs) != 1 || msgs[0].blocksMessage == nil {
+		t.Fatalf("got %+v, want the blocks", msgs)
+	}
+
+	blocks := msgs[0].blocksMessage.Blocks
+	if len(blocks) != 2 {
+		t.Fatalf("got %d blocks, want the one with the train and the one past the signal", len(blocks))
+	}
+	tiles := 0
+	for _, block := range blocks {
+		tiles += len(block.Tiles)
+		for _, pos := range block.Tiles {
+			if pos.X > 12 {
+				t.Errorf("got a tile at %v outside the area asked about", pos)
+			}
+		}
+		held := block.OccupiedBy != ""
+		if held != slices.Contains(block.Tiles, world.Pos{X: 4, Y: 2}) {
+			t.Errorf("block %+v is held %v, want only the train's block held", block, held)
+		}
+		if held && (block.OccupiedBy != train.OccupierID() || block.Reserved) {
+			t.Errorf("got %+v, want it occupied by the train", block)
+		}
+	}
+	if tiles != 13 {
+		t.Errorf("got %d tiles, want the 13 tracks in the area", tiles)
+	}
+}
+
+func TestGetBlocksKeepsToTheMaximumSpan(t *testing.T) {
+	w := world.New(2*message.MaxBlocksSpan, 4)
+	straightLine(w, 2, 0, w.Width-1)
+	e := New(w, time.Millisecond)
+
+	msg, replies := fromPlayer(&incomingMessage{getBlocksMessage: &message.GetBlocksMessage{
+		Min: world.Pos{X: 0, Y: 0},
+		Max: world.Pos{X: w.Width - 1, Y: 3},
+	}})
+	e.handlePlayerMessage(msg)
+	msgs := drain(t, replies)
+	if len(msgs) != 1 || msgs[0].blocksMessage == nil {
+		t.Fatalf("got %+v, want the blocks", msgs)
+	}
+	reply := msgs[0].blocksMessage
+	if reply.Max.X != message.MaxBlocksSpan-1 {
+		t.Errorf("answered up to %v, want the area cut to %d tiles across", reply.Max, message.MaxBlocksSpan)
+	}
+	if len(reply.Blocks) != 1 || len(reply.Blocks[0].Tiles) != message.MaxBlocksSpan {
+		t.Errorf("got %d blocks, want the one block's tiles inside the cut area", len(reply.Blocks))
+	}
+}
+
+func TestTickBroadcastsBlockChanges(t *testing.T) {
+	w := world.New(20, 10)
+	straightLine(w, 2, 0, 19)
+	w.Tracks[world.Pos{X: 10, Y: 2}].HasSignal = true
+	w.Tracks[world.Pos{X: 10, Y: 2}].SignalDir = types.DirEast
+	train := eastbound(w, 4, 2, true)
+	e := New(w, time.Millisecond)
+
+	e.tick()
+	var updates *message.BlockUpdatesMessage
+	for len(e.nm.broadcastCh) > 0 {
+		if msg := <-e.nm.broadcastCh; msg.blockUpdatesMessage != nil {
+			updates = msg.blockUpdatesMessage
+		}
+	}
+	if updates == nil || len(updates.Blocks) != 1 {
+		t.Fatalf("got %+v, want the block the train claimed", updates)
+	}
+	if got := updates.Blocks[0]; got.OccupiedBy != train.OccupierID() || len(got.Chunks) != 1 {
+		t.Errorf("got %+v, want it held by the train in the one chunk", got)
+	}
+
+	// Nothing changed hands so nothing more is sent
+	e.tick()
+	for len(e.nm.broadcastCh) > 0 {
+		if msg := <-e.nm.broadcastCh; msg.blockUpdatesMessage != nil {
+			t.Errorf("got %+v on a tick where no block changed hands", msg.blockUpdatesMessage)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package engine
+
+import "github.com/danharasymiw/bit-rail/message"
+
+// historyTicks is how far back a reconnecting player can resume from, about
+// five minutes at the default tick rate
+const historyTicks = 2000
+
+// changeLog remembers recent track changes so a player that reconnects can be
+// sent what they missed instead of the whole world
+type changeLog struct {
+	entries []changeLogEntry
+	// startTick is the oldest tick we still have every change for
+	startTick uint64
+}
+
+type changeLogEntry struct {
+	tick    uint64
+	changes []message.TileChange
+}
+
+func newChangeLog(tick uint64) *changeLog {
+	return &changeLog{startTick: tick}
+}
+
+func (l *changeLog) record(tick uint64, changes []message.TileChange) {
+	l.entries = append(l.entries, changeLogEntry{tick: tick, changes: changes})
+
+	if tick < historyTicks {
+		return
+	}
+	cutoff := tick - historyTicks
+	dropped := 0
+	for dropped < len(l.entries) && l.entries[dropped].tick < cutoff {
+		dropped++
+	}
+	if dropped > 0 {
+		l.entries = append(l.entries[:0], l.entries[dropped:]...)
+		l.startTick = cutoff
+	}
+}
+
+// since returns every change made on or after tick. Changes are complete
+// tile states so resending ones the player already has is harmless. It returns
+// false if the log doesn't go back that far
+func (l *changeLog) since(tick uint64) ([]message.TileChange, bool) {
+	if tick < l.startTick {
+		return nil, false
+	}
+
+	changes := make([]message.TileChange, 0)
+	for _, entry := range l.entries {
+		if entry.tick >= tick {
+			changes = append(changes, entry.changes...)
+		}
+	}
+	return changes, true
+}
@@ -1,52 +1,108 @@
 package engine
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/danharasymiw/bit-rail/accounts"
 	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/world"
 	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 )
 
 // PlayerMessage wraps an incoming message with player context
 type playerMessage struct {
-	playerID   string
-	message    *incomingMessage
-	responseCh *chan outgoingMessage
+	playerID string
+	message  *incomingMessage
+	player   *playerConnection
 }
 
 type incomingMessage struct {
-	loginMessage     *message.LoginMessage
-	chatMessage      *message.ChatMessage
-	getChunksMessage *message.GetChunksMessage
+	loginMessage             *message.LoginMessage
+	chatMessage              *message.ChatMessage
+	getChunksMessage         *message.GetChunksMessage
+	unsubscribeChunksMessage *message.UnsubscribeChunksMessage
+	buildTrackMessage        *message.BuildTrackMessage
+	buildTrackPathMessage    *message.BuildTrackPathMessage
+	removeTrackMessage       *message.RemoveTrackMessage
+	inspectTileMessage       *message.InspectTileMessage
+	getBlocksMessage         *message.GetBlocksMessage
 }
 
 type outgoingMessage struct {
-	initialLoadMessage *message.InitialLoadMessage
-	chatMessage        *message.ChatMessage
-	chunksMessage      *message.ChunksMessage
+	initialLoadMessage   *message.InitialLoadMessage
+	chatMessage          *message.ChatMessage
+	chunksMessage        *message.ChunksMessage
+	trainUpdatesMessage  *message.TrainUpdatesMessage
+	trackUpdatesMessage  *message.TrackUpdatesMessage
+	buildRejectedMessage *message.BuildRejectedMessage
+	moveCameraMessage    *message.MoveCameraMessage
+	tileInfoMessage      *message.TileInfoMessage
+	blocksMessage        *message.BlocksMessage
+	blockUpdatesMessage  *message.BlockUpdatesMessage
 }
 
 type playerConnection struct {
-	playerID   string
-	ws         *websocket.Conn
-	outgoingCh chan outgoingMessage
+	// playerID is the player's account ID, username is only for display
+	playerID string
+	username string
+	operator bool
+	ws       *websocket.Conn
+	codec    message.Codec
+	features []string
+	queue    *sendQueue
+	session  *session
+	// resumed is set when the connection picked up an existing session
+	resumed bool
+}
+
+// sessionTimeout is how long a session is kept after its connection drops
+const sessionTimeout = 5 * time.Minute
+
+// maxIncomingFrame is the biggest frame a client can send. Nothing a client
+// sends comes close, so this only stops a bad one making us decode megabytes
+const maxIncomingFrame = 64 * 1024
+
+// session outlives a single connection so a player can reconnect and carry on
+// where they left off. Sessions are guarded by the network manager's playersMu
+type session struct {
+	token    string
+	playerID string
+	// subscriptions are the chunks the player gets area updates for
+	subscriptions map[world.Pos]struct{}
+	// disconnectedAt is when the last connection dropped, zero while connected
+	disconnectedAt time.Time
 }
 
 type networkManager struct {
-	players     map[string]*playerConnection
+	accounts    *accounts.Store
+	players     map[string]*playerConnection // keyed by account ID
+	sessions    map[string]*session
 	playersMu   sync.RWMutex
 	upgrader    websocket.Upgrader
 	incomingCh  chan playerMessage   // Shared channel for ALL players
 	broadcastCh chan outgoingMessage // Shared channel for ALL players
+
+	// droppedMessages counts messages that never made it into a queue
+	droppedMessages atomic.Uint64
+	// slowConsumers counts players disconnected for falling behind
+	slowConsumers atomic.Uint64
 }
 
-func newNetworkManager() *networkManager {
-	return &networkManager{
+func newNetworkManager(store *accounts.Store) *networkManager {
+	nm := &networkManager{
+		accounts:    store,
 		players:     make(map[string]*playerConnection),
+		sessions:    make(map[string]*session),
 		incomingCh:  make(chan playerMessage, 100),
 		broadcastCh: make(chan outgoingMessage, 100),
 		upgrader: websocket.Upgrader{
@@ -55,6 +111,7 @@ func newNetworkManager() *networkManager {
 			CheckOrigin:     func(r *http.Request) bool { return true },
 		},
 	}
+	return nm
 }
 
 func (nm *networkManager) startServer(readyCh chan<- struct{}) {
@@ -67,7 +124,6 @@ func (nm *networkManager) startServer(readyCh chan<- struct{}) {
 	logrus.Info("Server ready on :2977")
 	close(readyCh)
 
-	go nm.broadcastLoop()
 	http.Serve(listener, nil)
 }
 
@@ -76,65 +132,203 @@ func (nm *networkManager) wsHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	ws.SetReadLimit(maxIncomingFrame)
 
-	var msg message.Message
-	if err := ws.ReadJSON(&msg); err != nil {
+	// Clients pick the codec, we answer in whichever one the login arrived in
+	frameType, frame, err := ws.ReadMessage()
+	if err != nil {
 		ws.Close()
 		return
 	}
+	codec := message.CodecForFrameType(frameType)
 
-	if msg.Type != message.MessageTypeLogin {
+	msgType, body, err := codec.Decode(frame)
+	if err != nil || msgType != message.MessageTypeLogin {
 		logrus.Warn("First message was not login")
 		ws.Close()
 		return
 	}
 
 	var loginMsg message.LoginMessage
-	if err := json.Unmarshal(msg.Data, &loginMsg); err != nil {
+	if err := codec.DecodeBody(body, &loginMsg); err != nil {
 		logrus.Errorf("Failed to unmarshal login message: %v", err)
-		ws.Close()
+		nm.rejectLogin(ws, codec, "could not read login, the client is probably on a different protocol version")
 		return
 	}
 
-	// Create response channel for this player
-	responseCh := make(chan outgoingMessage, 100)
+	if loginMsg.ProtocolVersion != message.ProtocolVersion {
+		logrus.WithField("player", loginMsg.Username).Warnf("Client protocol version %d does not match ours", loginMsg.ProtocolVersion)
+		nm.rejectLogin(ws, codec, fmt.Sprintf("server speaks protocol version %d but the client speaks %d", message.ProtocolVersion, loginMsg.ProtocolVersion))
+		return
+	}
 
+	account, err := nm.authenticate(&loginMsg)
+	if err != nil {
+		logrus.WithField("player", loginMsg.Username).Infof("Login refused: %v", err)
+		nm.rejectLogin(ws, codec, err.Error())
+		return
+	}
+	// Nothing past here needs the password
+	loginMsg.Password = ""
+
+	features := message.SharedCapabilities(loginMsg.Capabilities)
 	playerConn := &playerConnection{
-		playerID:   loginMsg.Username,
-		ws:         ws,
-		outgoingCh: responseCh,
+		playerID: account.ID.String(),
+		username: account.Username,
+		operator: account.Operator,
+		ws:       ws,
+		codec:    codec,
+		features: features,
+		queue:    newSendQueue(),
+	}
+	if !playerConn.has(message.CapabilitySessionResume) {
+		loginMsg.SessionToken = ""
+	}
+	if err := nm.addPlayer(playerConn, loginMsg.SessionToken); err != nil {
+		logrus.WithField("player", playerConn.playerID).Infof("Login refused: %v", err)
+		nm.rejectLogin(ws, codec, err.Error())
+		return
 	}
 
-	nm.playersMu.Lock()
-	nm.players[loginMsg.Username] = playerConn
-	nm.playersMu.Unlock()
+	result := &message.LoginResultMessage{
+		Accepted:      true,
+		ServerVersion: message.ProtocolVersion,
+		Features:      features,
+		PlayerID:      playerConn.playerID,
+	}
+	if playerConn.has(message.CapabilitySessionResume) {
+		result.SessionToken = playerConn.session.token
+	}
+	if err := nm.writeDirect(ws, codec, message.MessageTypeLoginResult, result); err != nil {
+		logrus.Errorf("Failed to accept login: %v", err)
+		nm.disconnectPlayer(playerConn)
+		ws.Close()
+		return
+	}
 
 	// Send login message to engine for processing
 	nm.incomingCh <- playerMessage{
-		playerID:   loginMsg.Username,
-		message:    &incomingMessage{loginMessage: &loginMsg},
-		responseCh: &responseCh,
+		playerID: playerConn.playerID,
+		message:  &incomingMessage{loginMessage: &loginMsg},
+		player:   playerConn,
 	}
 
 	go nm.handleRead(playerConn)
 	nm.handleWrite(playerConn)
 }
 
+// authenticate checks the login against the account store, registering the
+// account first if the player asked for that
+func (nm *networkManager) authenticate(loginMsg *message.LoginMessage) (*accounts.Account, error) {
+	if loginMsg.Register {
+		account, err := nm.accounts.Register(loginMsg.Username, loginMsg.Password)
+		if err == nil {
+			logrus.WithField("player", account.ID).WithField("username", account.Username).Info("Registered new account")
+			return account, nil
+		}
+		if !errors.Is(err, accounts.ErrUsernameTaken) {
+			return nil, err
+		}
+		// Fall through and log in to the existing account
+	}
+
+	account, err := nm.accounts.Authenticate(loginMsg.Username, loginMsg.Password)
+	if errors.Is(err, accounts.ErrUnknownAccount) || errors.Is(err, accounts.ErrWrongPassword) {
+		// Don't tell people which usernames exist
+		return nil, fmt.Errorf("wrong username or password")
+	}
+	return account, err
+}
+
+// addPlayer registers the connection, resuming the player's session if the
+// token matches one and starting a new one otherwise. A player can only be
+// connected once, a second login is refused unless it is resuming the session
+// of the first
+func (nm *networkManager) addPlayer(playerConn *playerConnection, token string) error {
+	nm.playersMu.Lock()
+	defer nm.playersMu.Unlock()
+
+	for t, sess := range nm.sessions {
+		if !sess.disconnectedAt.IsZero() && time.Since(sess.disconnectedAt) > sessionTimeout {
+			delete(nm.sessions, t)
+		}
+	}
+
+	sess, resumed := nm.sessions[token]
+	resumed = resumed && sess.playerID == playerConn.playerID
+
+	if old, ok := nm.players[playerConn.playerID]; ok {
+		if !resumed || old.session != sess {
+			return fmt.Errorf("already logged in somewhere else")
+		}
+		// The old connection is probably dead and we haven't noticed yet
+		old.queue.close()
+		old.ws.Close()
+	}
+
+	if !resumed {
+		tokenBytes := make([]byte, 16)
+		if _, err := rand.Read(tokenBytes); err != nil {
+			return err
+		}
+		sess = &session{
+			token:         hex.EncodeToString(tokenBytes),
+			playerID:      playerConn.playerID,
+			subscriptions: make(map[world.Pos]struct{}),
+		}
+		nm.sessions[sess.token] = sess
+	}
+
+	sess.disconnectedAt = time.Time{}
+	playerConn.session = sess
+	playerConn.resumed = resumed
+	nm.players[playerConn.playerID] = playerConn
+	return nil
+}
+
+// writeDirect sends a message straight down the websocket. It is only safe
+// before handleWrite has started for the connection
+func (nm *networkManager) writeDirect(ws *websocket.Conn, codec message.Codec, msgType message.MessageType, body any) error {
+	frame, err := codec.Encode(msgType, body)
+	if err != nil {
+		return err
+	}
+	return ws.WriteMessage(codec.FrameType(), frame)
+}
+
+func (nm *networkManager) rejectLogin(ws *websocket.Conn, codec message.Codec, reason string) {
+	err := nm.writeDirect(ws, codec, message.MessageTypeLoginResult, &message.LoginResultMessage{
+		Accepted:      false,
+		ServerVersion: message.ProtocolVersion,
+		Reason:        reason,
+	})
+	if err != nil {
+		logrus.Debugf("Failed to send login rejection: %v", err)
+	}
+	ws.Close()
+}
+
 func (nm *networkManager) handleRead(playerConn *playerConnection) {
 	logEntry := logrus.WithField("player", playerConn.playerID)
 	for {
-		var msg message.Message
-		if err := playerConn.ws.ReadJSON(&msg); err != nil {
+		_, frame, err := playerConn.ws.ReadMessage()
+		if err != nil {
 			logEntry.Debugf("WebSocket read error: %v", err)
 			return
 		}
 
+		msgType, body, err := playerConn.codec.Decode(frame)
+		if err != nil {
+			logEntry.Errorf("Error decoding message: %v", err)
+			continue
+		}
+
 		var incoming incomingMessage
 
-		switch msg.Type {
+		switch msgType {
 		case message.MessageTypeChat:
 			var chatMsg message.ChatMessage
-			if err := json.Unmarshal(msg.Data, &chatMsg); err != nil {
+			if err := playerConn.codec.DecodeBody(body, &chatMsg); err != nil {
 				logEntry.Errorf("Error unmarshaling chat message: %v", err)
 				continue
 			}
@@ -142,60 +336,129 @@ func (nm *networkManager) handleRead(playerConn *playerConnection) {
 
 		case message.MessageTypeGetChunks:
 			var getChunksMsg message.GetChunksMessage
-			if err := json.Unmarshal(msg.Data, &getChunksMsg); err != nil {
+			if err := playerConn.codec.DecodeBody(body, &getChunksMsg); err != nil {
 				logEntry.Errorf("Error unmarshaling get chunks message: %v", err)
 				continue
 			}
 			incoming.getChunksMessage = &getChunksMsg
 
+		case message.MessageTypeUnsubscribeChunks:
+			var unsubscribeChunksMsg message.UnsubscribeChunksMessage
+			if err := playerConn.codec.DecodeBody(body, &unsubscribeChunksMsg); err != nil {
+				logEntry.Errorf("Error unmarshaling unsubscribe chunks message: %v", err)
+				continue
+			}
+			incoming.unsubscribeChunksMessage = &unsubscribeChunksMsg
+
+		case message.MessageTypeBuildTrack:
+			var buildTrackMsg message.BuildTrackMessage
+			if err := playerConn.codec.DecodeBody(body, &buildTrackMsg); err != nil {
+				logEntry.Errorf("Error unmarshaling build track message: %v", err)
+				continue
+			}
+			incoming.buildTrackMessage = &buildTrackMsg
+
+		case message.MessageTypeBuildTrackPath:
+			var buildTrackPathMsg message.BuildTrackPathMessage
+			if err := playerConn.codec.DecodeBody(body, &buildTrackPathMsg); err != nil {
+				logEntry.Errorf("Error unmarshaling build track path message: %v", err)
+				continue
+			}
+			incoming.buildTrackPathMessage = &buildTrackPathMsg
+
+		case message.MessageTypeRemoveTrack:
+			var removeTrackMsg message.RemoveTrackMessage
+			if err := playerConn.codec.DecodeBody(body, &removeTrackMsg); err != nil {
+				logEntry.Errorf("Error unmarshaling remove track message: %v", err)
+				continue
+			}
+			incoming.removeTrackMessage = &removeTrackMsg
+
+		case message.MessageTypeInspectTile:
+			var inspectTileMsg message.InspectTileMessage
+			if err := playerConn.codec.DecodeBody(body, &inspectTileMsg); err != nil {
+				logEntry.Errorf("Error unmarshaling inspect tile message: %v", err)
+				continue
+			}
+			incoming.inspectTileMessage = &inspectTileMsg
+
+		case message.MessageTypeGetBlocks:
+			var getBlocksMsg message.GetBlocksMessage
+			if err := playerConn.codec.DecodeBody(body, &getBlocksMsg); err != nil {
+				logEntry.Errorf("Error unmarshaling get blocks message: %v", err)
+				continue
+			}
+			incoming.getBlocksMessage = &getBlocksMsg
+
 		default:
-			logEntry.Debugf("Unknown message type: %d", msg.Type)
+			logEntry.Debugf("Unknown message type: %d", msgType)
 			continue
 		}
 
 		nm.incomingCh <- playerMessage{
-			playerID:   playerConn.playerID,
-			message:    &incoming,
-			responseCh: &playerConn.outgoingCh,
+			playerID: playerConn.playerID,
+			message:  &incoming,
+			player:   playerConn,
 		}
 	}
 }
 
 func (nm *networkManager) handleWrite(playerConn *playerConnection) {
 	logEntry := logrus.WithField("player", playerConn.playerID)
-	defer nm.disconnectPlayer(playerConn.playerID)
+	defer nm.disconnectPlayer(playerConn)
 	defer playerConn.ws.Close()
 
-	for outgoing := range playerConn.outgoingCh {
+	for {
+		outgoing, ok := playerConn.queue.pop()
+		if !ok {
+			return
+		}
+
 		var msgType message.MessageType
-		var data []byte
-		var err error
+		var body any
 
 		if outgoing.initialLoadMessage != nil {
 			msgType = message.MessageTypeInitialLoad
-			data, err = json.Marshal(outgoing.initialLoadMessage)
+			body = outgoing.initialLoadMessage
 		} else if outgoing.chatMessage != nil {
 			msgType = message.MessageTypeChat
-			data, err = json.Marshal(outgoing.chatMessage)
+			body = outgoing.chatMessage
 		} else if outgoing.chunksMessage != nil {
 			msgType = message.MessageTypeChunks
-			data, err = json.Marshal(outgoing.chunksMessage)
+			body = outgoing.chunksMessage
+		} else if outgoing.trainUpdatesMessage != nil {
+			msgType = message.MessageTypeTrainUpdates
+			body = outgoing.trainUpdatesMessage
+		} else if outgoing.trackUpdatesMessage != nil {
+			msgType = message.MessageTypeTrackUpdates
+			body = outgoing.trackUpdatesMessage
+		} else if outgoing.buildRejectedMessage != nil {
+			msgType = message.MessageTypeBuildRejected
+			body = outgoing.buildRejectedMessage
+		} else if outgoing.moveCameraMessage != nil {
+			msgType = message.MessageTypeMoveCamera
+			body = outgoing.moveCameraMessage
+		} else if outgoing.tileInfoMessage != nil {
+			msgType = message.MessageTypeTileInfo
+			body = outgoing.tileInfoMessage
+		} else if outgoing.blocksMessage != nil {
+			msgType = message.MessageTypeBlocks
+			body = outgoing.blocksMessage
+		} else if outgoing.blockUpdatesMessage != nil {
+			msgType = message.MessageTypeBlockUpdates
+			body = outgoing.blockUpdatesMessage
 		} else {
 			logEntry.Warn("Unknown outgoing message type")
 			continue
 		}
 
+		frame, err := playerConn.codec.Encode(msgType, body)
 		if err != nil {
 			logEntry.Errorf("Error marshaling message: %v", err)
 			continue
 		}
 
-		msg := message.Message{
-			Type: msgType,
-			Data: data,
-		}
-
-		if err := playerConn.ws.WriteJSON(msg); err != nil {
+		if err := playerConn.ws.WriteMessage(playerConn.codec.FrameType(), frame); err != nil {
 			logEntry.Errorf("WebSocket write error: %v", err)
 			return
 		}
@@ -206,18 +469,233 @@ func (nm *networkManager) broadcastLoop() {
 	for msg := range nm.broadcastCh {
 		nm.playersMu.RLock()
 		for _, player := range nm.players {
-			player.outgoingCh <- msg
+			if filtered, ok := msg.forSubscriber(player.session.subscriptions); ok {
+				nm.send(player, filtered)
+			}
 		}
 		nm.playersMu.RUnlock()
 	}
 }
 
-func (nm *networkManager) disconnectPlayer(playerID string) {
+// broadcast queues a message for every player. Chat is dropped if the
+// broadcast loop is behind, anything else waits for it as losing world state
+// would leave every client out of sync. The loop never blocks on a player so
+// the wait is short
+func (nm *networkManager) broadcast(msg outgoingMessage) {
+	if msg.chatMessage == nil {
+		nm.broadcastCh <- msg
+		return
+	}
+	select {
+	case nm.broadcastCh <- msg:
+	default:
+		if dropped := nm.droppedMessages.Add(1); dropped%100 == 1 {
+			logrus.WithField("dropped", dropped).Warn("Broadcast queue is full, dropping chat")
+		}
+	}
+}
+
+// sendInArea queues an area update for one player, trimmed to the chunks they
+// are subscribed to
+func (nm *networkManager) sendInArea(player *playerConnection, msg outgoingMessage) {
+	nm.playersMu.RLock()
+	filtered, ok := msg.forSubscriber(player.session.subscriptions)
+	nm.playersMu.RUnlock()
+	if ok {
+		nm.send(player, filtered)
+	}
+}
+
+// send queues a message for one player without blocking. Players that have
+// fallen too far behind are disconnected rather than holding everyone else up
+func (nm *networkManager) send(player *playerConnection, msg outgoingMessage) {
+	msg, ok := msg.forCapabilities(player)
+	if !ok {
+		return
+	}
+	if player.queue.push(msg) {
+		return
+	}
+
+	nm.droppedMessages.Add(1)
+	nm.slowConsumers.Add(1)
+	logrus.WithField("player", player.playerID).
+		WithField("dropped", player.queue.droppedCount()).
+		WithField("slowConsumers", nm.slowConsumers.Load()).
+		Warn("Disconnecting player that can't keep up")
+	// Closing the queue stops the writer, which closes the socket and
+	// removes the player
+	player.queue.close()
+}
+
+// has reports whether the capability was agreed on at login
+func (p *playerConnection) has(capability string) bool {
+	return slices.Contains(p.features, capability)
+}
+
+// forCapabilities trims a message down to what the player agreed to at login.
+// It returns false if the player can't take the message at all
+func (msg outgoingMessage) forCapabilities(player *playerConnection) (outgoingMessage, bool) {
+	switch {
+	case msg.trainUpdatesMessage != nil:
+		if !player.has(message.CapabilityTrainUpdates) {
+			return msg, false
+		}
+		if player.has(message.CapabilityTrainOrders) {
+			return msg, true
+		}
+		// The fields stay as the codec needs them, they're just left empty
+		states := make([]message.TrainState, len(msg.trainUpdatesMessage.Trains))
+		for i, state := range msg.trainUpdatesMessage.Trains {
+			state.Orders, state.CurrentOrder, state.RepeatOrders, state.WaitTicks = nil, 0, false, 0
+			states[i] = state
+		}
+		msg.trainUpdatesMessage = &message.TrainUpdatesMessage{Tick: msg.trainUpdatesMessage.Tick, Trains: states}
+	case msg.buildRejectedMessage != nil:
+		return msg, player.has(message.CapabilityTrackBuilding)
+	case msg.moveCameraMessage != nil:
+		return msg, player.has(message.CapabilityMoveCamera)
+	case msg.tileInfoMessage != nil:
+		return msg, player.has(message.CapabilityTileInspect)
+	case msg.blocksMessage != nil, msg.blockUpdatesMessage != nil:
+		return msg, player.has(message.CapabilityBlockStates)
+	}
+	return msg, true
+}
+
+// requiredCapability is the capability a player needs to have agreed to for
+// the server to act on the message, empty if anyone can send it
+func (msg *incomingMessage) requiredCapability() string {
+	switch {
+	case msg.buildTrackMessage != nil, msg.buildTrackPathMessage != nil, msg.removeTrackMessage != nil:
+		return message.CapabilityTrackBuilding
+	case msg.inspectTileMessage != nil:
+		return message.CapabilityTileInspect
+	case msg.getBlocksMessage != nil:
+		return message.CapabilityBlockStates
+	}
+	return ""
+}
+
+// forSubscriber trims area updates down to the parts inside the subscribed
+// chunks. It returns false if nothing is left for the player. Messages that
+// aren't tied to an area go to everyone
+func (msg outgoingMessage) forSubscriber(subscriptions map[world.Pos]struct{}) (outgoingMessage, bool) {
+	subscribed := func(chunkPos world.Pos) bool {
+		_, ok := subscriptions[chunkPos]
+		return ok
+	}
+
+	switch {
+	case msg.trainUpdatesMessage != nil:
+		states := make([]message.TrainState, 0, len(msg.trainUpdatesMessage.Trains))
+		for _, state := range msg.trainUpdatesMessage.Trains {
+			if slices.ContainsFunc(state.Chunks, subscribed) {
+				states = append(states, state)
+			}
+		}
+		if len(states) == 0 {
+			return outgoingMessage{}, false
+		}
+		return outgoingMessage{trainUpdatesMessage: &message.TrainUpdatesMessage{
+			Tick:   msg.trainUpdatesMessage.Tick,
+			Trains: states,
+		}}, true
+
+	case msg.trackUpdatesMessage != nil:
+		changes := make([]message.TileChange, 0, len(msg.trackUpdatesMessage.Changes))
+		for _, change := range msg.trackUpdatesMessage.Changes {
+			if subscribed(world.TileToChunkPos(change.Pos)) {
+				changes = append(changes, change)
+			}
+		}
+		if len(changes) == 0 {
+			return outgoingMessage{}, false
+		}
+		return outgoingMessage{trackUpdatesMessage: &message.TrackUpdatesMessage{
+			Tick:    msg.trackUpdatesMessage.Tick,
+			Changes: changes,
+		}}, true
+
+	case msg.blockUpdatesMessage != nil:
+		states := make([]message.BlockState, 0, len(msg.blockUpdatesMessage.Blocks))
+		for _, state := range msg.blockUpdatesMessage.Blocks {
+			if slices.ContainsFunc(state.Chunks, subscribed) {
+				states = append(states, state)
+			}
+		}
+		if len(states) == 0 {
+			return outgoingMessage{}, false
+		}
+		return outgoingMessage{blockUpdatesMessage: &message.BlockUpdatesMessage{
+			Tick:   msg.blockUpdatesMessage.Tick,
+			Blocks: states,
+		}}, true
+	}
+	return msg, true
+}
+
+// subscribe starts sending the player area updates for the chunks
+func (nm *networkManager) subscribe(playerID string, chunkPositions ...world.Pos) {
+	nm.playersMu.Lock()
+	defer nm.playersMu.Unlock()
+
+	player, ok := nm.players[playerID]
+	if !ok {
+		return
+	}
+	for _, pos := range chunkPositions {
+		player.session.subscriptions[pos] = struct{}{}
+	}
+}
+
+func (nm *networkManager) unsubscribe(playerID string, chunkPositions ...world.Pos) {
 	nm.playersMu.Lock()
-	if player, exists := nm.players[playerID]; exists {
-		close(player.outgoingCh)
-		delete(nm.players, playerID)
-		logrus.Debugf("Player %s disconnected", playerID)
+	defer nm.playersMu.Unlock()
+
+	player, ok := nm.players[playerID]
+	if !ok {
+		return
+	}
+	for _, pos := range chunkPositions {
+		delete(player.session.subscriptions, pos)
+	}
+}
+
+// usernames returns the names of everyone connected
+func (nm *networkManager) usernames() []string {
+	nm.playersMu.RLock()
+	defer nm.playersMu.RUnlock()
+
+	names := make([]string, 0, len(nm.players))
+	for _, player := range nm.players {
+		names = append(names, player.username)
+	}
+	return names
+}
+
+// unsubscribeAll stops every area update for the player
+func (nm *networkManager) unsubscribeAll(playerID string) {
+	nm.playersMu.Lock()
+	defer nm.playersMu.Unlock()
+
+	if player, ok := nm.players[playerID]; ok {
+		clear(player.session.subscriptions)
+	}
+}
+
+// disconnectPlayer removes the connection, keeping the session around in case
+// the player comes back
+func (nm *networkManager) disconnectPlayer(playerConn *playerConnection) {
+	nm.playersMu.Lock()
+	defer nm.playersMu.Unlock()
+
+	playerConn.queue.close()
+	// A reconnect may have already replaced this connection
+	if nm.players[playerConn.playerID] != playerConn {
+		return
 	}
-	nm.playersMu.Unlock()
+	delete(nm.players, playerConn.playerID)
+	playerConn.session.disconnectedAt = time.Now()
+	logrus.WithField("player", playerConn.playerID).WithField("dropped", playerConn.queue.droppedCount()).Debug("Player disconnected")
 }
@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danharasymiw/bit-rail/accounts"
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// dialLogin connects to the server, logs in and returns the server's answer
+func dialLogin(t *testing.T, srv *httptest.Server, login *message.LoginMessage) (*websocket.Conn, *message.LoginResultMessage) {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	codec := message.BinaryCodec{}
+	frame, err := codec.Encode(message.MessageTypeLogin, login)
+	if err != nil {
+		t.Fatalf("encode login: %v", err)
+	}
+	if err := ws.WriteMessage(codec.FrameType(), frame); err != nil {
+		t.Fatalf("send login: %v", err)
+	}
+
+	_, frame, err = ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("read login result: %v", err)
+	}
+	msgType, body, err := codec.Decode(frame)
+	if err != nil || msgType != message.MessageTypeLoginResult {
+		t.Fatalf("got message type %d (%v), want a login result", msgType, err)
+	}
+	var result message.LoginResultMessage
+	if err := codec.DecodeBody(body, &result); err != nil {
+		t.Fatalf("decode login result: %v", err)
+	}
+	return ws, &result
+}
+
+func TestLoginHandshake(t *testing.T) {
+	nm := newNetworkManager(accounts.NewMemoryStore())
+	srv := httptest.NewServer(http.HandlerFunc(nm.wsHandler))
+	defer srv.Close()
+
+	_, result := dialLogin(t, srv, &message.LoginMessage{ProtocolVersion: message.ProtocolVersion + 1, Username: "old"})
+	if result.Accepted || result.ServerVersion != message.ProtocolVersion || result.Reason == "" {
+		t.Errorf("other protocol version got %+v, want a rejection saying why", result)
+	}
+
+	_, result = dialLogin(t, srv, &message.LoginMessage{
+		ProtocolVersion: message.ProtocolVersion,
+		Username:        "alice",
+		Password:        "correct horse",
+	})
+	if result.Accepted {
+		t.Error("login to an account that doesn't exist was accepted")
+	}
+
+	_, result = dialLogin(t, srv, &message.LoginMessage{
+		ProtocolVersion: message.ProtocolVersion,
+		Username:        "alice",
+		Password:        "correct horse",
+		Register:        true,
+		Capabilities:    []string{"from-the-future", message.CapabilityTrainUpdates, message.CapabilitySessionResume},
+	})
+	if !result.Accepted {
+		t.Fatalf("login rejected: %s", result.Reason)
+	}
+	if result.PlayerID == "" || result.SessionToken == "" {
+		t.Errorf("got %+v, want a player ID and session token", result)
+	}
+	if !slices.Equal(result.Features, []string{message.CapabilityTrainUpdates, message.CapabilitySessionResume}) {
+		t.Errorf("got features %v, want only the one both sides have", result.Features)
+	}
+
+	login := <-nm.incomingCh
+	if login.playerID != result.PlayerID || login.message.loginMessage == nil {
+		t.Errorf("engine got %+v, want alice's login", login)
+	}
+	if login.message.loginMessage.Password != "" {
+		t.Error("password was passed on to the engine")
+	}
+
+	// The account is there now but the password has to match
+	_, result = dialLogin(t, srv, &message.LoginMessage{
+		ProtocolVersion: message.ProtocolVersion,
+		Username:        "alice",
+		Password:        "wrong horse",
+	})
+	if result.Accepted {
+		t.Error("wrong password was accepted")
+	}
+}
+
+func TestForSubscriberTrimsAreaUpdates(t *testing.T) {
+	here, there := world.Pos{X: 0, Y: 0}, world.Pos{X: 1, Y: 0}
+	subscriptions := map[world.Pos]struct{}{here: {}}
+	inside, outside := uuid.New(), uuid.New()
+
+	trainUpdates := outgoingMessage{trainUpdatesMessage: &message.TrainUpdatesMessage{
+		Tick: 4,
+		Trains: []message.TrainState{
+			{ID: inside, Chunks: []world.Pos{there, here}},
+			{ID: outside, Chunks: []world.Pos{there}},
+		},
+	}}
+	got, ok := trainUpdates.forSubscriber(subscriptions)
+	if !ok || got.trainUpdatesMessage.Tick != 4 || len(got.trainUpdatesMessage.Trains) != 1 || got.trainUpdatesMessage.Trains[0].ID != inside {
+		t.Errorf("train updates: got %+v, want just the train in a subscribed chunk", got.trainUpdatesMessage)
+	}
+
+	trackUpdates := outgoingMessage{trackUpdatesMessage: &message.TrackUpdatesMessage{
+		Changes: []message.TileChange{{Pos: world.Pos{X: 3, Y: 3}}, {Pos: world.Pos{X: world.ChunkSize + 3, Y: 3}}},
+	}}
+	got, ok = trackUpdates.forSubscriber(subscriptions)
+	if !ok || len(got.trackUpdatesMessage.Changes) != 1 || got.trackUpdatesMessage.Changes[0].Pos != (world.Pos{X: 3, Y: 3}) {
+		t.Errorf("track updates: got %+v, want just the change in a subscribed chunk", got.trackUpdatesMessage)
+	}
+
+	if _, ok := trackUpdates.forSubscriber(map[world.Pos]struct{}{}); ok {
+		t.Error("player without subscriptions got track updates")
+	}
+	chat := outgoingMessage{chatMessage: &message.ChatMessage{Message: "hi"}}
+	if got, ok := chat.forSubscriber(nil); !ok || got.chatMessage != chat.chatMessage {
+		t.Error("chat should go to everyone")
+	}
+}
+
+func TestForCapabilitiesTrimsToWhatWasAgreed(t *testing.T) {
+	player := &playerConnection{features: []string{message.CapabilityTrainUpdates}}
+
+	trainUpdates := outgoingMessage{trainUpdatesMessage: &message.TrainUpdatesMessage{
+		Tick:   4,
+		Trains: []message.TrainState{{ID: uuid.New(), Orders: []trains.Order{{Type: trains.OrderWait, Ticks: 5}}, WaitTicks: 3}},
+	}}
+	got, ok := trainUpdates.forCapabilities(player)
+	if !ok || got.trainUpdatesMessage.Tick != 4 || len(got.trainUpdatesMessage.Trains) != 1 {
+		t.Fatalf("got %+v, want the train update without orders", got.trainUpdatesMessage)
+	}
+	if state := got.trainUpdatesMessage.Trains[0]; state.Orders != nil || state.WaitTicks != 0 {
+		t.Errorf("orders were sent to a player without %s: %+v", message.CapabilityTrainOrders, state)
+	}
+	if trainUpdates.trainUpdatesMessage.Trains[0].Orders == nil {
+		t.Error("trimming changed the message other players get")
+	}
+
+	for _, msg := range []outgoingMessage{
+		{moveCameraMessage: &message.MoveCameraMessage{}},
+		{tileInfoMessage: &message.TileInfoMessage{}},
+		{blocksMessage: &message.BlocksMessage{}},
+		{buildRejectedMessage: &message.BuildRejectedMessage{}},
+	} {
+		if _, ok := msg.forCapabilities(player); ok {
+			t.Errorf("%+v was sent to a player who didn't agree to it", msg)
+		}
+	}
+	if _, ok := (outgoingMessage{chatMessage: &message.ChatMessage{}}).forCapabilities(player); !ok {
+		t.Error("chat should go to everyone")
+	}
+}
+
+func TestMessagesNeedingCapabilitiesAreIgnored(t *testing.T) {
+	e := New(world.New(world.ChunkSize, world.ChunkSize), time.Millisecond)
+	msg, replies := fromPlayer(&incomingMessage{buildTrackMessage: &message.BuildTrackMessage{
+		Pos:   world.Pos{X: 1, Y: 1},
+		Track: types.Track{Direction: types.DirEast | types.DirWest},
+	}})
+	msg.player.features = []string{message.CapabilityTrainUpdates}
+
+	e.handlePlayerMessage(msg)
+	if e.w.Tracks[world.Pos{X: 1, Y: 1}] != nil {
+		t.Error("track was built for a player without track building")
+	}
+	if msgs := drain(t, replies); len(msgs) != 0 {
+		t.Errorf("got %+v, want the message ignored", msgs)
+	}
+}
+
+func TestChunkRequestsSubscribe(t *testing.T) {
+	e := New(world.New(3*world.ChunkSize, world.ChunkSize), time.Millisecond)
+	getChunks, replies := fromPlayer(&incomingMessage{getChunksMessage: &message.GetChunksMessage{
+		Positions: []world.Pos{{X: 1}, {X: 2}, {X: 5}, {X: -1}},
+	}})
+	player := getChunks.player
+	e.nm.players["alice"] = player
+
+	e.handlePlayerMessage(getChunks)
+	msgs := drain(t, replies)
+	if len(msgs) != 1 || msgs[0].chunksMessage == nil || len(msgs[0].chunksMessage.Chunks) != 2 {
+		t.Fatalf("got %+v, want the two chunks inside the world", msgs)
+	}
+	if len(player.session.subscriptions) != 2 {
+		t.Errorf("subscribed to %v, want the two chunks sent", player.session.subscriptions)
+	}
+
+	unsubscribe, _ := fromPlayer(&incomingMessage{unsubscribeChunksMessage: &message.UnsubscribeChunksMessage{
+		Positions: []world.Pos{{X: 1}},
+	}})
+	unsubscribe.player = player
+	e.handlePlayerMessage(unsubscribe)
+	if _, ok := player.session.subscriptions[world.Pos{X: 2}]; !ok || len(player.session.subscriptions) != 1 {
+		t.Errorf("subscribed to %v after unsubscribing, want just chunk 2", player.session.subscriptions)
+	}
+}
@@ -0,0 +1,345 @@
+package engine
+
+import (
+	"container/heap"
+	"math/bits"
+	"slices"
+
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/google/uuid"
+)
+
+// routeNode is a junction or signal in the track graph. Each node keeps a
+// routing table that is filled in lazily as trains ask for directions
+type routeNode struct {
+	ID    types.NodeID
+	Pos   world.Pos
+	table map[routeKey]*routeEntry
+	// edges are the stretches of track leaving the node, walked the first
+	// time they are needed
+	edges map[types.Dir]*routeEdge
+}
+
+// routeEdge is the track from a node to the next one along it
+type routeEdge struct {
+	// tiles are the tracks passed through, ending with the node reached
+	tiles []world.Pos
+	// to is where the next node is and enteredFrom the side it is entered
+	// from. ok is false if the track runs out before reaching one
+	to          world.Pos
+	enteredFrom types.Dir
+	ok          bool
+}
+
+// routeKey depends on which side the train entered the node from, since a
+// train can't leave the way it came in
+type routeKey struct {
+	from types.Dir
+	dest world.Pos
+}
+
+type routeEntry struct {
+	next  types.Dir
+	cost  int
+	dirty bool
+	// deps are the nodes the search went through, a change to any of their
+	// edges could give a different route
+	deps []world.Pos
+}
+
+type router struct {
+	w     *world.World
+	nodes map[world.Pos]*routeNode
+	// owners are the nodes whose edges go through each tile
+	owners map[world.Pos][]world.Pos
+	// dependents are the routing table entries that depend on each node
+	dependents map[world.Pos]map[*routeEntry]struct{}
+}
+
+func newRouter(w *world.World) *router {
+	return &router{
+		w:          w,
+		nodes:      make(map[world.Pos]*routeNode),
+		owners:     make(map[world.Pos][]world.Pos),
+		dependents: make(map[world.Pos]map[*routeEntry]struct{}),
+	}
+}
+
+// isNode reports whether a track is somewhere a train can stop or turn
+func isNode(track *types.Track) bool {
+	return bits.OnesCount8(uint8(track.Direction)) > 2 || track.HasSignal
+}
+
+// nodeAt returns the node at pos, creating it the first time it is needed
+func (r *router) nodeAt(pos world.Pos) *routeNode {
+	if node, ok := r.nodes[pos]; ok {
+		return node
+	}
+	track := r.w.Tracks[pos]
+	if track == nil || !isNode(track) {
+		return nil
+	}
+	node := &routeNode{
+		ID:    types.NodeID(uuid.New()),
+		Pos:   pos,
+		table: make(map[routeKey]*routeEntry),
+		edges: make(map[types.Dir]*routeEdge),
+	}
+	r.nodes[pos] = node
+	return node
+}
+
+// edge returns the edge leaving the node in dir
+func (r *router) edge(node *routeNode, dir types.Dir) *routeEdge {
+	if edge, ok := node.edges[dir]; ok {
+		return edge
+	}
+	edge := r.walk(node.Pos, dir)
+	node.edges[dir] = edge
+	for _, pos := range edge.tiles {
+		r.owners[pos] = append(r.owners[pos], node.Pos)
+	}
+	return edge
+}
+
+// walk follows the track from pos in dir until it reaches a node or the
+// track runs out
+func (r *router) walk(pos world.Pos, dir types.Dir) *routeEdge {
+	edge := &routeEdge{}
+	curr := pos
+	for {
+		curr = curr.Neighbour(dir)
+		enteredFrom := types.OppositeDir(dir)
+		track := r.w.Tracks[curr]
+		if track == nil || track.Direction&enteredFrom == 0 {
+			return edge
+		}
+		edge.tiles = append(edge.tiles, curr)
+		if isNode(track) || curr == pos {
+			// Coming back round to where we started without passing a
+			// node is a loop with nowhere to turn off
+			edge.to, edge.enteredFrom, edge.ok = curr, enteredFrom, isNode(track)
+			return edge
+		}
+		dir = track.Direction &^ enteredFrom
+		if dir == types.DirNone {
+			return edge
+		}
+	}
+}
+
+// nextDir returns the direction a train that entered pos from the given side
+// should leave in to reach dest. It returns false if dest can't be reached
+func (r *router) nextDir(pos world.Pos, from types.Dir, dest world.Pos) (types.Dir, bool) {
+	next, _, ok := r.route(pos, from, dest)
+	return next, ok
+}
+
+// route returns the first direction and length of the shortest route to dest.
+// Between nodes it follows the track to the next node and asks it the way
+func (r *router) route(pos world.Pos, from types.Dir, dest world.Pos) (types.Dir, int, bool) {
+	if node := r.nodeAt(pos); node != nil {
+		entry := r.entry(node, from, dest)
+		return entry.next, entry.cost, entry.next != types.DirNone
+	}
+
+	track := r.w.Tracks[pos]
+	if track == nil {
+		return types.DirNone, 0, false
+	}
+	best, bestCost := types.DirNone, 0
+	for d := types.Dir(types.DirNorth); d <= types.DirWest; d <<= 1 {
+		if track.Direction&d == 0 || d == from {
+			continue
+		}
+		edge := r.walk(pos, d)
+		cost, ok := 0, false
+		if i := slices.Index(edge.tiles, dest); i >= 0 {
+			cost, ok = i+1, true
+		} else if edge.ok {
+			node := r.nodeAt(edge.to)
+			if entry := r.entry(node, edge.enteredFrom, dest); entry.next != types.DirNone {
+				cost, ok = len(edge.tiles)+entry.cost, true
+			}
+		}
+		if ok && (best == types.DirNone || cost < bestCost) {
+			best, bestCost = d, cost
+		}
+	}
+	return best, bestCost, best != types.DirNone
+}
+
+// entry returns the node's routing table entry, working it out if it isn't
+// cached or has been dirtied by a track change
+func (r *router) entry(node *routeNode, from types.Dir, dest world.Pos) *routeEntry {
+	key := routeKey{from: from, dest: dest}
+	if entry, ok := node.table[key]; ok && !entry.dirty {
+		return entry
+	}
+
+	entry := r.search(node, from, dest)
+	node.table[key] = entry
+	for _, pos := range entry.deps {
+		if r.dependents[pos] == nil {
+			r.dependents[pos] = make(map[*routeEntry]struct{})
+		}
+		r.dependents[pos][entry] = struct{}{}
+	}
+	return entry
+}
+
+// search runs Dijkstra over the node graph from the node and returns the
+// first direction of the shortest route to dest along with its length. Nodes
+// with a cached route to dest aren't searched past, their cost is used instead
+func (r *router) search(start *routeNode, from types.Dir, dest world.Pos) *routeEntry {
+	result := &routeEntry{deps: []world.Pos{start.Pos}}
+	found := func(firstDir types.Dir, cost int) {
+		if result.next == types.DirNone || cost < result.cost {
+			result.next, result.cost = firstDir, cost
+		}
+	}
+
+	queue := &routeQueue{}
+	expand := func(node *routeNode, enteredFrom, firstDir types.Dir, distance int) {
+		track := r.w.Tracks[node.Pos]
+		for d := types.Dir(types.DirNorth); d <= types.DirWest; d <<= 1 {
+			if track.Direction&d == 0 || d == enteredFrom {
+				continue
+			}
+			first := firstDir
+			if first == types.DirNone {
+				first = d
+			}
+			edge := r.edge(node, d)
+			if i := slices.Index(edge.tiles, dest); i >= 0 {
+				found(first, distance+i+1)
+				continue
+			}
+			if edge.ok {
+				heap.Push(queue, routeState{
+					pos:         edge.to,
+					enteredFrom: edge.enteredFrom,
+					firstDir:    first,
+					distance:    distance + len(edge.tiles),
+				})
+			}
+		}
+	}
+	expand(start, from, types.DirNone, 0)
+
+	type visitKey struct {
+		pos         world.Pos
+		enteredFrom types.Dir
+	}
+	visited := map[visitKey]bool{}
+	for queue.Len() > 0 {
+		curr := heap.Pop(queue).(routeState)
+		if result.next != types.DirNone && curr.distance >= result.cost {
+			break
+		}
+		key := visitKey{pos: curr.pos, enteredFrom: curr.enteredFrom}
+		if visited[key] {
+			continue
+		}
+		visited[key] = true
+
+		node := r.nodeAt(curr.pos)
+		if node == nil {
+			continue
+		}
+		if !slices.Contains(result.deps, node.Pos) {
+			result.deps = append(result.deps, node.Pos)
+		}
+
+		if cached, ok := node.table[routeKey{from: curr.enteredFrom, dest: dest}]; ok && !cached.dirty {
+			for _, pos := range cached.deps {
+				if !slices.Contains(result.deps, pos) {
+					result.deps = append(result.deps, pos)
+				}
+			}
+			if cached.next != types.DirNone {
+				found(curr.firstDir, curr.distance+cached.cost)
+			}
+			continue
+		}
+		expand(node, curr.enteredFrom, curr.firstDir, curr.distance)
+	}
+	return result
+}
+
+// trackChanged drops the nodes whose edges touch pos and dirties the cached
+// routes that went through them. A new track can join on to any of the tiles
+// next to it so their nodes go too
+func (r *router) trackChanged(pos world.Pos) {
+	affected := map[world.Pos]bool{}
+	for _, p := range []world.Pos{pos, pos.Neighbour(types.DirNorth), pos.Neighbour(types.DirEast), pos.Neighbour(types.DirSouth), pos.Neighbour(types.DirWest)} {
+		if _, ok := r.nodes[p]; ok {
+			affected[p] = true
+		}
+		for _, owner := range r.owners[p] {
+			affected[owner] = true
+		}
+	}
+	for p := range affected {
+		r.dropNode(p)
+	}
+}
+
+// dropNode forgets the node at pos along with its edges and every cached
+// route that depends on it. It is created again the next time it is needed
+func (r *router) dropNode(pos world.Pos) {
+	node, ok := r.nodes[pos]
+	if ok {
+		for _, edge := range node.edges {
+			for _, tile := range edge.tiles {
+				r.owners[tile] = slices.DeleteFunc(r.owners[tile], func(owner world.Pos) bool { return owner == pos })
+				if len(r.owners[tile]) == 0 {
+					delete(r.owners, tile)
+				}
+			}
+		}
+		for _, entry := range node.table {
+			r.forget(entry)
+		}
+		delete(r.nodes, pos)
+	}
+	for entry := range r.dependents[pos] {
+		r.forget(entry)
+	}
+	delete(r.dependents, pos)
+}
+
+// forget dirties the entry and stops tracking what it depends on
+func (r *router) forget(entry *routeEntry) {
+	entry.dirty = true
+	for _, pos := range entry.deps {
+		delete(r.dependents[pos], entry)
+		if len(r.dependents[pos]) == 0 {
+			delete(r.dependents, pos)
+		}
+	}
+}
+
+// routeState is a node reached during a search, along with the side it was
+// entered from and the first direction taken from the start to get there
+type routeState struct {
+	pos         world.Pos
+	enteredFrom types.Dir
+	firstDir    types.Dir
+	distance    int
+}
+
+// routeQueue orders search states by distance for container/heap
+type routeQueue []routeState
+
+func (q routeQueue) Len() int           { return len(q) }
+func (q routeQueue) Less(i, j int) bool { return q[i].distance < q[j].distance }
+func (q routeQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *routeQueue) Push(x any)        { *q = append(*q, x.(routeState)) }
+func (q *routeQueue) Pop() any {
+	old := *q
+	state := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return state
+}
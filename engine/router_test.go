@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+)
+
+// loopWorld has a main line along y 2 from x 0 to 10 with junctions at x 3
+// and x 8. A longer branch leaves each junction to the north and joins them
+// along y 5. The tile at gap on the main line is left out
+func loopWorld(gap int) *world.World {
+	w := world.New(20, 20)
+	for x := 0; x <= 10; x++ {
+		if x != gap {
+			w.AddTrack(world.Pos{X: x, Y: 2}, &types.Track{Direction: types.DirEast | types.DirWest})
+		}
+	}
+	w.Tracks[world.Pos{X: 3, Y: 2}].Direction |= types.DirNorth
+	w.Tracks[world.Pos{X: 8, Y: 2}].Direction |= types.DirNorth
+
+	for y := 3; y <= 4; y++ {
+		w.AddTrack(world.Pos{X: 3, Y: y}, &types.Track{Direction: types.DirNorth | types.DirSouth})
+		w.AddTrack(world.Pos{X: 8, Y: y}, &types.Track{Direction: types.DirNorth | types.DirSouth})
+	}
+	w.AddTrack(world.Pos{X: 3, Y: 5}, &types.Track{Direction: types.DirSouth | types.DirEast})
+	for x := 4; x <= 7; x++ {
+		w.AddTrack(world.Pos{X: x, Y: 5}, &types.Track{Direction: types.DirEast | types.DirWest})
+	}
+	w.AddTrack(world.Pos{X: 8, Y: 5}, &types.Track{Direction: types.DirWest | types.DirSouth})
+
+	// A line of its own that nothing reaches
+	for x := 0; x <= 10; x++ {
+		w.AddTrack(world.Pos{X: x, Y: 9}, &types.Track{Direction: types.DirEast | types.DirWest})
+	}
+	return w
+}
+
+func TestRouterPicksBranch(t *testing.T) {
+	tests := []struct {
+		name string
+		pos  world.Pos
+		from types.Dir
+		dest world.Pos
+		want types.Dir
+	}{
+		{"straight on is shorter", world.Pos{X: 3, Y: 2}, types.DirWest, world.Pos{X: 10, Y: 2}, types.DirEast},
+		{"onto the branch", world.Pos{X: 3, Y: 2}, types.DirWest, world.Pos{X: 5, Y: 5}, types.DirNorth},
+		{"branch from the other end", world.Pos{X: 8, Y: 2}, types.DirEast, world.Pos{X: 6, Y: 5}, types.DirNorth},
+		{"main line from the other end", world.Pos{X: 8, Y: 2}, types.DirEast, world.Pos{X: 1, Y: 2}, types.DirWest},
+		{"between junctions", world.Pos{X: 5, Y: 2}, types.DirWest, world.Pos{X: 10, Y: 2}, types.DirEast},
+		{"round a corner", world.Pos{X: 3, Y: 4}, types.DirSouth, world.Pos{X: 7, Y: 5}, types.DirNorth},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRouter(loopWorld(-1))
+			// Asking twice gives the cached answer the second time
+			for range 2 {
+				got, ok := r.nextDir(tt.pos, tt.from, tt.dest)
+				if !ok || got != tt.want {
+					t.Fatalf("got %s %v, want %s", got, ok, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRouterUnreachable(t *testing.T) {
+	tests := []struct {
+		name string
+		pos  world.Pos
+		from types.Dir
+		dest world.Pos
+	}{
+		{"another line", world.Pos{X: 3, Y: 2}, types.DirWest, world.Pos{X: 5, Y: 9}},
+		{"not track", world.Pos{X: 3, Y: 2}, types.DirWest, world.Pos{X: 15, Y: 15}},
+		{"behind a dead end", world.Pos{X: 9, Y: 2}, types.DirWest, world.Pos{X: 0, Y: 2}},
+		{"starting off the track", world.Pos{X: 15, Y: 15}, types.DirWest, world.Pos{X: 0, Y: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRouter(loopWorld(-1))
+			if got, ok := r.nextDir(tt.pos, tt.from, tt.dest); ok {
+				t.Errorf("got a route %s to somewhere unreachable", got)
+			}
+		})
+	}
+}
+
+func TestRouterForgetsRoutesAfterTrackChanges(t *testing.T) {
+	start, dest := world.Pos{X: 3, Y: 2}, world.Pos{X: 10, Y: 2}
+	gap := world.Pos{X: 5, Y: 2}
+
+	t.Run("track removed from the route", func(t *testing.T) {
+		w := loopWorld(-1)
+		r := newRouter(w)
+		if got, _ := r.nextDir(start, types.DirWest, dest); got != types.DirEast {
+			t.Fatalf("got %s before the change, want east", got)
+		}
+		// Fill in routes from further back that pass through the same nodes
+		r.nextDir(world.Pos{X: 1, Y: 2}, types.DirWest, dest)
+		r.nextDir(world.Pos{X: 3, Y: 4}, types.DirNorth, dest)
+
+		w.RemoveTrack(gap)
+		r.trackChanged(gap)
+		if got, _ := r.nextDir(start, types.DirWest, dest); got != types.DirNorth {
+			t.Errorf("got %s, want the branch around the gap", got)
+		}
+		if got, _ := r.nextDir(world.Pos{X: 1, Y: 2}, types.DirWest, dest); got != types.DirEast {
+			t.Errorf("got %s from further back, want east to the junction", got)
+		}
+		if got, ok := r.nextDir(world.Pos{X: 4, Y: 2}, types.DirWest, dest); ok {
+			t.Errorf("got %s running into the gap, want no route", got)
+		}
+	})
+
+	t.Run("bypass added", func(t *testing.T) {
+		w := loopWorld(gap.X)
+		r := newRouter(w)
+		if got, _ := r.nextDir(start, types.DirWest, dest); got != types.DirNorth {
+			t.Fatalf("got %s before the change, want the branch", got)
+		}
+		r.nextDir(world.Pos{X: 1, Y: 2}, types.DirWest, dest)
+
+		w.AddTrack(gap, &types.Track{Direction: types.DirEast | types.DirWest})
+		r.trackChanged(gap)
+		if got, _ := r.nextDir(start, types.DirWest, dest); got != types.DirEast {
+			t.Errorf("got %s, want east through the new track", got)
+		}
+	})
+
+	t.Run("junction removed", func(t *testing.T) {
+		w := loopWorld(gap.X)
+		r := newRouter(w)
+		if _, ok := r.nextDir(start, types.DirWest, dest); !ok {
+			t.Fatal("no route before the change")
+		}
+
+		// Without the junction the branch can't be reached any more
+		junction := world.Pos{X: 3, Y: 2}
+		w.Tracks[junction].Direction = types.DirEast | types.DirWest
+		r.trackChanged(junction)
+		if got, ok := r.nextDir(world.Pos{X: 1, Y: 2}, types.DirWest, dest); ok {
+			t.Errorf("got %s, want no route once the junction is gone", got)
+		}
+	})
+}
+
+func TestRouterKeepsRoutesAwayFromTrackChanges(t *testing.T) {
+	w := loopWorld(-1)
+	r := newRouter(w)
+	start, dest := world.Pos{X: 3, Y: 2}, world.Pos{X: 10, Y: 2}
+	if _, ok := r.nextDir(start, types.DirWest, dest); !ok {
+		t.Fatal("no route before the change")
+	}
+	entry := r.nodes[start].table[routeKey{from: types.DirWest, dest: dest}]
+
+	// The other line isn't joined to anything the route uses
+	far := world.Pos{X: 5, Y: 9}
+	w.RemoveTrack(far)
+	r.trackChanged(far)
+	if entry.dirty || r.nodes[start] == nil {
+		t.Error("route was dropped for a change on a line it doesn't use")
+	}
+
+	near := world.Pos{X: 9, Y: 2}
+	w.RemoveTrack(near)
+	r.trackChanged(near)
+	if !entry.dirty {
+		t.Error("route kept after track it runs along was removed")
+	}
+}
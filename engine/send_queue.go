@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/google/uuid"
+)
+
+const (
+	// sendQueueLimit is how many messages can wait for a single player
+	sendQueueLimit = 64
+	// sendQueueHardLimit is how far a queue can run over sendQueueLimit during
+	// the grace period before we give up on the player anyway
+	sendQueueHardLimit = 4 * sendQueueLimit
+	// slowConsumerGrace is how long a player's queue can stay over its limit
+	// before we give up on them
+	slowConsumerGrace = 5 * time.Second
+)
+
+// sendQueue holds the messages waiting to be written to one player. Pushing
+// never blocks so the engine and broadcast loop can't be held up by a slow
+// connection
+type sendQueue struct {
+	mu     sync.Mutex
+	msgs   []outgoingMessage
+	wakeCh chan struct{}
+	closed bool
+
+	// fullSince is when the queue last went over its limit, zero while it
+	// has room
+	fullSince time.Time
+	dropped   uint64
+}
+
+func newSendQueue() *sendQueue {
+	return &sendQueue{
+		msgs:   make([]outgoingMessage, 0, sendQueueLimit),
+		wakeCh: make(chan struct{}, 1),
+	}
+}
+
+// push queues the message without blocking. It returns false if the player
+// has fallen too far behind and should be disconnected
+func (q *sendQueue) push(msg outgoingMessage) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return true
+	}
+	if q.coalesce(msg) {
+		return true
+	}
+
+	if len(q.msgs) >= sendQueueLimit {
+		now := time.Now()
+		if q.fullSince.IsZero() {
+			q.fullSince = now
+		}
+		overGrace := now.Sub(q.fullSince) > slowConsumerGrace
+
+		// Chat can be lost without harm so it goes first to make room
+		q.shedChat()
+		if msg.chatMessage != nil && len(q.msgs) >= sendQueueLimit {
+			q.dropped++
+			return !overGrace
+		}
+
+		// Anything else would leave the client out of sync with the world,
+		// so the queue is let run over its limit while the player catches up
+		if overGrace || len(q.msgs) >= sendQueueHardLimit {
+			q.dropped++
+			return false
+		}
+	}
+
+	q.msgs = append(q.msgs, msg)
+	q.wake()
+	return true
+}
+
+// coalesce folds the message into one that is already queued if the newer one
+// supersedes it. Must be called with mu held
+func (q *sendQueue) coalesce(msg outgoingMessage) bool {
+	switch {
+	case msg.trainUpdatesMessage != nil:
+		// Train states are complete so a newer one replaces an older one for
+		// the same train. Only the last message is merged into, otherwise the
+		// newer states could jump ahead of chunk data queued before them
+		if last := len(q.msgs) - 1; last >= 0 && q.msgs[last].trainUpdatesMessage != nil {
+			q.msgs[last].trainUpdatesMessage = mergeTrainUpdates(q.msgs[last].trainUpdatesMessage, msg.trainUpdatesMessage)
+			return true
+		}
+
+	case msg.trackUpdatesMessage != nil:
+		// Track changes are merged into the last message for the same reason
+		if last := len(q.msgs) - 1; last >= 0 && q.msgs[last].trackUpdatesMessage != nil {
+			changes := append([]message.TileChange(nil), q.msgs[last].trackUpdatesMessage.Changes...)
+			changes = append(changes, msg.trackUpdatesMessage.Changes...)
+			q.msgs[last].trackUpdatesMessage = &message.TrackUpdatesMessage{
+				Tick:    msg.trackUpdatesMessage.Tick,
+				Changes: changes,
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// shedChat throws away queued chat. Must be called with mu held
+func (q *sendQueue) shedChat() {
+	kept := q.msgs[:0]
+	for _, msg := range q.msgs {
+		if msg.chatMessage != nil {
+			q.dropped++
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	clear(q.msgs[len(kept):])
+	q.msgs = kept
+}
+
+func mergeTrainUpdates(older, newer *message.TrainUpdatesMessage) *message.TrainUpdatesMessage {
+	merged := &message.TrainUpdatesMessage{
+		Tick:   newer.Tick,
+		Trains: make([]message.TrainState, 0, len(older.Trains)+len(newer.Trains)),
+	}
+	index := make(map[uuid.UUID]int, len(older.Trains))
+	for _, state := range older.Trains {
+		index[state.ID] = len(merged.Trains)
+		merged.Trains = append(merged.Trains, state)
+	}
+	for _, state := range newer.Trains {
+		if i, ok := index[state.ID]; ok {
+			merged.Trains[i] = state
+			continue
+		}
+		merged.Trains = append(merged.Trains, state)
+	}
+	return merged
+}
+
+// pop waits for the next message. It returns false once the queue is closed
+func (q *sendQueue) pop() (outgoingMessage, bool) {
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			return outgoingMessage{}, false
+		}
+		if len(q.msgs) > 0 {
+			msg := q.msgs[0]
+			q.msgs[0] = outgoingMessage{}
+			q.msgs = q.msgs[1:]
+			if len(q.msgs) < sendQueueLimit {
+				q.fullSince = time.Time{}
+			}
+			q.mu.Unlock()
+			return msg, true
+		}
+		q.mu.Unlock()
+		<-q.wakeCh
+	}
+}
+
+// close stops the writer, anything still queued is thrown away
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.msgs = nil
+	q.wake()
+}
+
+func (q *sendQueue) droppedCount() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// wake lets a waiting pop know there is something to do. Must be called with
+// mu held
+func (q *sendQueue) wake() {
+	select {
+	case q.wakeCh <- struct{}{}:
+	default:
+	}
+}
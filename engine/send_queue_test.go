@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/google/uuid"
+)
+
+func trainUpdate(tick uint64, ids ...uuid.UUID) outgoingMessage {
+	msg := &message.TrainUpdatesMessage{Tick: tick}
+	for _, id := range ids {
+		msg.Trains = append(msg.Trains, message.TrainState{ID: id, Speed: int(tick)})
+	}
+	return outgoingMessage{trainUpdatesMessage: msg}
+}
+
+func trackUpdate(tick uint64, x int) outgoingMessage {
+	return outgoingMessage{trackUpdatesMessage: &message.TrackUpdatesMessage{
+		Tick:    tick,
+		Changes: []message.TileChange{{Pos: world.Pos{X: x}}},
+	}}
+}
+
+func chat(text string) outgoingMessage {
+	return outgoingMessage{chatMessage: &message.ChatMessage{Message: text}}
+}
+
+func chunks() outgoingMessage {
+	return outgoingMessage{chunksMessage: &message.ChunksMessage{}}
+}
+
+func drain(t *testing.T, q *sendQueue) []outgoingMessage {
+	t.Helper()
+	q.mu.Lock()
+	n := len(q.msgs)
+	q.mu.Unlock()
+
+	msgs := make([]outgoingMessage, 0, n)
+	for range n {
+		msg, ok := q.pop()
+		if !ok {
+			t.Fatal("queue closed while draining")
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestSendQueueCoalescesTrainUpdatesAtTail(t *testing.T) {
+	q := newSendQueue()
+	a, b := uuid.New(), uuid.New()
+
+	q.push(trainUpdate(1, a))
+	q.push(trainUpdate(2, a, b))
+
+	msgs := drain(t, q)
+	if len(msgs) != 1 {
+		t.Fatalf("got %d messages, want 1", len(msgs))
+	}
+	got := msgs[0].trainUpdatesMessage
+	if got.Tick != 2 || len(got.Trains) != 2 {
+		t.Fatalf("got tick %d with %d trains, want tick 2 with 2", got.Tick, len(got.Trains))
+	}
+	if got.Trains[0].ID != a || got.Trains[0].Speed != 2 {
+		t.Errorf("older state for train a wasn't replaced: %+v", got.Trains[0])
+	}
+}
+
+func TestSendQueueKeepsTrainUpdatesBehindChunks(t *testing.T) {
+	q := newSendQueue()
+	a := uuid.New()
+
+	q.push(trainUpdate(1, a))
+	q.push(chunks())
+	q.push(trainUpdate(2, a))
+
+	msgs := drain(t, q)
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3", len(msgs))
+	}
+	if msgs[0].trainUpdatesMessage.Tick != 1 || msgs[1].chunksMessage == nil || msgs[2].trainUpdatesMessage.Tick != 2 {
+		t.Error("train update jumped ahead of the chunks queued before it")
+	}
+}
+
+func TestSendQueueCoalescesTrackUpdatesAtTail(t *testing.T) {
+	q := newSendQueue()
+
+	q.push(trackUpdate(1, 1))
+	q.push(trackUpdate(2, 2))
+	q.push(chunks())
+	q.push(trackUpdate(3, 3))
+
+	msgs := drain(t, q)
+	if len(msgs) != 3 {
+		t.Fatalf("got %d messages, want 3", len(msgs))
+	}
+	first := msgs[0].trackUpdatesMessage
+	if first.Tick != 2 || len(first.Changes) != 2 {
+		t.Errorf("got tick %d with %d changes, want tick 2 with 2", first.Tick, len(first.Changes))
+	}
+	if msgs[2].trackUpdatesMessage.Changes[0].Pos.X != 3 {
+		t.Error("track update jumped ahead of the chunks queued before it")
+	}
+}
+
+func TestSendQueueShedsChatWhenFull(t *testing.T) {
+	q := newSendQueue()
+	for range sendQueueLimit {
+		if !q.push(chat("hi")) {
+			t.Fatal("disconnected while under the limit")
+		}
+	}
+
+	if !q.push(chunks()) {
+		t.Fatal("disconnected on the first overflow")
+	}
+	msgs := drain(t, q)
+	if len(msgs) != 1 || msgs[0].chunksMessage == nil {
+		t.Fatalf("got %d messages, want just the chunks", len(msgs))
+	}
+	if q.droppedCount() != sendQueueLimit {
+		t.Errorf("dropped %d, want %d", q.droppedCount(), sendQueueLimit)
+	}
+}
+
+func TestSendQueueRunsOverLimitDuringGrace(t *testing.T) {
+	q := newSendQueue()
+	for i := range sendQueueLimit + 10 {
+		if !q.push(trackUpdate(uint64(i), i)) {
+			t.Fatalf("disconnected after %d messages, inside the grace period", i)
+		}
+		// Stop them merging
+		q.push(chunks())
+	}
+
+	// Chat is still dropped while over the limit
+	if !q.push(chat("hi")) {
+		t.Fatal("disconnected for chat inside the grace period")
+	}
+	msgs := drain(t, q)
+	if len(msgs) != 2*(sendQueueLimit+10) {
+		t.Errorf("got %d messages, want %d", len(msgs), 2*(sendQueueLimit+10))
+	}
+	for _, msg := range msgs {
+		if msg.chatMessage != nil {
+			t.Fatal("chat was queued while over the limit")
+		}
+	}
+}
+
+func TestSendQueueDisconnectsAfterGrace(t *testing.T) {
+	q := newSendQueue()
+	for range sendQueueLimit {
+		q.push(chunks())
+	}
+	if !q.push(chunks()) {
+		t.Fatal("disconnected on the first overflow")
+	}
+
+	q.mu.Lock()
+	q.fullSince = time.Now().Add(-slowConsumerGrace - time.Second)
+	q.mu.Unlock()
+	if q.push(chunks()) {
+		t.Error("still connected after the grace period")
+	}
+}
+
+func TestSendQueueDisconnectsAtHardLimit(t *testing.T) {
+	q := newSendQueue()
+	for i := range sendQueueHardLimit {
+		if !q.push(chunks()) {
+			t.Fatalf("disconnected after %d messages, below the hard limit", i)
+		}
+	}
+	if q.push(chunks()) {
+		t.Error("still connected past the hard limit")
+	}
+}
+
+func TestSendQueueGraceResetsOnceDrained(t *testing.T) {
+	q := newSendQueue()
+	for range sendQueueLimit + 1 {
+		q.push(chunks())
+	}
+	drain(t, q)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.fullSince.IsZero() {
+		t.Error("grace period wasn't reset once the queue drained")
+	}
+}
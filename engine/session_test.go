@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/danharasymiw/bit-rail/message"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/danharasymiw/bit-rail/world/test_worlds"
+	"github.com/google/uuid"
+)
+
+func testConnection(playerID string) *playerConnection {
+	return &playerConnection{
+		playerID: playerID,
+		username: playerID,
+		features: message.Capabilities,
+		queue:    newSendQueue(),
+	}
+}
+
+func TestSessionResumesWithToken(t *testing.T) {
+	nm := newNetworkManager(nil)
+	first := testConnection("alice")
+	if err := nm.addPlayer(first, ""); err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+	if first.resumed || first.session.token == "" {
+		t.Fatal("first login should start a new session with a token")
+	}
+	nm.subscribe("alice", world.Pos{X: 1, Y: 2})
+	nm.disconnectPlayer(first)
+
+	second := testConnection("alice")
+	if err := nm.addPlayer(second, first.session.token); err != nil {
+		t.Fatalf("resuming: %v", err)
+	}
+	if !second.resumed || second.session != first.session {
+		t.Fatal("session wasn't resumed")
+	}
+	if _, ok := second.session.subscriptions[world.Pos{X: 1, Y: 2}]; !ok {
+		t.Error("subscriptions were lost")
+	}
+	if !second.session.disconnectedAt.IsZero() {
+		t.Error("resumed session still looks disconnected")
+	}
+}
+
+func TestSessionNotResumed(t *testing.T) {
+	nm := newNetworkManager(nil)
+	alice := testConnection("alice")
+	if err := nm.addPlayer(alice, ""); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	token := alice.session.token
+
+	// Another player can't take over the session with its token
+	bob := testConnection("bob")
+	if err := nm.addPlayer(bob, token); err != nil {
+		t.Fatalf("bob's login: %v", err)
+	}
+	if bob.resumed || bob.session == alice.session {
+		t.Error("bob picked up alice's session")
+	}
+
+	// Nor can a second login without the token while the first is connected
+	if err := nm.addPlayer(testConnection("alice"), ""); err == nil {
+		t.Error("second login without the token was let in")
+	}
+
+	// Sessions don't last forever once disconnected
+	nm.disconnectPlayer(alice)
+	alice.session.disconnectedAt = time.Now().Add(-sessionTimeout - time.Second)
+	late := testConnection("alice")
+	if err := nm.addPlayer(late, token); err != nil {
+		t.Fatalf("late login: %v", err)
+	}
+	if late.resumed {
+		t.Error("expired session was resumed")
+	}
+}
+
+// login runs a login through the engine as if it had come off the network
+func login(e *Engine, player *playerConnection, lastTick uint64) {
+	e.handleLoginMessage(playerMessage{
+		playerID: player.playerID,
+		player:   player,
+		message:  &incomingMessage{loginMessage: &message.LoginMessage{LastTick: lastTick}},
+	})
+}
+
+func TestResumeSendsMissedChanges(t *testing.T) {
+	e := New(test_worlds.NewBlock(), time.Millisecond)
+	first := testConnection("alice")
+	if err := e.nm.addPlayer(first, ""); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	login(e, first, 0)
+	if msgs := drain(t, first.queue); len(msgs) != 1 || msgs[0].initialLoadMessage == nil {
+		t.Fatalf("new session got %d messages, want just the initial load", len(msgs))
+	}
+	e.nm.disconnectPlayer(first)
+
+	// Track is laid while the player is away
+	lastTick := e.tickCount
+	e.tick()
+	pos := world.Pos{X: 30, Y: 30}
+	e.w.AddTrack(pos, &types.Track{Direction: types.DirNorth | types.DirSouth})
+	e.trackChanged(pos)
+
+	second := testConnection("alice")
+	if err := e.nm.addPlayer(second, first.session.token); err != nil {
+		t.Fatalf("resuming: %v", err)
+	}
+	login(e, second, lastTick)
+
+	var changes []message.TileChange
+	trainsSeen := map[uuid.UUID]bool{}
+	for _, msg := range drain(t, second.queue) {
+		switch {
+		case msg.initialLoadMessage != nil:
+			t.Fatal("resumed session was sent the whole world")
+		case msg.trackUpdatesMessage != nil:
+			changes = append(changes, msg.trackUpdatesMessage.Changes...)
+		case msg.trainUpdatesMessage != nil:
+			for _, state := range msg.trainUpdatesMessage.Trains {
+				trainsSeen[state.ID] = true
+			}
+		}
+	}
+	if len(changes) != 1 || changes[0].Pos != pos {
+		t.Errorf("got changes %+v, want only the track laid at %v", changes, pos)
+	}
+	if len(trainsSeen) != len(e.w.Trains) {
+		t.Errorf("got %d trains, want every one of the %d", len(trainsSeen), len(e.w.Trains))
+	}
+}
+
+func TestResumeTooLateReloads(t *testing.T) {
+	e := New(test_worlds.NewBlock(), time.Millisecond)
+	player := testConnection("alice")
+	if err := e.nm.addPlayer(player, ""); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	player.resumed = true
+
+	// The history only goes back historyTicks, so the change at tick 2 is
+	// forgotten and the player can't be told about it
+	e.history.record(2, nil)
+	e.tickCount = 3 * historyTicks
+	e.history.record(e.tickCount, nil)
+	login(e, player, 1)
+
+	msgs := drain(t, player.queue)
+	if len(msgs) != 1 || msgs[0].initialLoadMessage == nil {
+		t.Fatalf("got %d messages, want just the initial load", len(msgs))
+	}
+}
+
+func TestChangeLogSince(t *testing.T) {
+	l := newChangeLog(0)
+	for tick := uint64(1); tick <= 3; tick++ {
+		l.record(tick, []message.TileChange{{Pos: world.Pos{X: int(tick)}}})
+	}
+
+	changes, ok := l.since(2)
+	if !ok || len(changes) != 2 || changes[0].Pos.X != 2 || changes[1].Pos.X != 3 {
+		t.Errorf("got %+v, %v, want the changes from ticks 2 and 3", changes, ok)
+	}
+
+	l.record(historyTicks+10, nil)
+	if _, ok := l.since(3); ok {
+		t.Error("log claims to go back further than it keeps")
+	}
+	if _, ok := l.since(10); !ok {
+		t.Error("log lost changes it should still have")
+	}
+}
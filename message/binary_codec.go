@@ -0,0 +1,345 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/gorilla/websocket"
+)
+
+// BinaryCodec packs messages into a compact binary form. A frame is the
+// message type byte followed by the body, which is encoded field by field in
+// declaration order:
+//   - signed integers as zigzag varints, unsigned integers as varints
+//   - strings, slices and maps with a varint length prefix
+//   - pointers with a presence byte
+//   - tile slices as one byte per tile
+//
+// Fields tagged `json:"-"` are skipped, just like with JSONCodec
+type BinaryCodec struct{}
+
+var errShortFrame = errors.New("binary frame ended early")
+
+var tileSliceType = reflect.TypeOf([]*types.Tile(nil))
+
+func (BinaryCodec) Encode(msgType MessageType, body any) ([]byte, error) {
+	// Bodies are decoded into a pointer, so encode what the pointer points at
+	rv := reflect.ValueOf(body)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("binary codec can't encode a nil %T", body)
+		}
+		rv = rv.Elem()
+	}
+
+	buf := []byte{byte(msgType)}
+	return appendValue(buf, rv)
+}
+
+func (BinaryCodec) Decode(frame []byte) (MessageType, []byte, error) {
+	if len(frame) == 0 {
+		return 0, nil, errShortFrame
+	}
+	return MessageType(frame[0]), frame[1:], nil
+}
+
+func (BinaryCodec) DecodeBody(body []byte, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("binary codec needs a non-nil pointer, got %T", v)
+	}
+	d := decoder{buf: body}
+	if err := d.readValue(rv.Elem()); err != nil {
+		return err
+	}
+	if len(d.buf) != 0 {
+		return fmt.Errorf("%d unexpected bytes after %T", len(d.buf), v)
+	}
+	return nil
+}
+
+func (BinaryCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func skipField(f reflect.StructField) bool {
+	return !f.IsExported() || f.Tag.Get("json") == "-"
+}
+
+func appendValue(buf []byte, v reflect.Value) ([]byte, error) {
+	if v.Type() == tileSliceType {
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			var tileType types.TileType
+			if tile := v.Index(i).Interface().(*types.Tile); tile != nil {
+				tileType = tile.Type
+			}
+			buf = append(buf, byte(tileType))
+		}
+		return buf, nil
+	}
+
+	var err error
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return binary.AppendUvarint(buf, v.Uint()), nil
+	case reflect.Uint8:
+		return append(buf, byte(v.Uint())), nil
+	case reflect.Float64, reflect.Float32:
+		return binary.LittleEndian.AppendUint64(buf, math.Float64bits(v.Float())), nil
+	case reflect.String:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		return append(buf, v.String()...), nil
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if buf, err = appendValue(buf, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			buf = binary.AppendUvarint(buf, uint64(v.Len()))
+			return append(buf, v.Bytes()...), nil
+		}
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if buf, err = appendValue(buf, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Map:
+		buf = binary.AppendUvarint(buf, uint64(v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			if buf, err = appendValue(buf, iter.Key()); err != nil {
+				return nil, err
+			}
+			if buf, err = appendValue(buf, iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case reflect.Pointer:
+		if v.IsNil() {
+			return append(buf, 0), nil
+		}
+		return appendValue(append(buf, 1), v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if skipField(v.Type().Field(i)) {
+				continue
+			}
+			if buf, err = appendValue(buf, v.Field(i)); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("binary codec can't encode %s", v.Type())
+	}
+}
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if len(d.buf) == 0 {
+		return 0, errShortFrame
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b, nil
+}
+
+func (d *decoder) readUvarint() (uint64, error) {
+	n, size := binary.Uvarint(d.buf)
+	if size <= 0 {
+		return 0, errShortFrame
+	}
+	d.buf = d.buf[size:]
+	return n, nil
+}
+
+func (d *decoder) readVarint() (int64, error) {
+	n, size := binary.Varint(d.buf)
+	if size <= 0 {
+		return 0, errShortFrame
+	}
+	d.buf = d.buf[size:]
+	return n, nil
+}
+
+// readLen reads a length prefix, refusing lengths that can't possibly fit in
+// what is left of the frame so a bad frame can't make us allocate huge slices
+func (d *decoder) readLen() (int, error) {
+	n, err := d.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.buf)) {
+		return 0, errShortFrame
+	}
+	return int(n), nil
+}
+
+func (d *decoder) readBytes(n int) ([]byte, error) {
+	if n > len(d.buf) {
+		return nil, errShortFrame
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *decoder) readValue(v reflect.Value) error {
+	if v.Type() == tileSliceType {
+		n, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		packed, err := d.readBytes(n)
+		if err != nil {
+			return err
+		}
+		tiles := make([]types.Tile, n)
+		tilePtrs := make([]*types.Tile, n)
+		for i, b := range packed {
+			tiles[i].Type = types.TileType(b)
+			tilePtrs[i] = &tiles[i]
+		}
+		v.Set(reflect.ValueOf(tilePtrs))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := d.readVarint()
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := d.readUvarint()
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Uint8:
+		b, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		v.SetUint(uint64(b))
+	case reflect.Float64, reflect.Float32:
+		b, err := d.readBytes(8)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	case reflect.String:
+		n, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		b, err := d.readBytes(n)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := d.readValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		n, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		// Nil and empty slices encode the same way, decode both as nil
+		if n == 0 {
+			v.SetZero()
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := d.readBytes(n)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(append([]byte(nil), b...))
+			return nil
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := d.readValue(s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Map:
+		n, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.readValue(key); err != nil {
+				return err
+			}
+			val := reflect.New(v.Type().Elem()).Elem()
+			if err := d.readValue(val); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, val)
+		}
+		v.Set(m)
+	case reflect.Pointer:
+		present, err := d.readByte()
+		if err != nil {
+			return err
+		}
+		if present == 0 {
+			v.SetZero()
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := d.readValue(elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if skipField(v.Type().Field(i)) {
+				continue
+			}
+			if err := d.readValue(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("binary codec can't decode %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package message
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+)
+
+// Codec turns message bodies into websocket frames and back. Both ends of a
+// connection must use the same codec
+type Codec interface {
+	// Encode wraps a message body of the given type into a frame
+	Encode(msgType MessageType, body any) ([]byte, error)
+	// Decode splits a frame into its message type and a body for DecodeBody
+	Decode(frame []byte) (MessageType, []byte, error)
+	// DecodeBody reads a body returned by Decode into v
+	DecodeBody(body []byte, v any) error
+	// FrameType is the websocket message type frames are sent as
+	FrameType() int
+}
+
+// CodecForFrameType picks the codec matching a websocket frame type so the
+// server can answer clients in whichever codec they logged in with
+func CodecForFrameType(frameType int) Codec {
+	if frameType == websocket.BinaryMessage {
+		return BinaryCodec{}
+	}
+	return JSONCodec{}
+}
+
+// JSONCodec sends messages as a JSON Message envelope with a JSON encoded
+// body. It is much larger than BinaryCodec but easy to read when debugging
+type JSONCodec struct{}
+
+func (JSONCodec) Encode(msgType MessageType, body any) ([]byte, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(Message{Type: msgType, Data: data})
+}
+
+func (JSONCodec) Decode(frame []byte) (MessageType, []byte, error) {
+	var msg Message
+	if err := json.Unmarshal(frame, &msg); err != nil {
+		return 0, nil, err
+	}
+	return msg.Type, msg.Data, nil
+}
+
+func (JSONCodec) DecodeBody(body []byte, v any) error {
+	return json.Unmarshal(body, v)
+}
+
+func (JSONCodec) FrameType() int {
+	return websocket.TextMessage
+}
@@ -0,0 +1,185 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+	"github.com/google/uuid"
+)
+
+func testChunk() *world.Chunk {
+	w := world.New(world.ChunkSize, world.ChunkSize)
+	w.Tiles[3][4].Type = types.TileWater
+	w.AddTrack(world.Pos{X: 1, Y: 2}, &types.Track{
+		Direction: types.DirEast | types.DirWest,
+		HasSignal: true,
+		SignalDir: types.DirEast,
+		Block:     &types.Block{ID: types.BlockID(uuid.New())},
+	})
+	w.AddTrain(&trains.Train{
+		ID:          uuid.New(),
+		IsMoving:    true,
+		Speed:       250,
+		Destination: &trains.Destination{X: 9, Y: 9},
+		Cars:        []*trains.TrainCar{{X: 1, Y: 2, Direction: types.DirEast, Type: trains.CarTypeLocomotive}},
+	})
+	return w.ChunkAt(world.Pos{})
+}
+
+// testMessages has a body for every message type
+func testMessages() map[MessageType]any {
+	pos := world.Pos{X: 12, Y: -3}
+	chunk := testChunk()
+	track := &types.Track{Direction: types.DirNorth | types.DirSouth}
+
+	return map[MessageType]any{
+		MessageTypeChat:      &ChatMessage{Author: "alice", Message: "hello ✓"},
+		MessageTypeChunks:    &ChunksMessage{Chunks: []*world.Chunk{chunk}},
+		MessageTypeGetChunks: &GetChunksMessage{Positions: []world.Pos{pos, {X: 1, Y: 1}}},
+		MessageTypeInitialLoad: &InitialLoadMessage{
+			Tick:      1 << 40,
+			Width:     640,
+			Height:    480,
+			CameraPos: pos,
+			Chunks:    []*world.Chunk{chunk},
+		},
+		MessageTypeLogin: &LoginMessage{
+			ProtocolVersion: ProtocolVersion,
+			Username:        "alice",
+			Password:        "correct horse",
+			Register:        true,
+			Capabilities:    Capabilities,
+			SessionToken:    "token",
+			LastTick:        42,
+		},
+		MessageTypeTrainUpdates: &TrainUpdatesMessage{
+			Tick: 7,
+			Trains: []TrainState{{
+				ID:           uuid.New(),
+				IsMoving:     true,
+				IsReversing:  true,
+				Speed:        -5,
+				Orders:       []trains.Order{{Type: trains.OrderGoTo, X: 3, Y: 4}, {Type: trains.OrderWait, Ticks: 60}},
+				CurrentOrder: 1,
+				RepeatOrders: true,
+				WaitTicks:    30,
+				Blocked:      true,
+				Cars:         []CarState{{X: 1, Y: 2, Direction: types.DirWest, Type: trains.CarTypeCargo}},
+			}},
+		},
+		MessageTypeBuildTrack:  &BuildTrackMessage{Pos: pos, Track: *track},
+		MessageTypeRemoveTrack: &RemoveTrackMessage{Pos: pos},
+		MessageTypeTrackUpdates: &TrackUpdatesMessage{
+			Tick: 9,
+			Changes: []TileChange{
+				{Pos: pos, Tile: types.Tile{Type: types.TileTrack}, Track: track},
+				{Pos: world.Pos{X: 2}, Tile: types.Tile{Type: types.TileGrass}},
+			},
+		},
+		MessageTypeBuildRejected:  &BuildRejectedMessage{Pos: pos, Reason: "a train is in the way"},
+		MessageTypeBuildTrackPath: &BuildTrackPathMessage{Path: []world.Pos{{X: 1, Y: 1}, {X: 2, Y: 1}}},
+		MessageTypeLoginResult: &LoginResultMessage{
+			Accepted:      true,
+			ServerVersion: ProtocolVersion,
+			Reason:        "welcome",
+			Features:      []string{CapabilityTrainUpdates},
+			SessionToken:  "token",
+			PlayerID:      uuid.NewString(),
+		},
+		MessageTypeUnsubscribeChunks: &UnsubscribeChunksMessage{Positions: []world.Pos{pos}},
+		MessageTypeMoveCamera:        &MoveCameraMessage{Pos: pos},
+		MessageTypeInspectTile:       &InspectTileMessage{Pos: pos},
+		MessageTypeTileInfo:          &TileInfoMessage{Pos: pos, BlockID: uuid.NewString(), OccupiedBy: uuid.NewString()},
+		MessageTypeGetBlocks:         &GetBlocksMessage{Min: pos, Max: world.Pos{X: 40, Y: 30}},
+		MessageTypeBlocks: &BlocksMessage{
+			Min: pos,
+			Max: world.Pos{X: 40, Y: 30},
+			Blocks: []BlockState{
+				{ID: uuid.NewString(), OccupiedBy: uuid.NewString(), Reserved: true, Tiles: []world.Pos{pos}},
+				{ID: uuid.NewString(), Tiles: []world.Pos{{X: 1}, {X: 2}}},
+			},
+		},
+		MessageTypeBlockUpdates: &BlockUpdatesMessage{
+			Tick:   11,
+			Blocks: []BlockState{{ID: uuid.NewString(), OccupiedBy: uuid.NewString()}},
+		},
+	}
+}
+
+func TestCodecsRoundTripEveryMessage(t *testing.T) {
+	msgs := testMessages()
+	for msgType := MessageTypeChat; msgType <= MessageTypeBlockUpdates; msgType++ {
+		if _, ok := msgs[msgType]; !ok {
+			t.Errorf("no test message for type %d", msgType)
+		}
+	}
+
+	for _, codec := range []Codec{BinaryCodec{}, JSONCodec{}} {
+		for msgType, body := range msgs {
+			frame, err := codec.Encode(msgType, body)
+			if err != nil {
+				t.Fatalf("%T encoding %T: %v", codec, body, err)
+			}
+			gotType, gotBody, err := codec.Decode(frame)
+			if err != nil {
+				t.Fatalf("%T decoding %T: %v", codec, body, err)
+			}
+			if gotType != msgType {
+				t.Errorf("%T: %T came back as type %d, want %d", codec, body, gotType, msgType)
+			}
+
+			decoded := reflect.New(reflect.TypeOf(body).Elem())
+			if err := codec.DecodeBody(gotBody, decoded.Interface()); err != nil {
+				t.Fatalf("%T decoding body of %T: %v", codec, body, err)
+			}
+			if !reflect.DeepEqual(decoded.Interface(), body) {
+				t.Errorf("%T: %T didn't survive the round trip\ngot  %+v\nwant %+v", codec, body, decoded.Interface(), body)
+			}
+		}
+	}
+}
+
+func TestBinaryCodecSkipsServerOnlyFields(t *testing.T) {
+	msg := &TrainUpdatesMessage{Trains: []TrainState{{ID: uuid.New(), Chunks: []world.Pos{{X: 1}}}}}
+	frame, err := BinaryCodec{}.Encode(MessageTypeTrainUpdates, msg)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	var decoded TrainUpdatesMessage
+	if err := (BinaryCodec{}).DecodeBody(frame[1:], &decoded); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if decoded.Trains[0].Chunks != nil {
+		t.Error("chunks were sent to the client")
+	}
+}
+
+func TestBinaryCodecRejectsTruncatedFrames(t *testing.T) {
+	for msgType, body := range testMessages() {
+		frame, err := BinaryCodec{}.Encode(msgType, body)
+		if err != nil {
+			t.Fatalf("encoding %T: %v", body, err)
+		}
+		decoded := reflect.New(reflect.TypeOf(body).Elem())
+		for n := 1; n < len(frame); n++ {
+			if err := (BinaryCodec{}).DecodeBody(frame[1:n], decoded.Interface()); err == nil {
+				t.Fatalf("%T cut to %d of %d bytes decoded without an error", body, n, len(frame))
+			}
+		}
+		// Trailing bytes are an error too
+		if err := (BinaryCodec{}).DecodeBody(append(frame[1:], 0), decoded.Interface()); err == nil {
+			t.Errorf("%T with a trailing byte decoded without an error", body)
+		}
+	}
+}
+
+func TestCodecForFrameType(t *testing.T) {
+	for _, codec := range []Codec{BinaryCodec{}, JSONCodec{}} {
+		if got := CodecForFrameType(codec.FrameType()); got != codec {
+			t.Errorf("%T frames picked %T", codec, got)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/danharasymiw/bit-rail/trains"
 	"github.com/danharasymiw/bit-rail/types"
 	"github.com/danharasymiw/bit-rail/world"
+	"github.com/google/uuid"
 )
 
 type MessageType uint8
@@ -14,6 +15,20 @@ const (
 	MessageTypeInitialLoad
 	MessageTypeLogin
 	MessageTypeGetChunks
+	MessageTypeTrainUpdates
+	MessageTypeBuildTrack
+	MessageTypeRemoveTrack
+	MessageTypeTrackUpdates
+	MessageTypeBuildRejected
+	MessageTypeBuildTrackPath
+	MessageTypeLoginResult
+	MessageTypeUnsubscribeChunks
+	MessageTypeMoveCamera
+	MessageTypeInspectTile
+	MessageTypeTileInfo
+	MessageTypeGetBlocks
+	MessageTypeBlocks
+	MessageTypeBlockUpdates
 )
 
 type Message struct {
@@ -21,7 +36,12 @@ type Message struct {
 	Data []byte
 }
 
+// MaxChatLength is the longest chat message in runes, the server cuts off
+// anything longer
+const MaxChatLength = 256
+
 type ChatMessage struct {
+	// Author is filled in by the server, whatever the client sends is ignored
 	Author  string
 	Message string
 }
@@ -30,17 +50,197 @@ type ChunksMessage struct {
 	Chunks []*world.Chunk
 }
 
+// GetChunksMessage requests chunks and subscribes to updates inside them
 type GetChunksMessage struct {
 	Positions []world.Pos
 }
+
+// UnsubscribeChunksMessage stops updates for chunks the client no longer needs
+type UnsubscribeChunksMessage struct {
+	Positions []world.Pos
+}
+
+// MoveCameraMessage tells the client to centre its view on Pos
+type MoveCameraMessage struct {
+	Pos world.Pos
+}
+
+// InspectTileMessage asks the server for the details of a tile that clients
+// aren't normally sent
+type InspectTileMessage struct {
+	Pos world.Pos
+}
+
+// TileInfoMessage answers an InspectTileMessage. BlockID is empty if the tile
+// has no block and OccupiedBy is empty if nothing holds the block
+type TileInfoMessage struct {
+	Pos        world.Pos
+	BlockID    string
+	OccupiedBy string
+}
+
+// MaxBlocksSpan is the widest and tallest area a GetBlocksMessage can ask
+// about, the server cuts anything bigger down to this
+const MaxBlocksSpan = 512
+
+// GetBlocksMessage asks the server which block each track from Min to Max,
+// inclusive, is in and who holds those blocks
+type GetBlocksMessage struct {
+	Min world.Pos
+	Max world.Pos
+}
+
+// BlocksMessage answers a GetBlocksMessage
+type BlocksMessage struct {
+	Min    world.Pos
+	Max    world.Pos
+	Blocks []BlockState
+}
+
+// BlockState is a block of track and who holds it. OccupiedBy is empty if the
+// block is free, Reserved is set if the train holding it has no cars in it
+type BlockState struct {
+	ID         string
+	OccupiedBy string
+	Reserved   bool
+	// Tiles are the block's tracks inside the area asked about, they're left
+	// out of updates
+	Tiles []world.Pos
+
+	// Chunks are the chunks the block has track in. The server uses them to
+	// pick who gets an update, they aren't sent
+	Chunks []world.Pos `json:"-"`
+}
+
+// BlockUpdatesMessage tells clients about blocks that were claimed or released
+// during a tick
+type BlockUpdatesMessage struct {
+	Tick   uint64
+	Blocks []BlockState
+}
+
+// LoginMessage is the first message a client sends. ProtocolVersion stays the
+// first field so a server can always tell which version it is talking to
 type LoginMessage struct {
-	Username string
+	ProtocolVersion int
+	Username        string
+	Password        string
+	// Register creates the account first if there isn't one with this name
+	Register     bool
+	Capabilities []string
+
+	// SessionToken is set when reconnecting, to pick up the old session
+	SessionToken string
+	// LastTick is the last tick the client has all updates for
+	LastTick uint64
+}
+
+// LoginResultMessage answers a login, telling the client whether it was
+// accepted and what the server supports
+type LoginResultMessage struct {
+	Accepted      bool
+	ServerVersion int
+	Reason        string
+	// Features are the capabilities enabled for this connection
+	Features []string
+	// SessionToken lets the client resume this session if it reconnects
+	SessionToken string
+	// PlayerID is the account's stable ID, it stays the same if the player
+	// is renamed
+	PlayerID string
 }
 
 type InitialLoadMessage struct {
+	Tick          uint64
 	Width, Height int
 	CameraPos     world.Pos
 	Chunks        []*world.Chunk
-	Trains        []*trains.Train
-	Tracks        map[world.Pos]*types.Track
+}
+
+// BuildTrackMessage asks the server to lay or replace the track at Pos
+type BuildTrackMessage struct {
+	Pos   world.Pos
+	Track types.Track
+}
+
+// MaxTrackPathLength is the most tiles a BuildTrackPathMessage can lay, the
+// server rejects longer paths
+const MaxTrackPathLength = 256
+
+// BuildTrackPathMessage asks the server to lay track along a path of adjacent
+// tiles, working out the track directions itself
+type BuildTrackPathMessage struct {
+	Path []world.Pos
+}
+
+type RemoveTrackMessage struct {
+	Pos world.Pos
+}
+
+// TrackUpdatesMessage tells clients about tiles whose track has changed
+type TrackUpdatesMessage struct {
+	Tick    uint64
+	Changes []TileChange
+}
+
+// TileChange is the new state of a tile, Track is nil if the tile has no track
+type TileChange struct {
+	Pos   world.Pos
+	Tile  types.Tile
+	Track *types.Track
+}
+
+// BuildRejectedMessage is sent back to a player whose construction request
+// failed validation
+type BuildRejectedMessage struct {
+	Pos    world.Pos
+	Reason string
+}
+
+// TrainUpdatesMessage carries the state of every train that changed during a tick
+type TrainUpdatesMessage struct {
+	Tick   uint64
+	Trains []TrainState
+}
+
+type TrainState struct {
+	ID           uuid.UUID
+	IsMoving     bool
+	IsReversing  bool
+	Speed        int
+	Orders       []trains.Order
+	CurrentOrder int
+	RepeatOrders bool
+	WaitTicks    int
+	Blocked      bool
+	Cars         []CarState
+
+	// Chunks are the chunks the train was in before and after the tick. The
+	// server uses them to pick who gets the update, they aren't sent
+	Chunks []world.Pos `json:"-"`
+}
+
+type CarState struct {
+	X, Y      int
+	Direction types.Dir
+	Type      trains.CarType
+}
+
+func NewTrainState(t *trains.Train) TrainState {
+	cars := make([]CarState, len(t.Cars))
+	for i, c := range t.Cars {
+		cars[i] = CarState{X: c.X, Y: c.Y, Direction: c.Direction, Type: c.Type}
+	}
+	return TrainState{
+		ID:           t.ID,
+		IsMoving:     t.IsMoving,
+		IsReversing:  t.IsReversing,
+		Speed:        t.Speed,
+		Orders:       append([]trains.Order(nil), t.Orders...),
+		CurrentOrder: t.CurrentOrder,
+		RepeatOrders: t.RepeatOrders,
+		WaitTicks:    t.WaitTicks,
+		Blocked:      t.Blocked,
+		Cars:         cars,
+	}
 }
@@ -0,0 +1,46 @@
+package message
+
+// ProtocolVersion is bumped whenever a message changes in a way older builds
+// can't read. Clients and servers only talk to the same version
+const ProtocolVersion = 2
+
+// Capabilities are optional features a build supports. The client sends its
+// list when logging in and the server answers with the ones both sides have
+const (
+	CapabilityTrainUpdates  = "train-updates"
+	CapabilityTrainOrders   = "train-orders"
+	CapabilityTrackBuilding = "track-building"
+	CapabilitySessionResume = "session-resume"
+	// CapabilityMoveCamera lets the server move the client's view, as /tp does
+	CapabilityMoveCamera = "move-camera"
+	// CapabilityTileInspect is InspectTileMessage and its TileInfoMessage
+	CapabilityTileInspect = "tile-inspect"
+	// CapabilityBlockStates is GetBlocksMessage, its BlocksMessage and the
+	// BlockUpdatesMessages that follow
+	CapabilityBlockStates = "block-states"
+)
+
+// Capabilities lists every capability this build supports
+var Capabilities = []string{
+	CapabilityTrainUpdates,
+	CapabilityTrainOrders,
+	CapabilityTrackBuilding,
+	CapabilitySessionResume,
+	CapabilityMoveCamera,
+	CapabilityTileInspect,
+	CapabilityBlockStates,
+}
+
+// SharedCapabilities returns the capabilities in theirs that this build also supports
+func SharedCapabilities(theirs []string) []string {
+	shared := make([]string, 0, len(theirs))
+	for _, c := range theirs {
+		for _, ours := range Capabilities {
+			if c == ours {
+				shared = append(shared, c)
+				break
+			}
+		}
+	}
+	return shared
+}
@@ -0,0 +1,24 @@
+package message
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSharedCapabilities(t *testing.T) {
+	tests := []struct {
+		name   string
+		theirs []string
+		want   []string
+	}{
+		{"nothing", nil, []string{}},
+		{"everything", Capabilities, Capabilities},
+		{"unknown dropped", []string{"teleporters", CapabilityTrackBuilding}, []string{CapabilityTrackBuilding}},
+		{"their order kept", []string{CapabilityTrackBuilding, CapabilityTrainUpdates}, []string{CapabilityTrackBuilding, CapabilityTrainUpdates}},
+	}
+	for _, tt := range tests {
+		if got := SharedCapabilities(tt.theirs); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package trains
+
+import "fmt"
+
+type OrderType uint8
+
+const (
+	// OrderGoTo routes the train to X, Y
+	OrderGoTo OrderType = iota
+	// OrderWait holds the train in place for Ticks ticks
+	OrderWait
+	// OrderReverse flips the train's direction of travel
+	OrderReverse
+)
+
+type Order struct {
+	Type  OrderType
+	X, Y  int
+	Ticks int
+}
+
+func (o OrderType) String() string {
+	switch o {
+	case OrderGoTo:
+		return "Go to"
+	case OrderWait:
+		return "Wait"
+	case OrderReverse:
+		return "Reverse"
+	default:
+		return "Unknown"
+	}
+}
+
+// String describes the order along with where it goes or how long it waits
+func (o Order) String() string {
+	switch o.Type {
+	case OrderGoTo:
+		return fmt.Sprintf("%s %d,%d", o.Type, o.X, o.Y)
+	case OrderWait:
+		return fmt.Sprintf("%s %d ticks", o.Type, o.Ticks)
+	default:
+		return o.Type.String()
+	}
+}
+
+// Order returns the order the train is currently working on, nil if it has
+// run out of orders
+func (t *Train) Order() *Order {
+	if t.CurrentOrder < 0 || t.CurrentOrder >= len(t.Orders) {
+		return nil
+	}
+	return &t.Orders[t.CurrentOrder]
+}
+
+// NextOrder moves on to the following order, wrapping around if the train
+// repeats its orders. A train that has finished its orders stops
+func (t *Train) NextOrder() {
+	t.CurrentOrder++
+	if t.CurrentOrder >= len(t.Orders) {
+		if !t.RepeatOrders {
+			t.IsMoving = false
+			return
+		}
+		t.CurrentOrder = 0
+	}
+	t.WaitTicks = 0
+	t.WaitStarted = false
+}
@@ -0,0 +1,78 @@
+package trains
+
+import (
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/types"
+)
+
+func TestNextOrder(t *testing.T) {
+	orders := []Order{
+		{Type: OrderGoTo, X: 1, Y: 2},
+		{Type: OrderWait, Ticks: 10},
+		{Type: OrderReverse},
+	}
+
+	train := &Train{IsMoving: true, Orders: orders}
+	if order := train.Order(); order == nil || order.Type != OrderGoTo {
+		t.Fatalf("got %+v, want the first order", order)
+	}
+	train.NextOrder()
+	if train.CurrentOrder != 1 || train.WaitStarted {
+		t.Errorf("wait order: got order %d started %v, want order 1 not started until the train gets to it", train.CurrentOrder, train.WaitStarted)
+	}
+	train.WaitTicks, train.WaitStarted = 4, true
+	train.NextOrder()
+	if train.WaitTicks != 0 || train.WaitStarted {
+		t.Errorf("still waiting %d ticks after the wait order", train.WaitTicks)
+	}
+	train.NextOrder()
+	if train.Order() != nil || train.IsMoving {
+		t.Error("train without repeating orders should stop once they run out")
+	}
+
+	repeating := &Train{IsMoving: true, Orders: orders, CurrentOrder: 2, RepeatOrders: true}
+	repeating.NextOrder()
+	if repeating.CurrentOrder != 0 || !repeating.IsMoving {
+		t.Errorf("repeating orders: got order %d, want them to start over", repeating.CurrentOrder)
+	}
+
+	if (&Train{}).Order() != nil {
+		t.Error("train without orders has an order")
+	}
+}
+
+func TestTravelDir(t *testing.T) {
+	train := &Train{Cars: []*TrainCar{
+		{X: 2, Direction: types.DirEast},
+		{X: 1, Direction: types.DirEast},
+	}}
+	if train.Lead() != train.Cars[0] || train.TravelDir() != types.DirEast {
+		t.Errorf("forwards: lead %+v heading %s, want the first car heading east", *train.Lead(), train.TravelDir())
+	}
+
+	train.IsReversing = true
+	if train.Lead() != train.Cars[1] || train.TravelDir() != types.DirWest {
+		t.Errorf("reversing: lead %+v heading %s, want the last car heading west", *train.Lead(), train.TravelDir())
+	}
+	train.SetTravelDir(types.DirNorth)
+	if train.Cars[1].Direction != types.DirSouth || train.TravelDir() != types.DirNorth {
+		t.Error("reversing train should point its last car backwards")
+	}
+}
+
+func TestOrderString(t *testing.T) {
+	for _, tt := range []struct {
+		order Order
+		want  string
+	}{
+		{Order{Type: OrderGoTo, X: 1, Y: 2}, "Go to 1,2"},
+		{Order{Type: OrderWait, Ticks: 10}, "Wait 10 ticks"},
+		{Order{Type: OrderReverse}, "Reverse"},
+		{Order{Type: OrderReverse + 1}, "Unknown"},
+	} {
+		if got := tt.order.String(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package trains
+
+// ProgressPerTile is how much progress a train needs to build up before it
+// moves on to the next tile. Speeds are measured in progress per tick
+const ProgressPerTile = 1000
+
+type carStats struct {
+	mass     int
+	power    int
+	topSpeed int
+}
+
+var carTypeStats = map[CarType]carStats{
+	CarTypeLocomotive: {mass: 8, power: 40, topSpeed: ProgressPerTile},
+	CarTypeCargo:      {mass: 4},
+	CarTypePassenger:  {mass: 3},
+}
+
+func (t *Train) stats() (mass, power, topSpeed int) {
+	for _, c := range t.Cars {
+		s := carTypeStats[c.Type]
+		mass += s.mass
+		power += s.power
+		if s.topSpeed > 0 && (topSpeed == 0 || s.topSpeed < topSpeed) {
+			topSpeed = s.topSpeed
+		}
+	}
+	if mass == 0 {
+		mass = 1
+	}
+	return mass, power, topSpeed
+}
+
+// MaxSpeed is the fastest the train can go, heavier trains can't reach the
+// locomotive's top speed
+func (t *Train) MaxSpeed() int {
+	mass, power, topSpeed := t.stats()
+	return min(topSpeed, power*1000/mass)
+}
+
+// AccelerationRate is how much speed the train gains each tick under power
+func (t *Train) AccelerationRate() int {
+	mass, power, _ := t.stats()
+	return max(power*25/mass, 1)
+}
+
+// BrakingRate is how much speed the train loses each tick when braking
+func (t *Train) BrakingRate() int {
+	mass, _, _ := t.stats()
+	return max(2000/mass, 1)
+}
+
+// StoppingDistance is how much progress the train covers while braking to a
+// stand from speed
+func (t *Train) StoppingDistance(speed int) int {
+	b := t.BrakingRate()
+	n := speed / b
+	return n*speed - b*n*(n+1)/2
+}
@@ -0,0 +1,64 @@
+package trains
+
+import "testing"
+
+func testTrain(carTypes ...CarType) *Train {
+	t := &Train{}
+	for _, carType := range carTypes {
+		t.Cars = append(t.Cars, &TrainCar{Type: carType})
+	}
+	return t
+}
+
+// brake runs the train's brakes the way the engine does, one rate a tick
+// until it stops, and returns how far it went
+func brake(t *Train, speed int) int {
+	covered := 0
+	for speed > 0 {
+		speed = max(speed-t.BrakingRate(), 0)
+		covered += speed
+	}
+	return covered
+}
+
+func TestStoppingDistanceMatchesBraking(t *testing.T) {
+	for _, train := range []*Train{
+		testTrain(CarTypeLocomotive),
+		testTrain(CarTypeLocomotive, CarTypeCargo, CarTypeCargo),
+		testTrain(CarTypeLocomotive, CarTypePassenger, CarTypeCargo, CarTypeCargo, CarTypeCargo),
+	} {
+		for speed := 0; speed <= train.MaxSpeed(); speed++ {
+			if got, want := train.StoppingDistance(speed), brake(train, speed); got != want {
+				t.Fatalf("%d cars at speed %d: stopping distance %d, braking covers %d", len(train.Cars), speed, got, want)
+			}
+		}
+	}
+}
+
+func TestHeavierTrainsAreSlower(t *testing.T) {
+	light := testTrain(CarTypeLocomotive)
+	heavy := testTrain(CarTypeLocomotive)
+	for range 10 {
+		heavy.Cars = append(heavy.Cars, &TrainCar{Type: CarTypeCargo})
+	}
+
+	if light.MaxSpeed() != ProgressPerTile {
+		t.Errorf("lone locomotive max speed %d, want its top speed %d", light.MaxSpeed(), ProgressPerTile)
+	}
+	if heavy.MaxSpeed() >= light.MaxSpeed() {
+		t.Errorf("heavy max speed %d isn't below light %d", heavy.MaxSpeed(), light.MaxSpeed())
+	}
+	if heavy.AccelerationRate() >= light.AccelerationRate() {
+		t.Errorf("heavy acceleration %d isn't below light %d", heavy.AccelerationRate(), light.AccelerationRate())
+	}
+	if heavy.StoppingDistance(heavy.MaxSpeed()) <= heavy.StoppingDistance(heavy.MaxSpeed()/2) {
+		t.Error("stopping distance doesn't grow with speed")
+	}
+}
+
+func TestCarsWithoutPowerDontMove(t *testing.T) {
+	cars := testTrain(CarTypeCargo, CarTypeCargo)
+	if cars.MaxSpeed() != 0 {
+		t.Errorf("max speed %d without a locomotive, want 0", cars.MaxSpeed())
+	}
+}
@@ -6,15 +6,83 @@ import (
 )
 
 type Train struct {
-	ID           uuid.UUID
-	IsReversing  bool
-	IsMoving     bool
-	Speed        int
+	ID          uuid.UUID
+	IsReversing bool
+	IsMoving    bool
+	// Speed is in progress per tick, see ProgressPerTile
+	Speed int
+	// Acceleration is the change in speed applied on the last tick, negative
+	// while braking
 	Acceleration int
+	// Progress is how far the train is towards its next tile
+	Progress int
+
+	// Destination is where the train is routing to, nil if it is just
+	// following the track
+	Destination *Destination
+
+	Orders       []Order
+	CurrentOrder int
+	RepeatOrders bool
+	// WaitTicks counts down while the train is working on a wait order
+	WaitTicks int
+	// WaitStarted is set once WaitTicks has been set for the current order
+	WaitStarted bool
+	// Blocked is set while the train wants to move but something is in its way
+	Blocked bool
 
 	Cars []*TrainCar
 }
 
+type Destination struct {
+	X, Y int
+}
+
+// OccupierID lets a train hold a block
+func (t *Train) OccupierID() string {
+	return t.ID.String()
+}
+
+// Clone returns a deep copy of the train that is safe to hand to another goroutine
+func (t *Train) Clone() *Train {
+	clone := *t
+	if t.Destination != nil {
+		dest := *t.Destination
+		clone.Destination = &dest
+	}
+	clone.Orders = append([]Order(nil), t.Orders...)
+	clone.Cars = make([]*TrainCar, len(t.Cars))
+	for i, c := range t.Cars {
+		car := *c
+		clone.Cars[i] = &car
+	}
+	return &clone
+}
+
+// Lead returns the car at the front in the direction of travel
+func (t *Train) Lead() *TrainCar {
+	if t.IsReversing {
+		return t.Cars[len(t.Cars)-1]
+	}
+	return t.Cars[0]
+}
+
+// TravelDir returns the direction the lead car is about to move in
+func (t *Train) TravelDir() types.Dir {
+	if t.IsReversing {
+		return types.OppositeDir(t.Lead().Direction)
+	}
+	return t.Lead().Direction
+}
+
+// SetTravelDir points the lead car in the direction it should move next
+func (t *Train) SetTravelDir(d types.Dir) {
+	if t.IsReversing {
+		d = types.OppositeDir(d)
+	}
+	t.Lead().Direction = d
+}
+
 type CarType uint8
 
 const (
@@ -23,6 +91,19 @@ const (
 	CarTypePassenger
 )
 
+func (c CarType) String() string {
+	switch c {
+	case CarTypeLocomotive:
+		return "Locomotive"
+	case CarTypeCargo:
+		return "Cargo"
+	case CarTypePassenger:
+		return "Passenger"
+	default:
+		return "Unknown"
+	}
+}
+
 type TrainCar struct {
 	X, Y      int
 	Direction types.Dir
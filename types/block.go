@@ -12,7 +12,7 @@ type BlockID uuid.UUID
 // Only one train is allowed to be inside of a block at a time
 type Block struct {
 	ID         BlockID
-	OccupiedBy Occupier
+	OccupiedBy Occupier `json:"-"`
 }
 
 func NewBlock() *Block {
@@ -23,5 +23,5 @@ func NewBlock() *Block {
 
 // Occupier is an entity that occupies a block
 type Occupier interface {
-	ID() string
+	OccupierID() string
 }
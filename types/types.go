@@ -13,8 +13,30 @@ const (
 	TileWater
 	TileTree
 	TileMountain
+
+	// NumTileTypes is how many tile types there are, keep it last
+	NumTileTypes = iota
 )
 
+func (t TileType) String() string {
+	switch t {
+	case TileGrass:
+		return "Grass"
+	case TileTrack:
+		return "Track"
+	case TileIron:
+		return "Iron"
+	case TileWater:
+		return "Water"
+	case TileTree:
+		return "Tree"
+	case TileMountain:
+		return "Mountain"
+	default:
+		return "Unknown"
+	}
+}
+
 type Dir uint8
 
 const (
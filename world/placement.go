@@ -0,0 +1,128 @@
+package world
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/danharasymiw/bit-rail/types"
+)
+
+// Neighbour returns the position one tile away in the given direction
+func (p Pos) Neighbour(d types.Dir) Pos {
+	switch d {
+	case types.DirNorth:
+		return Pos{X: p.X, Y: p.Y + 1}
+	case types.DirSouth:
+		return Pos{X: p.X, Y: p.Y - 1}
+	case types.DirEast:
+		return Pos{X: p.X + 1, Y: p.Y}
+	case types.DirWest:
+		return Pos{X: p.X - 1, Y: p.Y}
+	default:
+		return p
+	}
+}
+
+// DirTo returns the direction from p to an adjacent tile, false if the tiles
+// aren't next to each other
+func (p Pos) DirTo(other Pos) (types.Dir, bool) {
+	for d := types.Dir(types.DirNorth); d <= types.DirWest; d <<= 1 {
+		if p.Neighbour(d) == other {
+			return d, true
+		}
+	}
+	return types.DirNone, false
+}
+
+// Buildable reports whether track can be laid on the tile at pos
+func (w *World) Buildable(pos Pos) bool {
+	if !w.InBounds(pos) {
+		return false
+	}
+	switch w.TileAt(pos).Type {
+	case types.TileGrass, types.TileTree, types.TileTrack:
+		return true
+	default:
+		return false
+	}
+}
+
+// PlanTrackPath works out the track direction each tile along the path needs
+// so the path joins up, merged with any track already on or pointing into
+// those tiles. Nothing in the world is changed
+func (w *World) PlanTrackPath(path []Pos) (map[Pos]types.Dir, error) {
+	if len(path) < 2 {
+		return nil, fmt.Errorf("a track path needs at least two tiles")
+	}
+
+	plan := make(map[Pos]types.Dir, len(path))
+	for i, pos := range path {
+		if !w.Buildable(pos) {
+			return nil, fmt.Errorf("can't build track at %d,%d", pos.X, pos.Y)
+		}
+		if _, ok := plan[pos]; !ok {
+			plan[pos] = types.DirNone
+			if track, ok := w.Tracks[pos]; ok {
+				plan[pos] = track.Direction
+			}
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev := path[i-1]
+		d, ok := prev.DirTo(pos)
+		if !ok {
+			return nil, fmt.Errorf("%d,%d and %d,%d aren't next to each other", prev.X, prev.Y, pos.X, pos.Y)
+		}
+		if i >= 2 && path[i-2] == pos {
+			return nil, fmt.Errorf("track can't double back on itself at %d,%d", prev.X, prev.Y)
+		}
+		plan[prev] |= d
+		plan[pos] |= types.OppositeDir(d)
+	}
+
+	// Join up with any dead ends that already point into the path
+	for pos := range plan {
+		for d := types.Dir(types.DirNorth); d <= types.DirWest; d <<= 1 {
+			if neighbour, ok := w.Tracks[pos.Neighbour(d)]; ok && neighbour.Direction&types.OppositeDir(d) != 0 {
+				plan[pos] |= d
+			}
+		}
+	}
+	return plan, nil
+}
+
+// ApplyTrackPlan lays the track from a plan, keeping any signals already on
+// those tiles. It returns the positions that changed
+func (w *World) ApplyTrackPlan(plan map[Pos]types.Dir) []Pos {
+	changed := make([]Pos, 0, len(plan))
+	for pos, dir := range plan {
+		existing, ok := w.Tracks[pos]
+		if ok && existing.Direction == dir {
+			continue
+		}
+
+		track := &types.Track{Direction: dir}
+		if ok && existing.HasSignal && dir&existing.SignalDir != 0 {
+			track.HasSignal, track.SignalDir = true, existing.SignalDir
+		}
+		w.AddTrack(pos, track)
+		changed = append(changed, pos)
+	}
+
+	slices.SortFunc(changed, func(a, b Pos) int {
+		return cmp.Or(cmp.Compare(a.Y, b.Y), cmp.Compare(a.X, b.X))
+	})
+	return changed
+}
+
+// PlaceTrackPath plans and lays track along the path in one go
+func (w *World) PlaceTrackPath(path []Pos) ([]Pos, error) {
+	plan, err := w.PlanTrackPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return w.ApplyTrackPlan(plan), nil
+}
@@ -0,0 +1,125 @@
+package world
+
+import (
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/types"
+)
+
+func TestPlanTrackPathTurnsCorners(t *testing.T) {
+	w := New(10, 10)
+	plan, err := w.PlanTrackPath([]Pos{{X: 1, Y: 1}, {X: 2, Y: 1}, {X: 2, Y: 2}})
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+
+	want := map[Pos]types.Dir{
+		{X: 1, Y: 1}: types.DirEast,
+		{X: 2, Y: 1}: types.DirWest | types.DirNorth,
+		{X: 2, Y: 2}: types.DirSouth,
+	}
+	if len(plan) != len(want) {
+		t.Fatalf("planned %d tiles, want %d", len(plan), len(want))
+	}
+	for pos, dir := range want {
+		if plan[pos] != dir {
+			t.Errorf("%v: got %s, want %s", pos, plan[pos], dir)
+		}
+	}
+	if len(w.Tracks) != 0 {
+		t.Error("planning laid track")
+	}
+}
+
+func TestPlanTrackPathJoinsExistingTrack(t *testing.T) {
+	w := New(10, 10)
+	// A dead end pointing east into where the path starts
+	w.AddTrack(Pos{X: 0, Y: 1}, &types.Track{Direction: types.DirEast})
+	// Track the path crosses
+	w.AddTrack(Pos{X: 2, Y: 1}, &types.Track{Direction: types.DirNorth | types.DirSouth})
+
+	plan, err := w.PlanTrackPath([]Pos{{X: 1, Y: 1}, {X: 2, Y: 1}, {X: 3, Y: 1}})
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if got, want := plan[Pos{X: 1, Y: 1}], types.Dir(types.DirWest|types.DirEast); got != want {
+		t.Errorf("start: got %s, want %s joined to the dead end", got, want)
+	}
+	if got, want := plan[Pos{X: 2, Y: 1}], types.Dir(types.DirNorth|types.DirSouth|types.DirEast|types.DirWest); got != want {
+		t.Errorf("crossing: got %s, want %s", got, want)
+	}
+}
+
+func TestPlanTrackPathRejects(t *testing.T) {
+	w := New(10, 10)
+	w.Tiles[5][5].Type = types.TileWater
+
+	tests := []struct {
+		name string
+		path []Pos
+	}{
+		{"too short", []Pos{{X: 1, Y: 1}}},
+		{"gap", []Pos{{X: 1, Y: 1}, {X: 3, Y: 1}}},
+		{"diagonal", []Pos{{X: 1, Y: 1}, {X: 2, Y: 2}}},
+		{"doubles back", []Pos{{X: 1, Y: 1}, {X: 2, Y: 1}, {X: 1, Y: 1}}},
+		{"water", []Pos{{X: 4, Y: 5}, {X: 5, Y: 5}}},
+		{"off the world", []Pos{{X: 9, Y: 1}, {X: 10, Y: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := w.PlanTrackPath(tt.path); err == nil {
+				t.Error("planned a path that should have been rejected")
+			}
+		})
+	}
+}
+
+func TestApplyTrackPlanKeepsSignals(t *testing.T) {
+	w := New(10, 10)
+	w.AddTrack(Pos{X: 2, Y: 1}, &types.Track{
+		Direction: types.DirEast | types.DirWest,
+		HasSignal: true,
+		SignalDir: types.DirEast,
+	})
+
+	changed, err := w.PlaceTrackPath([]Pos{{X: 2, Y: 0}, {X: 2, Y: 1}, {X: 2, Y: 2}})
+	if err != nil {
+		t.Fatalf("place: %v", err)
+	}
+	if len(changed) != 3 {
+		t.Errorf("changed %d tiles, want 3", len(changed))
+	}
+	track := w.Tracks[Pos{X: 2, Y: 1}]
+	if !track.HasSignal || track.SignalDir != types.DirEast {
+		t.Error("signal was lost when the track was extended")
+	}
+	if w.TileAt(Pos{X: 2, Y: 2}).Type != types.TileTrack {
+		t.Error("tile wasn't turned into track")
+	}
+
+	// Laying the same path again changes nothing
+	if changed, _ := w.PlaceTrackPath([]Pos{{X: 2, Y: 0}, {X: 2, Y: 1}, {X: 2, Y: 2}}); len(changed) != 0 {
+		t.Errorf("relaying changed %d tiles, want 0", len(changed))
+	}
+}
+
+func TestNeighbour(t *testing.T) {
+	pos := Pos{X: 3, Y: 3}
+	for d, want := range map[types.Dir]Pos{
+		types.DirNorth: {X: 3, Y: 4},
+		types.DirSouth: {X: 3, Y: 2},
+		types.DirEast:  {X: 4, Y: 3},
+		types.DirWest:  {X: 2, Y: 3},
+		types.DirNone:  pos,
+	} {
+		if got := pos.Neighbour(d); got != want {
+			t.Errorf("%s: got %v, want %v", d, got, want)
+		}
+		if d == types.DirNone {
+			continue
+		}
+		if back, ok := want.DirTo(pos); !ok || back != types.OppositeDir(d) {
+			t.Errorf("%s: direction back is %s, want %s", d, back, types.OppositeDir(d))
+		}
+	}
+}
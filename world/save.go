@@ -0,0 +1,239 @@
+package world
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/google/uuid"
+)
+
+const (
+	saveMagic = "BRSV"
+	// SaveVersion is bumped whenever the layout of saveFile changes. Older
+	// versions need a case in Load to keep them loading
+	SaveVersion uint16 = 1
+)
+
+// saveFile is the on disk layout of a world. It is gob encoded and gzipped
+// after a small uncompressed header holding the magic and version
+type saveFile struct {
+	Width, Height int
+	// Tiles holds one TileType per tile, row by row
+	Tiles  []byte
+	Tracks []savedTrack
+	Blocks []savedBlock
+	Trains []*trains.Train
+}
+
+type savedTrack struct {
+	X, Y      int
+	Direction types.Dir
+	HasSignal bool
+	SignalDir types.Dir
+	// Block is an index into saveFile.Blocks, -1 if the block hasn't been calculated
+	Block int
+}
+
+type savedBlock struct {
+	ID types.BlockID
+	// OccupiedBy is the ID of the train holding the block, uuid.Nil if free
+	OccupiedBy uuid.UUID
+}
+
+// Save writes the world to wr in the current save format
+func (w *World) Save(wr io.Writer) error {
+	sf := saveFile{
+		Width:  w.Width,
+		Height: w.Height,
+		Tiles:  make([]byte, 0, w.Width*w.Height),
+		Tracks: make([]savedTrack, 0, len(w.Tracks)),
+		Trains: w.Trains,
+	}
+	for _, row := range w.Tiles {
+		for _, tile := range row {
+			sf.Tiles = append(sf.Tiles, byte(tile.Type))
+		}
+	}
+
+	blockIndex := make(map[*types.Block]int)
+	for pos, track := range w.Tracks {
+		st := savedTrack{
+			X:         pos.X,
+			Y:         pos.Y,
+			Direction: track.Direction,
+			HasSignal: track.HasSignal,
+			SignalDir: track.SignalDir,
+			Block:     -1,
+		}
+		if track.Block != nil {
+			idx, ok := blockIndex[track.Block]
+			if !ok {
+				idx = len(sf.Blocks)
+				blockIndex[track.Block] = idx
+
+				sb := savedBlock{ID: track.Block.ID}
+				if track.Block.OccupiedBy != nil {
+					if id, err := uuid.Parse(track.Block.OccupiedBy.OccupierID()); err == nil {
+						sb.OccupiedBy = id
+					}
+				}
+				sf.Blocks = append(sf.Blocks, sb)
+			}
+			st.Block = idx
+		}
+		sf.Tracks = append(sf.Tracks, st)
+	}
+
+	if _, err := io.WriteString(wr, saveMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(wr, binary.LittleEndian, SaveVersion); err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(wr)
+	if err := gob.NewEncoder(zw).Encode(&sf); err != nil {
+		zw.Close()
+		return fmt.Errorf("encoding world: %w", err)
+	}
+	return zw.Close()
+}
+
+// Load reads a world written by Save
+func Load(r io.Reader) (*World, error) {
+	header := make([]byte, len(saveMagic))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("reading save header: %w", err)
+	}
+	if string(header) != saveMagic {
+		return nil, fmt.Errorf("not a bit-rail save file")
+	}
+
+	var version uint16
+	if err := binary.Read(r, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("reading save version: %w", err)
+	}
+
+	switch version {
+	case 1:
+		return loadV1(r)
+	default:
+		return nil, fmt.Errorf("unsupported save version %d, this build supports up to %d", version, SaveVersion)
+	}
+}
+
+func loadV1(r io.Reader) (*World, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("opening save data: %w", err)
+	}
+	defer zr.Close()
+
+	var sf saveFile
+	if err := gob.NewDecoder(zr).Decode(&sf); err != nil {
+		return nil, fmt.Errorf("decoding world: %w", err)
+	}
+	if sf.Width <= 0 || sf.Height <= 0 || len(sf.Tiles) != sf.Width*sf.Height {
+		return nil, fmt.Errorf("save has %d tiles for a %dx%d world", len(sf.Tiles), sf.Width, sf.Height)
+	}
+
+	w := New(sf.Width, sf.Height)
+	for i, tileType := range sf.Tiles {
+		w.Tiles[i/sf.Width][i%sf.Width] = &types.Tile{Type: types.TileType(tileType)}
+	}
+
+	for _, t := range sf.Trains {
+		w.AddTrain(t)
+	}
+
+	blocks := make([]*types.Block, len(sf.Blocks))
+	for i, sb := range sf.Blocks {
+		blocks[i] = &types.Block{ID: sb.ID}
+		if sb.OccupiedBy != uuid.Nil {
+			if t := w.TrainByID(sb.OccupiedBy); t != nil {
+				blocks[i].OccupiedBy = t
+			}
+		}
+	}
+
+	for _, st := range sf.Tracks {
+		pos := Pos{X: st.X, Y: st.Y}
+		if !w.InBounds(pos) {
+			return nil, fmt.Errorf("track at %d,%d is outside the world", pos.X, pos.Y)
+		}
+		track := &types.Track{
+			Direction: st.Direction,
+			HasSignal: st.HasSignal,
+			SignalDir: st.SignalDir,
+		}
+		if st.Block >= 0 && st.Block < len(blocks) {
+			track.Block = blocks[st.Block]
+		}
+		w.Tracks[pos] = track
+	}
+	return w, nil
+}
+
+// SaveFile writes the world to the file at path. The save goes to a temporary
+// file first and is renamed over path, so a crash part way through never
+// leaves a corrupt save behind
+func (w *World) SaveFile(path string) error {
+	return writeFile(path, w.Save)
+}
+
+// WriteSaveFile writes a save that was already made with Save to path, the
+// same way SaveFile does
+func WriteSaveFile(path string, save []byte) error {
+	return writeFile(path, func(wr io.Writer) error {
+		_, err := wr.Write(save)
+		return err
+	})
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	if err := write(bw); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// LoadFile reads a world from the file at path
+func LoadFile(path string) (*World, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Load(bufio.NewReader(f))
+}
@@ -0,0 +1,150 @@
+package world
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/google/uuid"
+)
+
+func saveTestWorld() *World {
+	w := New(12, 8)
+	w.Tiles[0][0].Type = types.TileWater
+	w.Tiles[7][11].Type = types.TileMountain
+
+	block := types.NewBlock()
+	for x := 1; x <= 4; x++ {
+		w.AddTrack(Pos{X: x, Y: 2}, &types.Track{Direction: types.DirEast | types.DirWest, Block: block})
+	}
+	w.AddTrack(Pos{X: 5, Y: 2}, &types.Track{
+		Direction: types.DirWest,
+		HasSignal: true,
+		SignalDir: types.DirWest,
+	})
+
+	t := &trains.Train{
+		ID:           uuid.New(),
+		IsMoving:     true,
+		Speed:        120,
+		Orders:       []trains.Order{{Type: trains.OrderGoTo, X: 4, Y: 2}, {Type: trains.OrderWait, Ticks: 30}},
+		CurrentOrder: 1,
+		RepeatOrders: true,
+		WaitTicks:    12,
+		WaitStarted:  true,
+		Cars: []*trains.TrainCar{
+			{X: 2, Y: 2, Direction: types.DirEast, Type: trains.CarTypeLocomotive},
+			{X: 1, Y: 2, Direction: types.DirEast, Type: trains.CarTypeCargo},
+		},
+	}
+	w.AddTrain(t)
+	block.OccupiedBy = t
+	return w
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	w := saveTestWorld()
+
+	var buf bytes.Buffer
+	if err := w.Save(&buf); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if loaded.Width != w.Width || loaded.Height != w.Height {
+		t.Fatalf("loaded a %dx%d world, want %dx%d", loaded.Width, loaded.Height, w.Width, w.Height)
+	}
+	for y := range w.Height {
+		for x := range w.Width {
+			if got, want := loaded.Tiles[y][x].Type, w.Tiles[y][x].Type; got != want {
+				t.Errorf("tile %d,%d: got %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	if len(loaded.Tracks) != len(w.Tracks) {
+		t.Fatalf("loaded %d tracks, want %d", len(loaded.Tracks), len(w.Tracks))
+	}
+	for pos, track := range w.Tracks {
+		got := loaded.Tracks[pos]
+		if got == nil || got.Direction != track.Direction || got.HasSignal != track.HasSignal || got.SignalDir != track.SignalDir {
+			t.Errorf("track at %v: got %+v, want %+v", pos, got, track)
+		}
+	}
+
+	// Tracks sharing a block still share one, and it is still held by the
+	// train that was loaded rather than the one that was saved
+	block := loaded.Tracks[Pos{X: 1, Y: 2}].Block
+	if block == nil || block.ID != w.Tracks[Pos{X: 1, Y: 2}].Block.ID {
+		t.Fatal("block wasn't kept")
+	}
+	if loaded.Tracks[Pos{X: 4, Y: 2}].Block != block {
+		t.Error("tracks in the same block were loaded into different blocks")
+	}
+	if loaded.Tracks[Pos{X: 5, Y: 2}].Block != nil {
+		t.Error("track without a block was given one")
+	}
+
+	if len(loaded.Trains) != 1 {
+		t.Fatalf("loaded %d trains, want 1", len(loaded.Trains))
+	}
+	got, want := loaded.Trains[0], w.Trains[0]
+	if block.OccupiedBy != got {
+		t.Error("block isn't held by the loaded train")
+	}
+	if got.ID != want.ID || got.Speed != want.Speed || !got.IsMoving || got.CurrentOrder != want.CurrentOrder ||
+		!got.RepeatOrders || got.WaitTicks != want.WaitTicks || !got.WaitStarted || !slices.Equal(got.Orders, want.Orders) {
+		t.Errorf("train: got %+v, want %+v", got, want)
+	}
+	if len(got.Cars) != len(want.Cars) {
+		t.Fatalf("loaded %d cars, want %d", len(got.Cars), len(want.Cars))
+	}
+	for i := range want.Cars {
+		if *got.Cars[i] != *want.Cars[i] {
+			t.Errorf("car %d: got %+v, want %+v", i, *got.Cars[i], *want.Cars[i])
+		}
+	}
+	for _, car := range got.Cars {
+		if !loaded.OccupiedAt(Pos{X: car.X, Y: car.Y}) {
+			t.Errorf("car at %d,%d doesn't occupy its tile", car.X, car.Y)
+		}
+	}
+}
+
+func TestSaveFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "world.sav")
+	w := saveTestWorld()
+	if err := w.SaveFile(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	loaded, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(loaded.Tracks) != len(w.Tracks) || len(loaded.Trains) != len(w.Trains) {
+		t.Errorf("loaded %d tracks and %d trains, want %d and %d", len(loaded.Tracks), len(loaded.Trains), len(w.Tracks), len(w.Trains))
+	}
+}
+
+func TestLoadRejectsOtherFiles(t *testing.T) {
+	if _, err := Load(strings.NewReader("not a save")); err == nil {
+		t.Error("loaded something that isn't a save")
+	}
+
+	var buf bytes.Buffer
+	if err := saveTestWorld().Save(&buf); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	data := buf.Bytes()
+	data[len(saveMagic)] = 0xff
+	if _, err := Load(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "unsupported save version") {
+		t.Errorf("got %v, want an unsupported version error", err)
+	}
+}
@@ -26,7 +26,14 @@ func NewPerlinWorld(seed, smoothness int64) *world.World {
 	w.AddTrack(world.Pos{X: 80, Y: 55}, &types.Track{Direction: types.DirSouth | types.DirWest})
 
 	w.AddTrain(&trains.Train{
-		IsMoving: true,
+		IsMoving:     true,
+		RepeatOrders: true,
+		Orders: []trains.Order{
+			{Type: trains.OrderGoTo, X: 90, Y: 50},
+			{Type: trains.OrderWait, Ticks: 30},
+			{Type: trains.OrderGoTo, X: 50, Y: 20},
+			{Type: trains.OrderWait, Ticks: 30},
+		},
 		Cars: []*trains.TrainCar{
 			{X: 50, Y: 20, Type: trains.CarTypeLocomotive, Direction: types.DirWest},
 			{X: 51, Y: 20, Type: trains.CarTypeCargo, Direction: types.DirWest},
@@ -64,7 +64,7 @@ func IntersectingLoopsTestWorld() *world.World {
 	w.AddTrack(world.Pos{X: 10, Y: 10}, &types.Track{Direction: types.DirNorth | types.DirSouth | types.DirEast | types.DirWest})
 	w.AddTrack(world.Pos{X: 15, Y: 9}, &types.Track{Direction: types.DirNorth | types.DirSouth | types.DirEast | types.DirWest})
 
-	w.Trains = append(w.Trains, &trains.Train{
+	w.AddTrain(&trains.Train{
 		IsMoving: true,
 		Cars: []*trains.TrainCar{
 			{Type: trains.CarTypeLocomotive, X: 13, Y: 12, Direction: types.DirEast},
@@ -1,9 +1,56 @@
 package test_worlds
 
-import "github.com/danharasymiw/bit-rail/world"
+import (
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
+	"github.com/danharasymiw/bit-rail/world"
+)
 
+// NewBlock is a single loop split into four blocks by signals with two
+// trains chasing each other around it
 func NewBlock() *world.World {
 	w := world.New(50, 50)
 
+	var loop []world.Pos
+	for x := 5; x < 30; x++ {
+		loop = append(loop, world.Pos{X: x, Y: 5})
+	}
+	for y := 5; y < 15; y++ {
+		loop = append(loop, world.Pos{X: 30, Y: y})
+	}
+	for x := 30; x > 5; x-- {
+		loop = append(loop, world.Pos{X: x, Y: 15})
+	}
+	for y := 15; y >= 5; y-- {
+		loop = append(loop, world.Pos{X: 5, Y: y})
+	}
+	if _, err := w.PlaceTrackPath(loop); err != nil {
+		panic(err)
+	}
+
+	// Signals
+	w.AddTrack(world.Pos{X: 18, Y: 5}, &types.Track{Direction: types.DirEast | types.DirWest, HasSignal: true, SignalDir: types.DirEast})
+	w.AddTrack(world.Pos{X: 30, Y: 10}, &types.Track{Direction: types.DirNorth | types.DirSouth, HasSignal: true, SignalDir: types.DirNorth})
+	w.AddTrack(world.Pos{X: 18, Y: 15}, &types.Track{Direction: types.DirEast | types.DirWest, HasSignal: true, SignalDir: types.DirWest})
+	w.AddTrack(world.Pos{X: 5, Y: 10}, &types.Track{Direction: types.DirNorth | types.DirSouth, HasSignal: true, SignalDir: types.DirSouth})
+
+	w.AddTrain(&trains.Train{
+		IsMoving: true,
+		Cars: []*trains.TrainCar{
+			{Type: trains.CarTypeLocomotive, X: 10, Y: 5, Direction: types.DirEast},
+			{Type: trains.CarTypeCargo, X: 9, Y: 5, Direction: types.DirEast},
+			{Type: trains.CarTypeCargo, X: 8, Y: 5, Direction: types.DirEast},
+		},
+	})
+	w.AddTrain(&trains.Train{
+		IsMoving: true,
+		Cars: []*trains.TrainCar{
+			{Type: trains.CarTypeLocomotive, X: 25, Y: 15, Direction: types.DirWest},
+			{Type: trains.CarTypeCargo, X: 26, Y: 15, Direction: types.DirWest},
+			{Type: trains.CarTypeCargo, X: 27, Y: 15, Direction: types.DirWest},
+			{Type: trains.CarTypeCargo, X: 28, Y: 15, Direction: types.DirWest},
+		},
+	})
+
 	return w
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/danharasymiw/bit-rail/trains"
 	"github.com/danharasymiw/bit-rail/types"
+	"github.com/google/uuid"
 )
 
 const ChunkSize = 64
@@ -39,6 +40,10 @@ func New(width, height int) *World {
 	return w
 }
 
+func (w *World) InBounds(pos Pos) bool {
+	return pos.X >= 0 && pos.X < w.Width && pos.Y >= 0 && pos.Y < w.Height
+}
+
 // TileAt exists incase we decide to switch to a 1D array for the world
 func (w *World) TileAt(pos Pos) *types.Tile {
 	return w.Tiles[pos.Y][pos.X]
@@ -73,9 +78,14 @@ func (p *Pos) UnmarshalText(data []byte) error {
 	return nil
 }
 
+// Chunk is a ChunkSize x ChunkSize section of the world along with the
+// tracks and trains inside it
 type Chunk struct {
-	Pos   Pos
-	Tiles []*types.Tile
+	Pos    Pos
+	Tiles  []*types.Tile
+	Tracks map[Pos]*types.Track
+	// Trains holds every train with at least one car inside the chunk
+	Trains []*trains.Train
 }
 
 func (w *World) ChunkAt(chunkPos Pos) *Chunk {
@@ -91,7 +101,8 @@ func (w *World) ChunkAt(chunkPos Pos) *Chunk {
 
 				// If this tile has a track, include it in the tracks map using position
 				if track, exists := w.Tracks[Pos{X: x, Y: y}]; exists {
-					tracks[Pos{X: x, Y: y}] = track
+					trackCopy := *track
+					tracks[Pos{X: x, Y: y}] = &trackCopy
 				}
 			} else {
 				// For out-of-bounds tiles, create a default grass tile
@@ -99,9 +110,22 @@ func (w *World) ChunkAt(chunkPos Pos) *Chunk {
 			}
 		}
 	}
+
+	chunkTrains := make([]*trains.Train, 0)
+	for _, t := range w.Trains {
+		for _, c := range t.Cars {
+			if TileToChunkPos(Pos{X: c.X, Y: c.Y}) == chunkPos {
+				chunkTrains = append(chunkTrains, t.Clone())
+				break
+			}
+		}
+	}
+
 	return &Chunk{
-		Pos:   chunkPos,
-		Tiles: tiles,
+		Pos:    chunkPos,
+		Tiles:  tiles,
+		Tracks: tracks,
+		Trains: chunkTrains,
 	}
 }
 
@@ -136,9 +160,27 @@ func (w *World) AddTrack(pos Pos, track *types.Track) {
 	w.Tracks[pos] = track
 }
 
+// RemoveTrack takes the track up, leaving grass behind
+func (w *World) RemoveTrack(pos Pos) {
+	w.Tiles[pos.Y][pos.X] = &types.Tile{Type: types.TileGrass}
+	delete(w.Tracks, pos)
+}
+
 func (w *World) AddTrain(t *trains.Train) {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
 	w.Trains = append(w.Trains, t)
 	for _, c := range t.Cars {
 		w.SetOccupied(Pos{X: c.X, Y: c.Y})
 	}
 }
+
+func (w *World) TrainByID(id uuid.UUID) *trains.Train {
+	for _, t := range w.Trains {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
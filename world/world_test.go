@@ -0,0 +1,93 @@
+package world
+
+import (
+	"testing"
+
+	"github.com/danharasymiw/bit-rail/trains"
+	"github.com/danharasymiw/bit-rail/types"
+)
+
+func TestChunkCarriesTracksAndTrains(t *testing.T) {
+	w := New(2*ChunkSize, ChunkSize+10)
+	inside := Pos{X: 5, Y: 5}
+	w.AddTrack(inside, &types.Track{Direction: types.DirEast | types.DirWest, HasSignal: true, SignalDir: types.DirEast})
+	w.AddTrack(Pos{X: ChunkSize + 1, Y: 5}, &types.Track{Direction: types.DirNorth | types.DirSouth})
+
+	home := &trains.Train{Cars: []*trains.TrainCar{{X: 5, Y: 5}, {X: 4, Y: 5}}}
+	// Straddles the edge between the first two chunks
+	straddling := &trains.Train{Cars: []*trains.TrainCar{{X: ChunkSize, Y: 8}, {X: ChunkSize - 1, Y: 8}}}
+	away := &trains.Train{Cars: []*trains.TrainCar{{X: ChunkSize + 5, Y: 20}}}
+	for _, train := range []*trains.Train{home, straddling, away} {
+		w.AddTrain(train)
+	}
+
+	chunk := w.ChunkAt(Pos{})
+	if len(chunk.Tiles) != ChunkSize*ChunkSize {
+		t.Fatalf("chunk has %d tiles, want %d", len(chunk.Tiles), ChunkSize*ChunkSize)
+	}
+	if len(chunk.Tracks) != 1 {
+		t.Fatalf("chunk has %d tracks, want just the one inside it", len(chunk.Tracks))
+	}
+	if got := chunk.Tracks[inside]; got == nil || *got != *w.Tracks[inside] {
+		t.Errorf("got track %+v, want %+v", got, w.Tracks[inside])
+	}
+
+	ids := map[string]bool{}
+	for _, train := range chunk.Trains {
+		ids[train.OccupierID()] = true
+	}
+	if len(chunk.Trains) != 2 || !ids[home.OccupierID()] || !ids[straddling.OccupierID()] {
+		t.Errorf("got %d trains, want the one inside and the one across the edge", len(chunk.Trains))
+	}
+	next := w.ChunkAt(Pos{X: 1})
+	if len(next.Trains) != 2 {
+		t.Errorf("next chunk has %d trains, want the one across the edge and the one inside", len(next.Trains))
+	}
+}
+
+func TestChunkIsACopy(t *testing.T) {
+	w := New(ChunkSize, ChunkSize)
+	pos := Pos{X: 1, Y: 1}
+	w.AddTrack(pos, &types.Track{Direction: types.DirEast | types.DirWest})
+	train := &trains.Train{
+		Orders:      []trains.Order{{Type: trains.OrderWait, Ticks: 3}},
+		Destination: &trains.Destination{X: 1, Y: 1},
+		Cars:        []*trains.TrainCar{{X: 1, Y: 1}},
+	}
+	w.AddTrain(train)
+
+	chunk := w.ChunkAt(Pos{})
+	chunk.Tracks[pos].Direction = types.DirNorth
+	sent := chunk.Trains[0]
+	sent.Cars[0].X = 9
+	sent.Orders[0].Ticks = 10
+	sent.Destination.X = 9
+
+	if w.Tracks[pos].Direction != types.DirEast|types.DirWest {
+		t.Error("changing the chunk's track changed the world")
+	}
+	if train.Cars[0].X != 1 || train.Orders[0].Ticks != 3 || train.Destination.X != 1 {
+		t.Error("changing the chunk's train changed the world")
+	}
+}
+
+func TestChunkPastTheEdge(t *testing.T) {
+	w := New(10, 10)
+	for y := range 10 {
+		for x := range 10 {
+			w.Tiles[y][x].Type = types.TileWater
+		}
+	}
+
+	chunk := w.ChunkAt(Pos{})
+	for i, tile := range chunk.Tiles {
+		x, y := i%ChunkSize, i/ChunkSize
+		want := types.TileGrass
+		if x < 10 && y < 10 {
+			want = types.TileWater
+		}
+		if tile.Type != want {
+			t.Fatalf("tile %d,%d is %v, want %v", x, y, tile.Type, want)
+		}
+	}
+}